@@ -3,6 +3,7 @@ package collector
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -43,6 +44,14 @@ type DataCollector interface {
 	)
 }
 
+// SupplementalDataCollector is an optional interface that data collectors may
+// implement when a single execution produces more than the list of data
+// returned from Run. It is called after Run succeeds, and returns additional
+// lists of data, keyed by the name under which they should be stored.
+type SupplementalDataCollector interface {
+	Supplemental() map[string][]interface{}
+}
+
 // Collector is an execution-scoped object encapsulating the entire collection
 // process.
 type Collector struct {
@@ -143,31 +152,9 @@ func (f *Collector) Run(ctx context.Context) (err error) {
 
 	log.Info().Msg("Starting new fetching process")
 
-	fullData := make(map[string][]interface{}, len(f.dataCollectors))
-
-	log.Debug().Int("amount", len(f.dataCollectors)).Msg("Running Kubernetes collectors")
-
-	for _, dc := range f.dataCollectors {
-		keyName, data, err := dc.Run(ctx, f.conf)
-		if err != nil {
-			if keyName == "helm_releases" {
-				log.Warn().Err(err).Msg("Failed fetching helm releases")
-				fullData[keyName] = data
-				continue
-			}
-			return fmt.Errorf("%s collector failed: %w", dc.Source(), err)
-		}
-
-		fullData[keyName] = data
-	}
-
-	for _, filter := range f.dataFilters {
-		log.Debug().Msg("Running filter")
-		err := filter(ctx, fullData)
-		if err != nil {
-			log.Warn().Err(err).Msg("Filter failed")
-			continue
-		}
+	fullData, err := f.Collect(ctx, &log)
+	if err != nil {
+		return err
 	}
 
 	if f.conf.DryRun {
@@ -197,14 +184,109 @@ func (f *Collector) Run(ctx context.Context) (err error) {
 		return fmt.Errorf("failed sending k8s objects tree to Infralight: %w", err)
 	}
 
+	k8sGraph, err := k8stree.GetK8sGraph(fullData["k8s_objects"], k8stree.LoadRelationshipRules(f.conf))
+	if err != nil {
+		return fmt.Errorf("failed getting k8s objects graph: %w", err)
+	}
+
+	err = f.sendK8sGraph(fetchingId, k8sGraph)
+	if err != nil {
+		return fmt.Errorf("failed sending k8s objects graph to Infralight: %w", err)
+	}
+
+	var snapshot *k8stree.Snapshot
+	if f.conf.TreeSnapshotPath != "" {
+		snapshot = k8stree.NewSnapshot(k8sTree, k8sGraph)
+		err = f.sendTreeChanges(fetchingId, snapshot)
+		if err != nil {
+			return fmt.Errorf("failed sending k8s objects tree changes to Infralight: %w", err)
+		}
+	}
+
+	err = f.sendPaged(fetchingId, "deprecations", "deprecatedApis", fullData["k8s_deprecated_apis"])
+	if err != nil {
+		return fmt.Errorf("failed sending deprecated APIs report to Infralight: %w", err)
+	}
+
+	err = f.sendPaged(fetchingId, "drift", "helmDrift", fullData["helm_drift"])
+	if err != nil {
+		return fmt.Errorf("failed sending Helm drift to Infralight: %w", err)
+	}
+
+	err = f.sendPaged(fetchingId, "rbac", "serviceAccountPermissions", fullData["k8s_rbac_permissions"])
+	if err != nil {
+		return fmt.Errorf("failed sending ServiceAccount permissions to Infralight: %w", err)
+	}
+
+	err = f.sendPaged(fetchingId, "rbac", "groupPermissions", fullData["k8s_rbac_group_permissions"])
+	if err != nil {
+		return fmt.Errorf("failed sending group permissions to Infralight: %w", err)
+	}
+
+	// unavailable APIs are sent even if no objects were collected, e.g. when
+	// discovery failed for every API group
+	err = f.sendPaged(fetchingId, "discovery", "unavailableApis", fullData["k8s_unavailable_apis"])
+	if err != nil {
+		return fmt.Errorf("failed sending unavailable APIs to Infralight: %w", err)
+	}
+
 	err = f.sendK8sObjects(fetchingId, fullData["k8s_objects"])
 	if err != nil {
 		return fmt.Errorf("failed sending objects to Infralight: %w", err)
 	}
 
+	// the snapshot is only persisted once the fetching is complete, so the
+	// changes of a failed fetching are sent again with the next one
+	if snapshot != nil {
+		err = snapshot.Save(f.conf.TreeSnapshotPath)
+		if err != nil {
+			log.Warn().Err(err).Str("path", f.conf.TreeSnapshotPath).Msg("Failed saving k8s objects tree snapshot")
+		}
+	}
+
 	return nil
 }
 
+// Collect executes all data collectors and filters, and returns the collected
+// data by key, without sending anything to the Infralight App Server. Progress
+// is logged to the provided logger, e.g. one carrying the fetching's fields.
+func (f *Collector) Collect(ctx context.Context, log *zerolog.Logger) (map[string][]interface{}, error) {
+	fullData := make(map[string][]interface{}, len(f.dataCollectors))
+
+	log.Debug().Int("amount", len(f.dataCollectors)).Msg("Running Kubernetes collectors")
+
+	for _, dc := range f.dataCollectors {
+		keyName, data, err := dc.Run(ctx, f.conf)
+		if err != nil {
+			if keyName == "helm_releases" {
+				log.Warn().Err(err).Msg("Failed fetching helm releases")
+				fullData[keyName] = data
+				continue
+			}
+			return nil, fmt.Errorf("%s collector failed: %w", dc.Source(), err)
+		}
+
+		fullData[keyName] = data
+
+		if sdc, ok := dc.(SupplementalDataCollector); ok {
+			for key, supplemental := range sdc.Supplemental() {
+				fullData[key] = append(fullData[key], supplemental...)
+			}
+		}
+	}
+
+	for _, filter := range f.dataFilters {
+		log.Debug().Msg("Running filter")
+		err := filter(ctx, f.conf, fullData)
+		if err != nil {
+			log.Warn().Err(err).Msg("Filter failed")
+			continue
+		}
+	}
+
+	return fullData, nil
+}
+
 func (f *Collector) authenticate() (err error) {
 	var credentials struct {
 		Token     string `json:"access_token"`
@@ -534,3 +616,129 @@ func (f *Collector) sendK8sTree(fetchingId string, data []k8stree.ObjectsTree) e
 		Msg("Sent k8s objects trees page successfully")
 	return nil
 }
+
+// sendK8sGraph sends the nodes and edges of the objects relationship graph to
+// the graph fetching endpoint, each paged separately
+func (f *Collector) sendK8sGraph(fetchingId string, graph *k8stree.Graph) error {
+	nodes := make([]interface{}, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		nodes[i] = node
+	}
+
+	edges := make([]interface{}, len(graph.Edges))
+	for i, edge := range graph.Edges {
+		edges[i] = edge
+	}
+
+	err := f.sendPaged(fetchingId, "graph", "nodes", nodes)
+	if err != nil {
+		return err
+	}
+
+	return f.sendPaged(fetchingId, "graph", "edges", edges)
+}
+
+// sendTreeChanges sends the changes between the last persisted snapshot of
+// the objects trees and the provided snapshot (see k8stree.DiffSnapshots).
+// Nothing is sent if there is no previous snapshot, e.g. on the first
+// fetching, or if it cannot be read.
+func (f *Collector) sendTreeChanges(fetchingId string, snapshot *k8stree.Snapshot) error {
+	previous, err := k8stree.LoadSnapshot(f.conf.TreeSnapshotPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			f.conf.Log.Info().
+				Str("FetchingId", fetchingId).
+				Msg("No previous k8s objects tree snapshot, skipping tree changes")
+		} else {
+			f.conf.Log.Warn().Err(err).
+				Str("FetchingId", fetchingId).
+				Str("path", f.conf.TreeSnapshotPath).
+				Msg("Failed loading previous k8s objects tree snapshot, skipping tree changes")
+		}
+		return nil
+	}
+
+	changes := k8stree.DiffSnapshots(previous, snapshot)
+	f.conf.Log.Info().
+		Str("FetchingId", fetchingId).
+		Int("changes", len(changes)).
+		Msg("Finished comparing k8s objects tree to previous snapshot")
+
+	items := make([]interface{}, len(changes))
+	for i, change := range changes {
+		items[i] = change
+	}
+
+	return f.sendPaged(fetchingId, "diff", "treeChanges", items)
+}
+
+// sendPaged sends a list of collected data items to the fetching endpoint with
+// the provided path suffix (e.g. "deprecations" for
+// /integrations/k8s/<clusterID>/fetching/deprecations). Items are split into
+// pages according to the configured page size, and each page is sent in the
+// request body under the provided key.
+func (f *Collector) sendPaged(fetchingId, path, key string, data []interface{}) error {
+	if len(data) == 0 {
+		f.conf.Log.Debug().
+			Str("FetchingId", fetchingId).
+			Str("key", key).
+			Msg("Nothing to send to Infralight")
+		return nil
+	}
+
+	totalBytes := 0
+	var chunks [][]interface{}
+	var items []interface{}
+	for idx, item := range data {
+		bytes, err := json.Marshal(item)
+		if err != nil {
+			f.conf.Log.Err(err).
+				Str("key", key).
+				Msg("failed to send item")
+		} else {
+			totalBytes += len(bytes)
+			items = append(items, item)
+		}
+
+		if totalBytes > f.conf.PageSize*1000 || idx == len(data)-1 {
+			chunks = append(chunks, items)
+			items = []interface{}{}
+			totalBytes = 0
+		}
+	}
+
+	concurrentGoroutines := make(chan struct{}, f.conf.MaxGoRoutines)
+	g, _ := errgroup.WithContext(context.Background())
+	for _, chunkItems := range chunks {
+		concurrentGoroutines <- struct{}{}
+
+		routineItems := chunkItems
+		g.Go(func() error {
+			defer func() {
+				<-concurrentGoroutines
+			}()
+			body := make(map[string]interface{}, 2)
+			body["fetchingId"] = fetchingId
+			body[key] = routineItems
+			err := f.client.
+				NewRequest("POST", fmt.Sprintf("/integrations/k8s/%s/fetching/%s", f.clusterID, path)).
+				ExpectedStatus(http.StatusNoContent).
+				JSONBody(body).
+				Run()
+			if err != nil {
+				log.Err(err).Str("ClusterId", f.clusterID).Str("FetchingId", fetchingId).
+					Int("ResourcesInPage", len(routineItems)).
+					Str("key", key).
+					Msg("Error sending resources to server")
+				return err
+			}
+			log.Info().Str("ClusterId", f.clusterID).Str("FetchingId", fetchingId).
+				Int("ResourcesInPage", len(routineItems)).
+				Str("key", key).
+				Msg("Sent page successfully")
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+	"k8s.io/client-go/rest"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// unavailableCollector is a data collector that collects no objects, as
+// discovery failed for all API groups
+type unavailableCollector struct{}
+
+func (c unavailableCollector) Source() string {
+	return "Unavailable API Server"
+}
+
+func (c unavailableCollector) Run(context.Context, *config.Config) (string, []interface{}, error) {
+	return "k8s_objects", nil, nil
+}
+
+func (c unavailableCollector) Supplemental() map[string][]interface{} {
+	return map[string][]interface{}{
+		"k8s_unavailable_apis": {
+			k8s.UnavailableAPI{GroupVersion: "metrics.k8s.io/v1beta1", Error: "the server is currently unable to handle the request"},
+		},
+	}
+}
+
+func TestRunWithoutObjects(t *testing.T) {
+	logger := zerolog.Nop()
+
+	var lock sync.Mutex
+	bodies := make(map[string]map[string]interface{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/account/access_keys/login":
+			_, _ = io.WriteString(w, `{"access_token": "token", "expires_in": 3600, "token_type": "Bearer"}`)
+			return
+		case "/api/v1/namespaces/kube-system":
+			_, _ = io.WriteString(w, `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "kube-system", "uid": "cluster-uid"}}`)
+			return
+		}
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = reader
+		}
+
+		var decoded map[string]interface{}
+		_ = json.NewDecoder(body).Decode(&decoded)
+
+		lock.Lock()
+		bodies[r.Method+" "+r.URL.Path] = decoded
+		lock.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	conf := &config.Config{
+		Log:           &logger,
+		Endpoint:      server.URL,
+		LoginEndpoint: server.URL,
+		PageSize:      500,
+		MaxGoRoutines: 1,
+	}
+
+	err := New("test", &rest.Config{Host: server.URL}, conf, unavailableCollector{}).Run(context.Background())
+	assert.MustBeNil(t, err, "error must be nil")
+
+	body, ok := bodies["POST /integrations/k8s/test/fetching/discovery"]
+	assert.MustBeTrue(t, ok, "unavailable APIs must be sent without objects")
+	assert.DeepEqual(t, []interface{}{
+		map[string]interface{}{
+			"groupVersion": "metrics.k8s.io/v1beta1",
+			"error":        "the server is currently unable to handle the request",
+		},
+	}, body["unavailableApis"], "unavailable APIs must match")
+
+	_, ok = bodies["POST /integrations/k8s/test/fetching/objects"]
+	assert.False(t, ok, "no objects must be sent")
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -41,6 +43,28 @@ var (
 	// Server).
 	ErrEndpoint = errors.New("Infralight endpoint must be provided")
 
+	// DefaultHelmSensitiveKeys is the list of regular expressions matched
+	// (case-insensitively) against the keys of Helm release values, to find
+	// values that must be redacted before they are sent to Firefly
+	DefaultHelmSensitiveKeys = []string{
+		"passw(or)?d",
+		"secret",
+		"token",
+		"credential",
+		"api[-_]?key",
+		"access[-_]?key",
+		"private[-_]?key",
+		"connection[-_]?string",
+	}
+
+	// DefaultRelationshipRulePacks is the list of built-in relationship rule
+	// packs enabled by default
+	DefaultRelationshipRulePacks = []string{
+		"cert-manager",
+		"crossplane",
+		"strimzi",
+	}
+
 	// DefaultResourceTypes is the list of Kubernetes resources that are
 	// to be collected by default (i.e. if there is no configuration at all)
 	DefaultResourceTypes = []string{
@@ -131,6 +155,11 @@ type Config struct {
 	// that the collector is allowed to collect
 	AllowedResources map[string]bool
 
+	// MetadataOnlyResources is a list of resource types (named by their plural
+	// resource name) for which only object metadata is collected. The full
+	// objects are never fetched from the API server for these types
+	MetadataOnlyResources map[string]bool
+
 	// OverrideUniqueClusterId is a boolean indicating whether to override the master url of the Kubernetes integration
 	OverrideUniqueClusterId bool
 
@@ -139,6 +168,89 @@ type Config struct {
 
 	// MaxGoRoutines is an integer for max goroutines running at ones sending the chunks.
 	MaxGoRoutines int
+
+	// CollectAllVersions is a boolean indicating whether to record, for every
+	// collected resource type, all versions served by the API server, and the
+	// API versions used by each object's managed fields. This allows Firefly to
+	// flag objects relying on deprecated API versions
+	CollectAllVersions bool
+
+	// CollectTypeSchemas is a boolean indicating whether to include the
+	// OpenAPI v3 schema of every custom resource type in the list of types
+	// supported by the cluster
+	CollectTypeSchemas bool
+
+	// HelmHistoryMax is the maximum number of revisions to collect for every
+	// Helm release (newest first). Zero disables collection of release history
+	HelmHistoryMax int
+
+	// HelmPendingTimeout is the duration after which a Helm release that is
+	// still in a pending state (pending-install, pending-upgrade or
+	// pending-rollback) is considered stuck
+	HelmPendingTimeout time.Duration
+
+	// HelmKeepChartFiles is a boolean indicating whether to keep the templates,
+	// files and values schema of each Helm release's chart. These are dropped
+	// by default, as they make for very large payloads
+	HelmKeepChartFiles bool
+
+	// HelmSensitiveKeys is a list of regular expressions matched
+	// (case-insensitively) against the keys of Helm release values (both the
+	// user-supplied values and the chart's default values). Matching values are
+	// redacted according to HelmRedaction
+	HelmSensitiveKeys []string
+
+	// HelmRedaction is the method used to redact sensitive Helm values: "mask"
+	// replaces them with a fixed string, "hash" replaces them with their
+	// SHA-256 hash (so changes can still be detected), and "none" disables
+	// redaction. Unless disabled, the data of Secrets rendered in release
+	// manifests is redacted as well
+	HelmRedaction string
+
+	// HelmRepositoryIndexes is a list of paths to Helm repository index files
+	// (index.yaml), against which the charts of collected releases are
+	// checked to find newer, deprecated and yanked versions. Relative paths
+	// are relative to ConfigDir. Every path may be followed by whitespace and
+	// the URL of the repository, which is otherwise inferred from the chart
+	// URLs in the index
+	HelmRepositoryIndexes []string
+
+	// HelmSource is the source from which Helm releases are read: "sdk" reads
+	// them from the Helm storage backend using the Helm SDK, while "objects"
+	// decodes them from the Helm storage secrets and configmaps already
+	// collected by the Kubernetes collector, avoiding a second list of all
+	// secrets in the cluster
+	HelmSource string
+
+	// HelmValuesDiffOnly is a boolean indicating whether to only keep the
+	// computed values of each Helm release that differ from the chart's
+	// default values, rather than the user-supplied values and the chart's
+	// default values
+	HelmValuesDiffOnly bool
+
+	// MaxDiscoveryFailures is the maximum number of API group-versions that
+	// may fail discovery (e.g. due to a broken aggregated APIService) before
+	// the collector run is failed. Up to this number, the failing APIs are
+	// skipped and reported as unavailable. A negative value means discovery
+	// failures never fail the run
+	MaxDiscoveryFailures int
+
+	// RelationshipRules is a list of paths to files declaring relationship
+	// rules between objects (see k8stree.RelationshipRule), which are added
+	// to the object graph. Relative paths are relative to ConfigDir
+	RelationshipRules []string
+
+	// RelationshipRulePacks is the list of built-in relationship rule packs
+	// to enable, named by operator (e.g. "cert-manager"). The value "none"
+	// disables all packs
+	RelationshipRulePacks []string
+
+	// TreeSnapshotPath is the path of a file where a snapshot of the objects
+	// trees is persisted after every fetching (see k8stree.Snapshot). When
+	// set, the changes since the previous snapshot are sent with every
+	// fetching. The file should be on a persistent volume. Relative paths are
+	// relative to the working directory
+	TreeSnapshotPath string
 }
 
 // LoadConfig creates a new configuration object. A logger object, a file-system
@@ -206,12 +318,37 @@ func LoadConfig(
 		conf.AllowedResources[resource] = true
 	}
 
+	conf.MetadataOnlyResources = make(map[string]bool)
+	for _, resource := range parseMultiple(conf.etcConfig("collector.metadataOnlyResources"), nil) {
+		conf.MetadataOnlyResources[resource] = true
+	}
+
 	conf.OverrideUniqueClusterId = parseBool(
 		conf.etcConfig("collector.OverrideUniqueClusterId"),
 		false,
 	)
 	conf.PageSize = parseInt(conf.etcConfig("collector.PageSize"), 500)
 	conf.MaxGoRoutines = parseInt(conf.etcConfig("collector.MaxGoRoutines"), 50)
+	conf.CollectAllVersions = parseBool(conf.etcConfig("collector.collectAllVersions"), false)
+	conf.CollectTypeSchemas = parseBool(conf.etcConfig("collector.collectTypeSchemas"), false)
+	conf.HelmHistoryMax = parseInt(conf.etcConfig("collector.helmHistoryMax"), 10)
+	conf.HelmPendingTimeout = parseDuration(conf.etcConfig("collector.helmPendingTimeout"), 30*time.Minute)
+	conf.HelmKeepChartFiles = parseBool(conf.etcConfig("collector.helmKeepChartFiles"), false)
+	conf.HelmSensitiveKeys = parseMultiple(conf.etcConfig("collector.helmSensitiveKeys"), DefaultHelmSensitiveKeys)
+	conf.HelmRedaction = parseOne(conf.etcConfig("collector.helmRedaction"), "mask")
+	conf.HelmValuesDiffOnly = parseBool(conf.etcConfig("collector.helmValuesDiffOnly"), false)
+	conf.HelmRepositoryIndexes = parseMultiple(conf.etcConfig("collector.helmRepositoryIndexes"), nil)
+	conf.HelmSource = parseOne(conf.etcConfig("collector.helmSource"), "sdk")
+	conf.MaxDiscoveryFailures = parseInt(conf.etcConfig("collector.maxDiscoveryFailures"), 5)
+	conf.RelationshipRules = parseMultiple(conf.etcConfig("collector.relationshipRules"), nil)
+	conf.RelationshipRulePacks = parseMultiple(
+		conf.etcConfig("collector.relationshipRulePacks"),
+		DefaultRelationshipRulePacks,
+	)
+	if len(conf.RelationshipRulePacks) == 1 && strings.TrimSpace(conf.RelationshipRulePacks[0]) == "none" {
+		conf.RelationshipRulePacks = nil
+	}
+	conf.TreeSnapshotPath = parseOne(conf.etcConfig("collector.treeSnapshotPath"), "")
 
 	return conf, nil
 }
@@ -233,8 +370,8 @@ func (conf *Config) backwardsCompatibilityResources() {
 // IgnoreNamespace accepts a namespace and returns a boolean value indicating
 // whether the namespace should be ignored
 func (conf *Config) IgnoreNamespace(ns string) bool {
-	if conf.Namespace != "" && ns != conf.Namespace {
-		return false
+	if conf.Namespace != "" {
+		return ns != conf.Namespace
 	}
 
 	if len(conf.IgnoreNamespaces) > 0 {
@@ -262,6 +399,15 @@ func parseInt(str string, defVal int) int {
 	return asInt
 }
 
+func parseDuration(str string, defVal time.Duration) time.Duration {
+	str = strings.TrimSpace(str)
+	asDuration, err := time.ParseDuration(str)
+	if err != nil {
+		return defVal
+	}
+	return asDuration
+}
+
 func parseMultiple(str string, defVal []string) []string {
 	str = strings.TrimSpace(str)
 	if str == "" {
@@ -295,6 +441,16 @@ func includes(list []string, value string) bool {
 	return false
 }
 
+// ReadFile reads a file from the configuration's file system. Relative paths
+// are relative to the configuration directory.
+func (conf *Config) ReadFile(name string) ([]byte, error) {
+	if !path.IsAbs(name) {
+		name = path.Join(conf.ConfigDir, name)
+	}
+
+	return fs.ReadFile(conf.FS, strings.TrimPrefix(path.Clean(name), "/"))
+}
+
 func (conf *Config) etcConfig(name string) string {
 	data, err := fs.ReadFile(
 		conf.FS,
@@ -5,6 +5,7 @@ import (
 	"os"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/jgroeneveld/trial/assert"
 	"github.com/rs/zerolog"
@@ -49,6 +50,24 @@ func TestLoadConfig(t *testing.T) {
 						"\nconfigmaps\nreplicationcontrollers\nsecrets\nservices\nserviceaccounts\npods\nnodes\napplications\n",
 					),
 				},
+				"etc/config/collector.metadataOnlyResources": &fstest.MapFile{
+					Data: []byte("events\nleases\n"),
+				},
+				"etc/config/collector.collectAllVersions": &fstest.MapFile{
+					Data: []byte("true\n"),
+				},
+				"etc/config/collector.helmPendingTimeout": &fstest.MapFile{
+					Data: []byte("1h\n"),
+				},
+				"etc/config/collector.relationshipRules": &fstest.MapFile{
+					Data: []byte("relationships.yaml\n"),
+				},
+				"etc/config/collector.relationshipRulePacks": &fstest.MapFile{
+					Data: []byte("cert-manager\n"),
+				},
+				"etc/config/collector.treeSnapshotPath": &fstest.MapFile{
+					Data: []byte("/var/lib/collector/snapshot.json\n"),
+				},
 			},
 			expConfig: Config{
 				Log:              &logger,
@@ -69,9 +88,23 @@ func TestLoadConfig(t *testing.T) {
 					"nodes":                  true,
 					"applications":           true,
 				},
+				MetadataOnlyResources: map[string]bool{
+					"events": true,
+					"leases": true,
+				},
 				OverrideUniqueClusterId: false,
+				CollectAllVersions:      true,
 				PageSize:                500,
 				MaxGoRoutines:           50,
+				HelmHistoryMax:          10,
+				HelmPendingTimeout:      time.Hour,
+				HelmSensitiveKeys:       DefaultHelmSensitiveKeys,
+				HelmRedaction:           "mask",
+				HelmSource:              "sdk",
+				MaxDiscoveryFailures:    5,
+				RelationshipRules:       []string{"relationships.yaml"},
+				RelationshipRulePacks:   []string{"cert-manager"},
+				TreeSnapshotPath:        "/var/lib/collector/snapshot.json",
 			},
 		},
 	}
@@ -106,3 +139,40 @@ func TestLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestIgnoreNamespace(t *testing.T) {
+	var tests = []struct {
+		name      string
+		conf      Config
+		namespace string
+		expIgnore bool
+	}{
+		{
+			name:      "When no namespaces are configured, nothing should be ignored",
+			namespace: "default",
+		},
+		{
+			name:      "When watching a namespace, it should not be ignored",
+			conf:      Config{Namespace: "apps"},
+			namespace: "apps",
+		},
+		{
+			name:      "When watching a namespace, other namespaces should be ignored",
+			conf:      Config{Namespace: "apps", IgnoreNamespaces: []string{"kube-system"}},
+			namespace: "default",
+			expIgnore: true,
+		},
+		{
+			name:      "When ignoring namespaces, they should be ignored",
+			conf:      Config{IgnoreNamespaces: []string{"kube-system"}},
+			namespace: "kube-system",
+			expIgnore: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expIgnore, test.conf.IgnoreNamespace(test.namespace), "result must match")
+		})
+	}
+}
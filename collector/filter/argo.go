@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
 	"github.com/infralight/k8s-collector/collector/k8s"
 	"github.com/rs/zerolog/log"
 	"github.com/thoas/go-funk"
@@ -14,7 +16,19 @@ import (
 	argotime "helm.sh/helm/v3/pkg/time"
 )
 
-func ArgoFilter(ctx context.Context, data map[string][]interface{}) error {
+// ArgoFilter creates Helm releases from Argo CD Applications, so that they
+// are reported along with releases installed by Helm. The chart versions of
+// Applications installing charts from Helm repositories are compared with the
+// repository indexes listed in config.Config.HelmRepositoryIndexes, like those
+// of Helm releases.
+func ArgoFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	var indexes []*helm.RepositoryIndex
+	indexesLoaded := false
+
 	for _, value := range data["k8s_objects"] {
 		obj, ok := value.(k8s.KubernetesObject)
 		if !ok {
@@ -25,8 +39,8 @@ func ArgoFilter(ctx context.Context, data map[string][]interface{}) error {
 			continue
 		}
 
-		meta, ok := obj.Object.(map[string]interface{})
-		if !ok {
+		meta := obj.Object
+		if meta == nil {
 			continue
 		}
 
@@ -114,7 +128,25 @@ func ArgoFilter(ctx context.Context, data map[string][]interface{}) error {
 			r.Manifest = yaml.String()
 		}
 
-		data["helm_releases"] = append(data["helm_releases"], r)
+		rel := &helm.Release{
+			Release:   r,
+			ChartInfo: helm.NewChartInfo(r.Chart),
+		}
+		if chartName, _ := funk.Get(meta, "spec.source.chart").(string); chartName != "" {
+			// the application installs a chart from a Helm repository, so its
+			// version can be checked against the repository's index
+			rel.ChartInfo.Name = chartName
+			repoURL, _ := funk.Get(meta, "spec.source.repoURL").(string)
+			rel.ChartInfo.Repository = helm.NormalizeRepository(repoURL)
+
+			if !indexesLoaded {
+				indexes = helm.LoadRepositoryIndexes(conf)
+				indexesLoaded = true
+			}
+			rel.CheckChart(indexes)
+		}
+
+		data["helm_releases"] = append(data["helm_releases"], rel)
 
 		log.Info().Str("name", name).Msg("Found Helm chart in Argo app")
 	}
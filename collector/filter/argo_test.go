@@ -0,0 +1,79 @@
+package filter
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func TestArgoFilter(t *testing.T) {
+	logger := zerolog.Nop()
+
+	application := func(name string, source map[string]interface{}) k8s.KubernetesObject {
+		return k8s.KubernetesObject{
+			Group:     "argoproj.io",
+			Kind:      "Application",
+			Namespace: "argocd",
+			Name:      name,
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": name, "namespace": "argocd"},
+				"spec":     map[string]interface{}{"source": source},
+				"status": map[string]interface{}{
+					"sourceType": "Helm",
+					"health":     map[string]interface{}{"status": "Healthy"},
+				},
+			},
+		}
+	}
+
+	data := map[string][]interface{}{
+		"k8s_objects": {
+			application("cache", map[string]interface{}{
+				"repoURL":        "https://charts.example.com/stable",
+				"chart":          "redis",
+				"targetRevision": "16.0.1",
+			}),
+			application("web", map[string]interface{}{
+				"repoURL":        "https://github.com/example/web.git",
+				"path":           "charts/web",
+				"targetRevision": "main",
+			}),
+		},
+	}
+
+	conf := &config.Config{
+		Log: &logger,
+		FS: fstest.MapFS{"etc/config/index.yaml": &fstest.MapFile{Data: []byte(`apiVersion: v1
+entries:
+  redis:
+    - name: redis
+      version: 16.1.0
+      urls: [https://charts.example.com/stable/redis-16.1.0.tgz]
+    - name: redis
+      version: 16.0.1
+      urls: [https://charts.example.com/stable/redis-16.0.1.tgz]
+`)}},
+		ConfigDir:             config.DefaultConfigDir,
+		HelmRepositoryIndexes: []string{"index.yaml"},
+	}
+
+	err := ArgoFilter(context.Background(), conf, data)
+	assert.MustBeNil(t, err, "error must be nil")
+	assert.MustBeEqual(t, 2, len(data["helm_releases"]), "a release must be created for every application")
+
+	cache := data["helm_releases"][0].(*helm.Release)
+	assert.Equal(t, "redis", cache.ChartInfo.Name, "chart name must match")
+	assert.MustBeTrue(t, cache.ChartStatus != nil, "chart from a Helm repository must be checked")
+	assert.Equal(t, "16.1.0", cache.ChartStatus.LatestVersion, "latest version must match")
+	assert.True(t, cache.ChartStatus.Outdated, "chart must be outdated")
+
+	web := data["helm_releases"][1].(*helm.Release)
+	assert.True(t, web.ChartStatus == nil, "chart from a Git repository must not be checked")
+}
@@ -0,0 +1,82 @@
+package filter
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// CollectionStatusNotCollected is the collection status of supported types
+// that the Kubernetes collector did not attempt to collect, e.g. because their
+// API group failed discovery
+const CollectionStatusNotCollected = "notCollected"
+
+// CollectionStatusFilter annotates every entry of the supported types list
+// ("k8s_types") with the outcome of collecting objects of that type: its
+// collection status, the reason it wasn't collected, and the number and total
+// size of the collected objects. Objects are only collected in the preferred
+// version of each type, so entries of other versions carry the outcome of the
+// preferred version, which is recorded in "collectedVersion". Subresource
+// entries (e.g. "deployments/scale") are left as-is, as objects are never
+// collected through subresources.
+func CollectionStatusFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	if _, ok := data["k8s_collection_status"]; !ok {
+		return nil
+	}
+
+	byGroupResource := make(map[schema.GroupResource]k8s.CollectionStatus, len(data["k8s_collection_status"]))
+	for _, value := range data["k8s_collection_status"] {
+		status, ok := value.(k8s.CollectionStatus)
+		if !ok {
+			continue
+		}
+		byGroupResource[schema.GroupResource{Group: status.Group, Resource: status.Resource}] = status
+	}
+
+	for _, value := range data["k8s_types"] {
+		entry, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if _, isSubresource := entry["parentResource"]; isSubresource {
+			continue
+		}
+
+		apiVersion, _ := entry["apiVersion"].(string)
+		resource, _ := entry["resource"].(string)
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			continue
+		}
+
+		status, ok := byGroupResource[gv.WithResource(resource).GroupResource()]
+		if !ok {
+			entry["collectionStatus"] = CollectionStatusNotCollected
+			continue
+		}
+
+		entry["collectionStatus"] = status.Status
+		entry["collectedVersion"] = status.Version
+		entry["objectCount"] = status.Count
+		entry["objectBytes"] = status.Bytes
+		if status.Reason != "" {
+			entry["collectionReason"] = status.Reason
+		}
+		if status.Code != 0 {
+			entry["collectionCode"] = status.Code
+		}
+		if status.MetadataOnly {
+			entry["metadataOnly"] = true
+		}
+	}
+
+	return nil
+}
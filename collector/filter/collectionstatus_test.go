@@ -0,0 +1,67 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func TestCollectionStatusFilter(t *testing.T) {
+	logger := zerolog.Nop()
+
+	deployments := map[string]interface{}{"apiVersion": "apps/v1", "resource": "deployments"}
+	deploymentsScale := map[string]interface{}{"apiVersion": "apps/v1", "resource": "deployments/scale", "parentResource": "deployments"}
+	betaDeployments := map[string]interface{}{"apiVersion": "apps/v1beta1", "resource": "deployments"}
+	secrets := map[string]interface{}{"apiVersion": "v1", "resource": "secrets"}
+	widgets := map[string]interface{}{"apiVersion": "example.com/v1", "resource": "widgets"}
+	gadgets := map[string]interface{}{"apiVersion": "example.com/v1", "resource": "gadgets"}
+
+	data := map[string][]interface{}{
+		"k8s_types": {deployments, deploymentsScale, betaDeployments, secrets, widgets, gadgets},
+		"k8s_collection_status": {
+			k8s.CollectionStatus{
+				Group:    "apps",
+				Version:  "v1",
+				Resource: "deployments",
+				Status:   k8s.CollectionStatusCollected,
+				Count:    2,
+				Bytes:    512,
+			},
+			k8s.CollectionStatus{
+				Version:  "v1",
+				Resource: "secrets",
+				Status:   k8s.CollectionStatusSkipped,
+				Reason:   "resource type is not in the list of allowed resources",
+			},
+			k8s.CollectionStatus{
+				Group:    "example.com",
+				Version:  "v1",
+				Resource: "widgets",
+				Status:   k8s.CollectionStatusFailed,
+				Reason:   "forbidden",
+				Code:     403,
+			},
+		},
+	}
+
+	err := CollectionStatusFilter(context.Background(), &config.Config{Log: &logger}, data)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	assert.Equal(t, k8s.CollectionStatusCollected, deployments["collectionStatus"], "deployments status must match")
+	assert.Equal(t, 2, deployments["objectCount"], "deployments count must match")
+	assert.Equal(t, 512, deployments["objectBytes"], "deployments size must match")
+	_, annotated := deploymentsScale["collectionStatus"]
+	assert.False(t, annotated, "subresources must not have a collection status")
+	assert.Equal(t, k8s.CollectionStatusCollected, betaDeployments["collectionStatus"], "non-preferred versions must share the status")
+	assert.Equal(t, "v1", betaDeployments["collectedVersion"], "collected version must match")
+	assert.Equal(t, k8s.CollectionStatusSkipped, secrets["collectionStatus"], "secrets status must match")
+	assert.Equal(t, "resource type is not in the list of allowed resources", secrets["collectionReason"], "secrets reason must match")
+	assert.Equal(t, k8s.CollectionStatusFailed, widgets["collectionStatus"], "widgets status must match")
+	assert.Equal(t, 403, widgets["collectionCode"], "widgets code must match")
+	assert.Equal(t, CollectionStatusNotCollected, gadgets["collectionStatus"], "gadgets must not be collected")
+}
@@ -0,0 +1,238 @@
+package filter
+
+import (
+	"context"
+	_ "embed" // required for the embedded deprecations table
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/thoas/go-funk"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/version"
+	"sigs.k8s.io/yaml"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// Sources in which usage of deprecated APIs may be found
+const (
+	UsageSourceObject          = "object"
+	UsageSourceManagedFields   = "managedFields"
+	UsageSourceHelmRelease     = "helmRelease"
+	UsageSourceArgoApplication = "argoApplication"
+)
+
+//go:embed deprecations.yaml
+var deprecationsTable []byte
+
+// deprecatedAPIs is the embedded deprecations table, indexed by group, version
+// and kind
+var deprecatedAPIs = loadDeprecatedAPIs(deprecationsTable)
+
+// DeprecatedAPI is a Kubernetes API (a kind in a specific API version) that is
+// deprecated, and is (or will be) removed in a specific Kubernetes version.
+type DeprecatedAPI struct {
+	APIVersion   string `json:"apiVersion"`
+	Kind         string `json:"kind"`
+	DeprecatedIn string `json:"deprecatedIn"`
+	RemovedIn    string `json:"removedIn"`
+	Replacement  string `json:"replacement,omitempty"`
+}
+
+// DeprecatedAPIUsage is a single use of a deprecated API, by a live object or
+// its managed fields, or by a resource rendered by a Helm release or an Argo
+// application.
+type DeprecatedAPIUsage struct {
+	DeprecatedAPI
+
+	// Source is where the usage was found (one of the UsageSource constants)
+	Source string `json:"source"`
+
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	UID       string `json:"uid,omitempty"`
+
+	// ReleaseNamespace and ReleaseName identify the Helm release or Argo
+	// application rendering the resource, if any
+	ReleaseNamespace string `json:"releaseNamespace,omitempty"`
+	ReleaseName      string `json:"releaseName,omitempty"`
+
+	// Deprecated and Removed indicate whether the API is already deprecated
+	// or removed in the cluster's Kubernetes version
+	Deprecated bool `json:"deprecated"`
+	Removed    bool `json:"removed"`
+
+	// Served indicates whether the cluster still serves the API
+	Served bool `json:"served"`
+}
+
+// DeprecationReport lists all usages of deprecated or removed APIs in a
+// cluster.
+type DeprecationReport struct {
+	ClusterVersion string               `json:"clusterVersion,omitempty"`
+	Usages         []DeprecatedAPIUsage `json:"usages"`
+}
+
+// DeprecationsFilter builds an upgrade-readiness report for the cluster. It
+// finds live objects (and their managed fields), Helm releases and Argo
+// applications that rely on APIs deprecated or removed in current or upcoming
+// Kubernetes versions, according to an embedded deprecations table, and
+// stores the report under the "k8s_deprecated_apis" key.
+func DeprecationsFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	report := &DeprecationReport{Usages: []DeprecatedAPIUsage{}}
+
+	var clusterVersion *utilversion.Version
+	if len(data["k8s_server_version"]) > 0 {
+		if info, ok := data["k8s_server_version"][0].(*version.Info); ok {
+			report.ClusterVersion = info.GitVersion
+			clusterVersion, _ = utilversion.ParseGeneric(info.GitVersion)
+		}
+	}
+
+	served := make(map[schema.GroupVersionKind]bool, len(data["k8s_types"]))
+	for _, value := range data["k8s_types"] {
+		apiVersion, _ := funk.Get(value, "apiVersion").(string)
+		kind, _ := funk.Get(value, "kind").(string)
+		served[schema.FromAPIVersionAndKind(apiVersion, kind)] = true
+	}
+
+	addUsage := func(usage DeprecatedAPIUsage, apiVersion, kind string) {
+		gvk := schema.FromAPIVersionAndKind(apiVersion, kind)
+		api, ok := deprecatedAPIs[gvk]
+		if !ok {
+			return
+		}
+
+		usage.DeprecatedAPI = api
+		usage.Served = served[gvk]
+		if clusterVersion != nil {
+			usage.Deprecated = clusterVersion.AtLeast(utilversion.MustParseGeneric(api.DeprecatedIn))
+			usage.Removed = clusterVersion.AtLeast(utilversion.MustParseGeneric(api.RemovedIn))
+		}
+		report.Usages = append(report.Usages, usage)
+	}
+
+	argoApps := make(map[string]bool)
+	for _, value := range data["k8s_objects"] {
+		obj, ok := value.(k8s.KubernetesObject)
+		if !ok {
+			continue
+		}
+
+		liveUsage := DeprecatedAPIUsage{
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+			UID:       obj.UID,
+		}
+
+		liveUsage.Source = UsageSourceObject
+		addUsage(liveUsage, obj.APIVersion(), obj.Kind)
+
+		liveUsage.Source = UsageSourceManagedFields
+		for _, apiVersion := range obj.ManagedFieldsAPIVersions {
+			if apiVersion != obj.APIVersion() {
+				addUsage(liveUsage, apiVersion, obj.Kind)
+			}
+		}
+
+		if obj.Kind == "Application" && obj.Group == "argoproj.io" {
+			argoApps[fmt.Sprintf("%s/%s", obj.Namespace, obj.Name)] = true
+			addArgoUsages(obj, addUsage)
+		}
+	}
+
+	for _, value := range data["helm_releases"] {
+		rel, ok := value.(*helm.Release)
+		if !ok || argoApps[fmt.Sprintf("%s/%s", rel.Namespace, rel.Name)] {
+			// releases created from Argo applications are already covered
+			continue
+		}
+
+		for _, resource := range helm.ParseManifest(rel.Manifest) {
+			addUsage(DeprecatedAPIUsage{
+				Source:           UsageSourceHelmRelease,
+				Namespace:        resource.Namespace,
+				Name:             resource.Name,
+				ReleaseNamespace: rel.Namespace,
+				ReleaseName:      rel.Name,
+			}, resource.APIVersion, resource.Kind)
+		}
+	}
+
+	sort.SliceStable(report.Usages, func(i, j int) bool {
+		a, b := report.Usages[i], report.Usages[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Kind < b.Kind
+	})
+
+	data["k8s_deprecated_apis"] = []interface{}{report}
+
+	log.Info().
+		Int("usages", len(report.Usages)).
+		Str("clusterVersion", report.ClusterVersion).
+		Msg("Finished checking usage of deprecated APIs")
+
+	return nil
+}
+
+// addArgoUsages finds deprecated APIs among the resources managed by an Argo
+// application
+func addArgoUsages(
+	app k8s.KubernetesObject,
+	addUsage func(usage DeprecatedAPIUsage, apiVersion, kind string),
+) {
+	resources, ok := funk.Get(app.Object, "status.resources").([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, res := range resources {
+		group, _ := funk.Get(res, "group").(string)
+		ver, _ := funk.Get(res, "version").(string)
+		kind, _ := funk.Get(res, "kind").(string)
+		namespace, _ := funk.Get(res, "namespace").(string)
+		name, _ := funk.Get(res, "name").(string)
+
+		addUsage(DeprecatedAPIUsage{
+			Source:           UsageSourceArgoApplication,
+			Namespace:        namespace,
+			Name:             name,
+			ReleaseNamespace: app.Namespace,
+			ReleaseName:      app.Name,
+		}, schema.GroupVersion{Group: group, Version: ver}.String(), kind)
+	}
+}
+
+func loadDeprecatedAPIs(table []byte) map[schema.GroupVersionKind]DeprecatedAPI {
+	var byRemoval map[string][]DeprecatedAPI
+	err := yaml.Unmarshal(table, &byRemoval)
+	if err != nil {
+		panic(fmt.Sprintf("invalid deprecations table: %s", err))
+	}
+
+	apis := make(map[schema.GroupVersionKind]DeprecatedAPI)
+	for removedIn, list := range byRemoval {
+		for _, api := range list {
+			api.RemovedIn = removedIn
+			apis[schema.FromAPIVersionAndKind(api.APIVersion, api.Kind)] = api
+		}
+	}
+
+	return apis
+}
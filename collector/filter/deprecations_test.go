@@ -0,0 +1,141 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/version"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func TestDeprecationsFilter(t *testing.T) {
+	data := map[string][]interface{}{
+		"k8s_server_version": {&version.Info{GitVersion: "v1.24.3-gke.100"}},
+		"k8s_types": {
+			map[string]interface{}{"apiVersion": "networking.k8s.io/v1", "kind": "Ingress"},
+			map[string]interface{}{"apiVersion": "policy/v1beta1", "kind": "PodDisruptionBudget"},
+			map[string]interface{}{"apiVersion": "policy/v1", "kind": "PodDisruptionBudget"},
+		},
+		"k8s_objects": {
+			k8s.KubernetesObject{
+				Group:                    "networking.k8s.io",
+				Version:                  "v1",
+				Kind:                     "Ingress",
+				Namespace:                "default",
+				Name:                     "web",
+				UID:                      "ingress-uid",
+				ManagedFieldsAPIVersions: []string{"extensions/v1beta1", "networking.k8s.io/v1"},
+			},
+			k8s.KubernetesObject{
+				Group:     "argoproj.io",
+				Version:   "v1alpha1",
+				Kind:      "Application",
+				Namespace: "argocd",
+				Name:      "jobs",
+				Object: map[string]interface{}{
+					"status": map[string]interface{}{
+						"resources": []interface{}{
+							map[string]interface{}{
+								"group":     "batch",
+								"version":   "v1beta1",
+								"kind":      "CronJob",
+								"namespace": "jobs",
+								"name":      "cleanup",
+							},
+						},
+					},
+				},
+			},
+		},
+		"helm_releases": {
+			&helm.Release{Release: &release.Release{
+				Name:      "api",
+				Namespace: "default",
+				Manifest: `---
+# Source: api/templates/pdb.yaml
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: api
+---
+# Source: api/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+`,
+			}},
+			&helm.Release{Release: &release.Release{
+				// created from the Argo application by the Argo filter
+				Name:      "jobs",
+				Namespace: "argocd",
+				Manifest: `---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: cleanup
+  namespace: jobs
+`,
+			}},
+		},
+	}
+
+	err := DeprecationsFilter(context.Background(), &config.Config{}, data)
+	assert.MustBeNil(t, err, "error must be nil")
+	assert.MustBeEqual(t, 1, len(data["k8s_deprecated_apis"]), "a single report must be created")
+
+	report := data["k8s_deprecated_apis"][0].(*DeprecationReport)
+	assert.Equal(t, "v1.24.3-gke.100", report.ClusterVersion, "cluster version must match")
+	assert.DeepEqual(t, []DeprecatedAPIUsage{
+		{
+			DeprecatedAPI: DeprecatedAPI{
+				APIVersion:   "batch/v1beta1",
+				Kind:         "CronJob",
+				DeprecatedIn: "1.21",
+				RemovedIn:    "1.25",
+				Replacement:  "batch/v1",
+			},
+			Source:           UsageSourceArgoApplication,
+			Namespace:        "jobs",
+			Name:             "cleanup",
+			ReleaseNamespace: "argocd",
+			ReleaseName:      "jobs",
+			Deprecated:       true,
+		},
+		{
+			DeprecatedAPI: DeprecatedAPI{
+				APIVersion:   "policy/v1beta1",
+				Kind:         "PodDisruptionBudget",
+				DeprecatedIn: "1.21",
+				RemovedIn:    "1.25",
+				Replacement:  "policy/v1",
+			},
+			Source:           UsageSourceHelmRelease,
+			Name:             "api",
+			ReleaseNamespace: "default",
+			ReleaseName:      "api",
+			Deprecated:       true,
+			Served:           true,
+		},
+		{
+			DeprecatedAPI: DeprecatedAPI{
+				APIVersion:   "extensions/v1beta1",
+				Kind:         "Ingress",
+				DeprecatedIn: "1.14",
+				RemovedIn:    "1.22",
+				Replacement:  "networking.k8s.io/v1",
+			},
+			Source:     UsageSourceManagedFields,
+			Namespace:  "default",
+			Name:       "web",
+			UID:        "ingress-uid",
+			Deprecated: true,
+			Removed:    true,
+		},
+	}, report.Usages, "usages must match")
+}
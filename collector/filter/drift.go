@@ -0,0 +1,479 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/thoas/go-funk"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// Annotations set by Helm on every object it creates or adopts
+const (
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// driftIgnoredPaths are paths in rendered objects that are never compared with
+// live objects, either because they are populated by the server or because
+// the server does not persist them as-is
+var driftIgnoredPaths = map[string]bool{
+	"status":                     true,
+	"metadata.creationTimestamp": true,
+	"metadata.namespace":         true,
+	"stringData":                 true,
+}
+
+// HelmDrift describes the differences between the resources rendered in the
+// manifest of a deployed Helm release and their live state in the cluster.
+type HelmDrift struct {
+	ReleaseNamespace string `json:"releaseNamespace"`
+	ReleaseName      string `json:"releaseName"`
+	Revision         int    `json:"revision"`
+
+	// Drifted is true if any resource changed, is missing or is extra
+	Drifted bool `json:"drifted"`
+
+	// Changed are resources whose live state differs from the manifest
+	Changed []DriftedResource `json:"changed,omitempty"`
+
+	// Missing are resources rendered in the manifest that do not exist in
+	// the cluster
+	Missing []helm.ManifestResource `json:"missing,omitempty"`
+
+	// Extra are live objects annotated as belonging to the release that are
+	// not rendered in its manifest
+	Extra []DriftedResource `json:"extra,omitempty"`
+}
+
+// DriftedResource is a live object that drifted from the manifest of the Helm
+// release it belongs to.
+type DriftedResource struct {
+	helm.ManifestResource
+
+	// UID is the unique identifier of the live object
+	UID string `json:"uid"`
+
+	// Paths are the paths of fields set in the manifest whose live values
+	// differ, e.g. "spec.template.spec.containers[name=web].image"
+	Paths []string `json:"paths,omitempty"`
+}
+
+// HelmDriftFilter detects drift between the manifests of deployed Helm
+// releases and the live objects collected from the cluster, e.g. due to
+// objects being edited manually. Only fields set in the manifest are compared,
+// so fields defaulted or populated by the server are ignored. A drift record
+// is created for every release and stored under the "helm_drift" key.
+//
+// Releases created from Argo applications are skipped, as their manifests are
+// not rendered by Helm.
+func HelmDriftFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	idx := newObjectIndex(data)
+
+	argoApps := make(map[string]bool)
+	for _, value := range data["k8s_objects"] {
+		obj, ok := value.(k8s.KubernetesObject)
+		if ok && obj.Kind == "Application" && obj.Group == "argoproj.io" {
+			argoApps[fmt.Sprintf("%s/%s", obj.Namespace, obj.Name)] = true
+		}
+	}
+
+	annotated := annotatedObjects(data["k8s_objects"])
+
+	drifts := make([]interface{}, 0, len(data["helm_releases"]))
+	drifted := 0
+	for _, value := range data["helm_releases"] {
+		rel, ok := value.(*helm.Release)
+		if !ok || rel.Release == nil || rel.Info == nil || rel.Info.Status != release.StatusDeployed {
+			continue
+		}
+		if argoApps[fmt.Sprintf("%s/%s", rel.Namespace, rel.Name)] {
+			continue
+		}
+
+		drift := releaseDrift(
+			rel,
+			idx,
+			data["k8s_objects"],
+			annotated[fmt.Sprintf("%s/%s", rel.Namespace, rel.Name)],
+		)
+		if drift.Drifted {
+			drifted++
+		}
+		drifts = append(drifts, drift)
+	}
+
+	data["helm_drift"] = drifts
+
+	log.Info().
+		Int("releases", len(drifts)).
+		Int("drifted", drifted).
+		Msg("Finished checking Helm releases for drift")
+
+	return nil
+}
+
+// annotatedObjects returns the positions of the collected objects annotated
+// as belonging to a Helm release, keyed by the release's namespace and name
+func annotatedObjects(objects []interface{}) map[string][]int {
+	positions := make(map[string][]int)
+	for pos, value := range objects {
+		obj, ok := value.(k8s.KubernetesObject)
+		if !ok || obj.Object == nil {
+			continue
+		}
+
+		annotations, _ := funk.Get(obj.Object, "metadata.annotations").(map[string]interface{})
+		name, _ := annotations[helmReleaseNameAnnotation].(string)
+		namespace, _ := annotations[helmReleaseNamespaceAnnotation].(string)
+		if name == "" {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s", namespace, name)
+		positions[key] = append(positions[key], pos)
+	}
+
+	return positions
+}
+
+// releaseDrift compares the manifest of a release with the live objects. The
+// annotated positions are those of the objects annotated as belonging to the
+// release (see annotatedObjects).
+func releaseDrift(
+	rel *helm.Release,
+	idx *objectIndex,
+	objects []interface{},
+	annotated []int,
+) *HelmDrift {
+	drift := &HelmDrift{
+		ReleaseNamespace: rel.Namespace,
+		ReleaseName:      rel.Name,
+		Revision:         rel.Version,
+	}
+
+	rendered := make(map[int]bool)
+	for _, desired := range helm.ParseManifestObjects(rel.Manifest) {
+		pos, ok := idx.resolve(desired.ManifestResource, rel.Namespace)
+		if !ok {
+			drift.Missing = append(drift.Missing, desired.ManifestResource)
+			continue
+		}
+		rendered[pos] = true
+
+		live := objects[pos].(k8s.KubernetesObject)
+		if live.Object == nil {
+			continue
+		}
+
+		desiredObject := desired.Object
+		if live.MetadataOnly {
+			// only metadata was collected for this type
+			desiredObject = map[string]interface{}{
+				"metadata": desired.Object["metadata"],
+			}
+		}
+
+		var paths []string
+		if desired.Kind == "Secret" {
+			desiredObject, paths = diffRedactedData(desiredObject, live.Object)
+		}
+
+		paths = diffFields("", desiredObject, live.Object, paths)
+		if len(paths) > 0 {
+			drift.Changed = append(drift.Changed, DriftedResource{
+				ManifestResource: desired.ManifestResource,
+				UID:              live.UID,
+				Paths:            paths,
+			})
+		}
+	}
+
+	for _, pos := range annotated {
+		if rendered[pos] {
+			continue
+		}
+
+		obj := objects[pos].(k8s.KubernetesObject)
+		drift.Extra = append(drift.Extra, DriftedResource{
+			ManifestResource: helm.ManifestResource{
+				APIVersion: obj.APIVersion(),
+				Kind:       obj.Kind,
+				Namespace:  obj.Namespace,
+				Name:       obj.Name,
+			},
+			UID: obj.UID,
+		})
+	}
+
+	drift.Drifted = len(drift.Changed) > 0 || len(drift.Missing) > 0 || len(drift.Extra) > 0
+	return drift
+}
+
+// diffRedactedData compares the data values of a Secret rendered in a
+// manifest whose values were redacted (see helm.RedactedEqual) to the live
+// Secret. It returns a copy of the desired Secret without the redacted values,
+// which cannot be compared as is, along with the paths of the redacted values
+// that differ.
+func diffRedactedData(desired, live map[string]interface{}) (map[string]interface{}, []string) {
+	data, ok := desired["data"].(map[string]interface{})
+	if !ok {
+		return desired, nil
+	}
+	liveData, _ := live["data"].(map[string]interface{})
+
+	var paths []string
+	remaining := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		liveValue, exists := liveData[key]
+		equal, redacted := helm.RedactedEqual(value, liveValue)
+		if !redacted {
+			remaining[key] = value
+			continue
+		}
+		if !exists || !equal {
+			paths = append(paths, joinPath("data", key))
+		}
+	}
+	sort.Strings(paths)
+
+	copied := make(map[string]interface{}, len(desired))
+	for key, value := range desired {
+		copied[key] = value
+	}
+	copied["data"] = remaining
+
+	return copied, paths
+}
+
+// diffFields compares the fields set in a desired value with a live value,
+// and appends the paths of all fields that differ to the provided list. Fields
+// that only exist in the live value are ignored. Items in lists whose items
+// are all named (e.g. containers, ports or environment variables) are matched
+// by name rather than position; named items only existing in the live list
+// are ignored, as they are commonly injected by admission webhooks.
+func diffFields(path string, desired, live interface{}, paths []string) []string {
+	if driftIgnoredPaths[path] {
+		return paths
+	}
+
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		liveMap, ok := live.(map[string]interface{})
+		if !ok {
+			if isEmptyValue(desired) {
+				return paths
+			}
+			return append(paths, path)
+		}
+
+		keys := make([]string, 0, len(desiredValue))
+		for key := range desiredValue {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value := desiredValue[key]
+			if value == nil {
+				// null values in manifests are equivalent to unset values
+				continue
+			}
+
+			liveValue, ok := liveMap[key]
+			if !ok {
+				if !isEmptyValue(value) && !driftIgnoredPaths[joinPath(path, key)] {
+					paths = append(paths, joinPath(path, key))
+				}
+				continue
+			}
+
+			paths = diffFields(joinPath(path, key), value, liveValue, paths)
+		}
+
+		return paths
+	case []interface{}:
+		liveList, ok := live.([]interface{})
+		if !ok {
+			if isEmptyValue(desired) {
+				return paths
+			}
+			return append(paths, path)
+		}
+
+		if desiredNames, ok := itemNames(desiredValue); ok {
+			if liveNames, ok := itemNames(liveList); ok {
+				livePositions := make(map[string]int, len(liveNames))
+				for i, name := range liveNames {
+					livePositions[name] = i
+				}
+
+				for i, name := range desiredNames {
+					itemPath := fmt.Sprintf("%s[name=%s]", path, name)
+					pos, ok := livePositions[name]
+					if !ok {
+						paths = append(paths, itemPath)
+						continue
+					}
+					paths = diffFields(itemPath, desiredValue[i], liveList[pos], paths)
+				}
+
+				return paths
+			}
+		}
+
+		if len(desiredValue) != len(liveList) {
+			return append(paths, path)
+		}
+
+		for i := range desiredValue {
+			paths = diffFields(fmt.Sprintf("%s[%d]", path, i), desiredValue[i], liveList[i], paths)
+		}
+
+		return paths
+	default:
+		if !equalScalars(desired, live) {
+			paths = append(paths, path)
+		}
+		return paths
+	}
+}
+
+func joinPath(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s[%s]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// itemNames returns the names of all items in a list, if all items are maps
+// with a unique "name" key
+func itemNames(list []interface{}) ([]string, bool) {
+	if len(list) == 0 {
+		return nil, false
+	}
+
+	names := make([]string, len(list))
+	seen := make(map[string]bool, len(list))
+	for i, item := range list {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		name, ok := itemMap["name"].(string)
+		if !ok || seen[name] {
+			return nil, false
+		}
+
+		names[i] = name
+		seen[name] = true
+	}
+
+	return names, true
+}
+
+// isEmptyValue returns true for values that the server drops when they are
+// set in a manifest, such as empty strings and empty lists
+func isEmptyValue(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return true
+	case string:
+		return typed == ""
+	case bool:
+		return !typed
+	case map[string]interface{}:
+		return len(typed) == 0
+	case []interface{}:
+		return len(typed) == 0
+	}
+
+	if number, ok := toFloat(value); ok {
+		return number == 0
+	}
+
+	return false
+}
+
+// equalScalars compares a scalar value from a manifest with a scalar value
+// from a live object. Numbers are compared regardless of their type, and
+// resource quantities are compared semantically, as the server normalizes
+// them (e.g. "1024Mi" is stored as "1Gi").
+func equalScalars(desired, live interface{}) bool {
+	if reflect.DeepEqual(desired, live) {
+		return true
+	}
+
+	desiredNumber, desiredIsNumber := toFloat(desired)
+	liveNumber, liveIsNumber := toFloat(live)
+	if desiredIsNumber && liveIsNumber {
+		return desiredNumber == liveNumber
+	}
+
+	desiredString, ok := scalarString(desired)
+	if !ok {
+		return false
+	}
+	liveString, ok := scalarString(live)
+	if !ok {
+		return false
+	}
+
+	desiredQuantity, err := resource.ParseQuantity(desiredString)
+	if err != nil {
+		return false
+	}
+	liveQuantity, err := resource.ParseQuantity(liveString)
+	if err != nil {
+		return false
+	}
+
+	return desiredQuantity.Cmp(liveQuantity) == 0
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case int:
+		return float64(typed), true
+	case int32:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case float32:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	default:
+		return 0, false
+	}
+}
+
+func scalarString(value interface{}) (string, bool) {
+	if typed, ok := value.(string); ok {
+		return typed, true
+	}
+
+	if number, ok := toFloat(value); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,253 @@
+package filter
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func TestHelmDriftFilter(t *testing.T) {
+	manifest := `---
+# Source: web/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  creationTimestamp: null
+data:
+  mode: production
+---
+# Source: web/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app.kubernetes.io/name: web
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: web
+  template:
+    spec:
+      containers:
+        - name: web
+          image: web:1.0.0
+          args: []
+          resources:
+            limits:
+              memory: 1024Mi
+---
+# Source: web/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+`
+
+	data := map[string][]interface{}{
+		"k8s_types": {
+			map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "namespaced": true},
+			map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "namespaced": true},
+			map[string]interface{}{"apiVersion": "v1", "kind": "Service", "namespaced": true},
+		},
+		"k8s_objects": {
+			k8s.KubernetesObject{
+				Version:   "v1",
+				Kind:      "ConfigMap",
+				Namespace: "apps",
+				Name:      "web",
+				UID:       "configmap-uid",
+				Object: map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata": map[string]interface{}{
+						"name":              "web",
+						"namespace":         "apps",
+						"creationTimestamp": "2021-06-01T00:00:00Z",
+					},
+					"data": map[string]interface{}{"mode": "production"},
+				},
+			},
+			k8s.KubernetesObject{
+				Group:     "apps",
+				Version:   "v1",
+				Kind:      "Deployment",
+				Namespace: "apps",
+				Name:      "web",
+				UID:       "deployment-uid",
+				Object: map[string]interface{}{
+					"apiVersion": "apps/v1",
+					"kind":       "Deployment",
+					"metadata": map[string]interface{}{
+						"name":      "web",
+						"namespace": "apps",
+						"labels":    map[string]interface{}{"app.kubernetes.io/name": "web"},
+					},
+					"spec": map[string]interface{}{
+						"replicas":             int64(5),
+						"revisionHistoryLimit": int64(10),
+						"selector": map[string]interface{}{
+							"matchLabels": map[string]interface{}{"app.kubernetes.io/name": "web"},
+						},
+						"template": map[string]interface{}{
+							"spec": map[string]interface{}{
+								"containers": []interface{}{
+									map[string]interface{}{
+										"name":                   "istio-proxy",
+										"image":                  "istio/proxyv2",
+										"terminationMessagePath": "/dev/termination-log",
+									},
+									map[string]interface{}{
+										"name":                   "web",
+										"image":                  "web:1.0.1",
+										"terminationMessagePath": "/dev/termination-log",
+										"resources": map[string]interface{}{
+											"limits": map[string]interface{}{"memory": "1Gi"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			k8s.KubernetesObject{
+				Version:   "v1",
+				Kind:      "Secret",
+				Namespace: "apps",
+				Name:      "web-extra",
+				UID:       "secret-uid",
+				Object: map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"name":      "web-extra",
+						"namespace": "apps",
+						"annotations": map[string]interface{}{
+							"meta.helm.sh/release-name":      "web",
+							"meta.helm.sh/release-namespace": "apps",
+						},
+					},
+				},
+			},
+		},
+		"helm_releases": {
+			&helm.Release{Release: &release.Release{
+				Name:      "web",
+				Namespace: "apps",
+				Version:   4,
+				Info:      &release.Info{Status: release.StatusDeployed},
+				Manifest:  manifest,
+			}},
+			&helm.Release{Release: &release.Release{
+				Name:      "old",
+				Namespace: "apps",
+				Version:   1,
+				Info:      &release.Info{Status: release.StatusUninstalled},
+				Manifest:  manifest,
+			}},
+		},
+	}
+
+	err := HelmDriftFilter(context.Background(), &config.Config{}, data)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	assert.DeepEqual(t, []interface{}{
+		&HelmDrift{
+			ReleaseNamespace: "apps",
+			ReleaseName:      "web",
+			Revision:         4,
+			Drifted:          true,
+			Changed: []DriftedResource{
+				{
+					ManifestResource: helm.ManifestResource{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+					UID:              "deployment-uid",
+					Paths: []string{
+						"spec.replicas",
+						"spec.template.spec.containers[name=web].image",
+					},
+				},
+			},
+			Missing: []helm.ManifestResource{
+				{APIVersion: "v1", Kind: "Service", Name: "web"},
+			},
+			Extra: []DriftedResource{
+				{
+					ManifestResource: helm.ManifestResource{APIVersion: "v1", Kind: "Secret", Namespace: "apps", Name: "web-extra"},
+					UID:              "secret-uid",
+				},
+			},
+		},
+	}, data["helm_drift"], "drift records must match")
+}
+
+func TestAnnotatedObjects(t *testing.T) {
+	annotated := func(name, namespace string) k8s.KubernetesObject {
+		return k8s.KubernetesObject{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{
+					"meta.helm.sh/release-name":      name,
+					"meta.helm.sh/release-namespace": namespace,
+				},
+			},
+		}}
+	}
+
+	objects := []interface{}{
+		annotated("web", "apps"),
+		k8s.KubernetesObject{Object: map[string]interface{}{"metadata": map[string]interface{}{}}},
+		annotated("web", "staging"),
+		k8s.KubernetesObject{},
+		annotated("web", "apps"),
+	}
+
+	assert.DeepEqual(t, map[string][]int{
+		"apps/web":    {0, 4},
+		"staging/web": {2},
+	}, annotatedObjects(objects), "objects must be indexed by release")
+}
+
+func TestDiffRedactedData(t *testing.T) {
+	hashed := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(`"c2FtZQ=="`)))
+
+	desired := map[string]interface{}{
+		"kind": "Secret",
+		"data": map[string]interface{}{
+			"masked":  "[REDACTED]",
+			"same":    hashed,
+			"changed": hashed,
+			"missing": "[REDACTED]",
+			"plain":   "cGxhaW4=",
+		},
+	}
+	live := map[string]interface{}{
+		"kind": "Secret",
+		"data": map[string]interface{}{
+			"masked":  "c2VjcmV0",
+			"same":    "c2FtZQ==",
+			"changed": "b3RoZXI=",
+			"plain":   "cGxhaW4=",
+		},
+	}
+
+	remaining, paths := diffRedactedData(desired, live)
+	assert.DeepEqual(t, []string{"data.changed", "data.missing"}, paths, "differing redacted values must be reported")
+	assert.DeepEqual(
+		t,
+		map[string]interface{}{"plain": "cGxhaW4="},
+		remaining["data"],
+		"values that are not redacted must be kept for comparison",
+	)
+	assert.Equal(t, 5, len(desired["data"].(map[string]interface{})), "desired secret must not be modified")
+}
@@ -1,7 +1,25 @@
 package filter
 
-import "context"
+import (
+	"context"
 
-type DataFilter func(ctx context.Context, data map[string][]interface{}) error
+	"github.com/infralight/k8s-collector/collector/config"
+)
 
-var All = []DataFilter{ArgoFilter}
+type DataFilter func(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error
+
+var All = []DataFilter{
+	CollectionStatusFilter,
+	HelmStorageFilter,
+	ArgoFilter,
+	HelmLinksFilter,
+	HelmDriftFilter,
+	APIVersionsFilter,
+	DeprecationsFilter,
+	RBACFilter,
+	HelmSizeFilter,
+}
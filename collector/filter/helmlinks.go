@@ -0,0 +1,158 @@
+package filter
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/thoas/go-funk"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// objectIdentity identifies a Kubernetes object regardless of the API version
+// in which it was collected
+type objectIdentity struct {
+	Group     string
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// kindIdentity identifies a Kubernetes object by kind, namespace and name
+// only, for resources whose group has changed between API versions (e.g.
+// Ingresses moving from "extensions" to "networking.k8s.io")
+type kindIdentity struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// objectIndex indexes the collected Kubernetes objects by their identity, so
+// that resources referenced elsewhere (e.g. in Helm release manifests) can be
+// resolved to live objects.
+type objectIndex struct {
+	// positions of objects in the list of collected objects
+	byIdentity map[objectIdentity]int
+	byKind     map[kindIdentity][]int
+
+	// whether each type is namespaced, according to the types supported by
+	// the cluster
+	namespaced map[schema.GroupKind]bool
+}
+
+func newObjectIndex(data map[string][]interface{}) *objectIndex {
+	idx := &objectIndex{
+		byIdentity: make(map[objectIdentity]int, len(data["k8s_objects"])),
+		byKind:     make(map[kindIdentity][]int, len(data["k8s_objects"])),
+		namespaced: make(map[schema.GroupKind]bool, len(data["k8s_types"])),
+	}
+
+	for _, value := range data["k8s_types"] {
+		apiVersion, _ := funk.Get(value, "apiVersion").(string)
+		kind, _ := funk.Get(value, "kind").(string)
+		namespaced, _ := funk.Get(value, "namespaced").(bool)
+		idx.namespaced[schema.FromAPIVersionAndKind(apiVersion, kind).GroupKind()] = namespaced
+	}
+
+	for i, value := range data["k8s_objects"] {
+		obj, ok := value.(k8s.KubernetesObject)
+		if !ok {
+			continue
+		}
+
+		idx.byIdentity[objectIdentity{obj.Group, obj.Kind, obj.Namespace, obj.Name}] = i
+		kindID := kindIdentity{obj.Kind, obj.Namespace, obj.Name}
+		idx.byKind[kindID] = append(idx.byKind[kindID], i)
+	}
+
+	return idx
+}
+
+// resolve finds the position of the live object matching the provided
+// resource in the list of collected objects. Resources without a namespace
+// are looked up in the provided default namespace, unless their type is known
+// to be cluster-scoped.
+func (idx *objectIndex) resolve(
+	resource helm.ManifestResource,
+	defaultNamespace string,
+) (pos int, ok bool) {
+	gk := resource.GroupVersionKind().GroupKind()
+
+	namespace := resource.Namespace
+	namespaced, known := idx.namespaced[gk]
+	switch {
+	case known && !namespaced:
+		namespace = ""
+	case namespace == "":
+		namespace = defaultNamespace
+	}
+
+	if pos, ok = idx.byIdentity[objectIdentity{gk.Group, gk.Kind, namespace, resource.Name}]; ok {
+		return pos, true
+	}
+
+	// the object may have been collected from a different group, which is
+	// only acceptable if it is unambiguous
+	if candidates := idx.byKind[kindIdentity{gk.Kind, namespace, resource.Name}]; len(candidates) == 1 {
+		return candidates[0], true
+	}
+
+	return 0, false
+}
+
+// HelmLinksFilter links the resources rendered in the manifest of every Helm
+// release (including those created from Argo applications) to the live
+// objects collected from the cluster. Releases are annotated with the UIDs of
+// their live objects and with the resources missing from the cluster, and
+// objects are annotated with the release that renders them.
+func HelmLinksFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	idx := newObjectIndex(data)
+
+	for _, value := range data["helm_releases"] {
+		rel, ok := value.(*helm.Release)
+		if !ok || rel.Release == nil {
+			continue
+		}
+
+		missing := 0
+		resources := helm.ParseManifest(rel.Manifest)
+		rel.Resources = make([]helm.LinkedResource, len(resources))
+		for i, resource := range resources {
+			rel.Resources[i].ManifestResource = resource
+
+			pos, ok := idx.resolve(resource, rel.Namespace)
+			if !ok {
+				rel.Resources[i].Missing = true
+				missing++
+				continue
+			}
+
+			obj := data["k8s_objects"][pos].(k8s.KubernetesObject)
+			rel.Resources[i].UID = obj.UID
+			obj.HelmRelease = &k8s.ReleaseRef{
+				Namespace: rel.Namespace,
+				Name:      rel.Name,
+				Revision:  rel.Version,
+			}
+			data["k8s_objects"][pos] = obj
+		}
+
+		if missing > 0 {
+			log.Debug().
+				Str("namespace", rel.Namespace).
+				Str("name", rel.Name).
+				Int("resources", len(resources)).
+				Int("missing", missing).
+				Msg("Helm release has resources missing from the cluster")
+		}
+	}
+
+	return nil
+}
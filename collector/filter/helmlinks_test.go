@@ -0,0 +1,93 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func TestHelmLinksFilter(t *testing.T) {
+	rel := &helm.Release{Release: &release.Release{
+		Name:      "web",
+		Namespace: "apps",
+		Version:   3,
+		Manifest: `---
+# Source: web/templates/clusterrole.yaml
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: web
+---
+# Source: web/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+# Source: web/templates/ingress.yaml
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+---
+# Source: web/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: other
+`,
+	}}
+
+	data := map[string][]interface{}{
+		"k8s_types": {
+			map[string]interface{}{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "ClusterRole", "namespaced": false},
+			map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "namespaced": true},
+		},
+		"k8s_objects": {
+			k8s.KubernetesObject{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "apps", Name: "web", UID: "deployment-uid"},
+			k8s.KubernetesObject{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole", Name: "web", UID: "clusterrole-uid"},
+			k8s.KubernetesObject{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress", Namespace: "apps", Name: "web", UID: "ingress-uid"},
+			k8s.KubernetesObject{Group: "", Version: "v1", Kind: "Service", Namespace: "apps", Name: "web", UID: "service-uid"},
+		},
+		"helm_releases": {rel},
+	}
+
+	err := HelmLinksFilter(context.Background(), &config.Config{}, data)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	assert.DeepEqual(t, []helm.LinkedResource{
+		{
+			ManifestResource: helm.ManifestResource{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "web"},
+			UID:              "clusterrole-uid",
+		},
+		{
+			ManifestResource: helm.ManifestResource{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+			UID:              "deployment-uid",
+		},
+		{
+			ManifestResource: helm.ManifestResource{APIVersion: "extensions/v1beta1", Kind: "Ingress", Name: "web"},
+			UID:              "ingress-uid",
+		},
+		{
+			ManifestResource: helm.ManifestResource{APIVersion: "v1", Kind: "Service", Namespace: "other", Name: "web"},
+			Missing:          true,
+		},
+	}, rel.Resources, "linked resources must match")
+
+	expRef := &k8s.ReleaseRef{Namespace: "apps", Name: "web", Revision: 3}
+	for i, expLinked := range []bool{true, true, true, false} {
+		obj := data["k8s_objects"][i].(k8s.KubernetesObject)
+		if expLinked {
+			assert.DeepEqual(t, expRef, obj.HelmRelease, "object %s must be linked to the release", obj.Kind)
+		} else {
+			assert.True(t, obj.HelmRelease == nil, "object %s must not be linked to the release", obj.Kind)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package filter
+
+import (
+	"context"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+)
+
+// HelmSizeFilter records the size of every Helm release (including those found
+// in Argo applications) as it is sent to Firefly. It must run after all other
+// filters that modify releases, such as HelmLinksFilter.
+func HelmSizeFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	for _, value := range data["helm_releases"] {
+		rel, ok := value.(*helm.Release)
+		if !ok {
+			continue
+		}
+
+		rel.Size = helm.ReleaseSize(rel)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func TestHelmSizeFilter(t *testing.T) {
+	rel := &helm.Release{Release: &release.Release{
+		Name:      "web",
+		Namespace: "apps",
+		Manifest: `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+`,
+	}}
+
+	data := map[string][]interface{}{
+		"k8s_types": {
+			map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "namespaced": true},
+		},
+		"k8s_objects": {
+			k8s.KubernetesObject{Version: "v1", Kind: "ConfigMap", Namespace: "apps", Name: "web", UID: "cm-uid"},
+		},
+		"helm_releases": {rel},
+	}
+
+	for _, filter := range []DataFilter{HelmLinksFilter, HelmSizeFilter} {
+		assert.MustBeNil(t, filter(context.Background(), &config.Config{}, data), "error must be nil")
+	}
+	assert.MustBeEqual(t, 1, len(rel.Resources), "resources must be linked")
+
+	size := rel.Size
+	rel.Size = 0
+	encoded, err := json.Marshal(rel)
+	assert.MustBeNil(t, err, "release must be encoded")
+	assert.Equal(t, len(encoded), size, "size must include the linked resources")
+}
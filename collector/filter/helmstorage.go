@@ -0,0 +1,111 @@
+package filter
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/rs/zerolog/log"
+	"github.com/thoas/go-funk"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// HelmStorageFilter reads Helm releases from the Helm storage secrets and
+// configmaps (those labeled "owner=helm") collected by the Kubernetes
+// collector, rather than from the Helm SDK. It only runs when
+// config.Config.HelmSource is "objects", in which case the Helm collector
+// does not collect anything. The encoded releases are removed from the
+// storage objects, as the decoded releases are sent separately.
+func HelmStorageFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	if conf.HelmSource != helm.SourceObjects {
+		return nil
+	}
+
+	if !conf.AllowedResources["secrets"] {
+		log.Warn().Msg("Secrets are not collected, only Helm releases stored in configmaps will be found")
+	}
+
+	var revisions []*release.Release
+	metadataOnly := 0
+	for i, value := range data["k8s_objects"] {
+		obj, ok := value.(k8s.KubernetesObject)
+		if !ok || obj.Group != "" || obj.Object == nil {
+			continue
+		}
+		if obj.Kind != "Secret" && obj.Kind != "ConfigMap" {
+			continue
+		}
+		if owner, _ := funk.Get(obj.Object, "metadata.labels."+helm.StorageOwnerLabel).(string); owner != helm.StorageOwner {
+			continue
+		}
+		if conf.IgnoreNamespace(obj.Namespace) {
+			continue
+		}
+		if obj.MetadataOnly {
+			metadataOnly++
+			continue
+		}
+
+		encoded, _ := funk.Get(obj.Object, "data.release").(string)
+		if obj.Kind == "Secret" {
+			if secretType, _ := obj.Object["type"].(string); secretType != helm.StorageSecretType {
+				continue
+			}
+
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Str("namespace", obj.Namespace).
+					Str("name", obj.Name).
+					Msg("Failed decoding Helm storage secret")
+				continue
+			}
+			encoded = string(decoded)
+		}
+
+		rel, err := helm.DecodeRelease(encoded)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("kind", obj.Kind).
+				Str("namespace", obj.Namespace).
+				Str("name", obj.Name).
+				Msg("Failed decoding Helm release from storage object")
+			continue
+		}
+		revisions = append(revisions, rel)
+
+		stripped := make(map[string]interface{}, len(obj.Object))
+		for key, value := range obj.Object {
+			if key != "data" {
+				stripped[key] = value
+			}
+		}
+		obj.Object = stripped
+		data["k8s_objects"][i] = obj
+	}
+
+	if metadataOnly > 0 {
+		log.Warn().
+			Int("amount", metadataOnly).
+			Msg("Helm storage objects collected as metadata only cannot be decoded")
+	}
+
+	releases := helm.BuildReleases(conf, revisions)
+	data["helm_releases"] = append(data["helm_releases"], releases...)
+
+	log.Info().
+		Int("amount", len(releases)).
+		Int("revisions", len(revisions)).
+		Msg("Finished reading Helm releases from collected objects")
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package filter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func TestHelmStorageFilter(t *testing.T) {
+	logger := zerolog.Nop()
+
+	// encode releases the same way Helm's storage drivers do
+	encode := func(rel *release.Release) string {
+		b, err := json.Marshal(rel)
+		assert.MustBeNil(t, err, "release must be encoded")
+
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err = w.Write(b)
+		assert.MustBeNil(t, err, "release must be compressed")
+		assert.MustBeNil(t, w.Close(), "release must be compressed")
+
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	newRevision := func(version int, status release.Status) *release.Release {
+		return &release.Release{
+			Name:      "web",
+			Namespace: "apps",
+			Version:   version,
+			Info:      &release.Info{Status: status},
+			Chart: &chart.Chart{
+				Metadata: &chart.Metadata{Name: "web", Version: "1.0.0"},
+			},
+		}
+	}
+
+	storageObject := func(kind, namespace, name, encoded string) k8s.KubernetesObject {
+		obj := k8s.KubernetesObject{
+			Version:   "v1",
+			Kind:      kind,
+			Namespace: namespace,
+			Name:      name,
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name":      name,
+					"namespace": namespace,
+					"labels": map[string]interface{}{
+						"owner": "helm",
+						"name":  "web",
+					},
+				},
+				"data": map[string]interface{}{"release": encoded},
+			},
+		}
+		if kind == "Secret" {
+			obj.Object["type"] = helm.StorageSecretType
+		}
+		return obj
+	}
+
+	secretData := func(rel *release.Release) string {
+		return base64.StdEncoding.EncodeToString([]byte(encode(rel)))
+	}
+
+	data := map[string][]interface{}{
+		"k8s_objects": {
+			storageObject("Secret", "apps", "sh.helm.release.v1.web.v1", secretData(newRevision(1, release.StatusSuperseded))),
+			storageObject("Secret", "apps", "sh.helm.release.v1.web.v2", secretData(newRevision(2, release.StatusDeployed))),
+			storageObject("ConfigMap", "kube-system", "sh.helm.release.v1.web.v1", encode(newRevision(1, release.StatusDeployed))),
+			k8s.KubernetesObject{Version: "v1", Kind: "ConfigMap", Namespace: "apps", Name: "settings"},
+		},
+	}
+
+	conf := &config.Config{
+		Log:              &logger,
+		IgnoreNamespaces: []string{"kube-system"},
+		AllowedResources: map[string]bool{"secrets": true},
+		HelmSource:       helm.SourceObjects,
+		HelmHistoryMax:   10,
+		HelmRedaction:    helm.RedactionNone,
+	}
+
+	err := HelmStorageFilter(context.Background(), conf, data)
+	assert.MustBeNil(t, err, "error must be nil")
+	assert.MustBeEqual(t, 1, len(data["helm_releases"]), "a single release must be found")
+
+	rel := data["helm_releases"][0].(*helm.Release)
+	assert.Equal(t, "apps/web", rel.Namespace+"/"+rel.Name, "release must match")
+	assert.Equal(t, 2, rel.Version, "latest revision must be used")
+	assert.DeepEqual(t, []int{2, 1}, []int{rel.History[0].Revision, rel.History[1].Revision}, "history must match")
+
+	for i := 0; i < 2; i++ {
+		obj := data["k8s_objects"][i].(k8s.KubernetesObject)
+		_, ok := obj.Object["data"]
+		assert.False(t, ok, "encoded release must be removed from %s", obj.Name)
+	}
+
+	_, ok := data["k8s_objects"][2].(k8s.KubernetesObject).Object["data"]
+	assert.True(t, ok, "objects in ignored namespaces must not be modified")
+}
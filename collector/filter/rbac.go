@@ -0,0 +1,580 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// Risks of RBAC rules, i.e. access that allows escalating privileges beyond
+// those explicitly granted
+const (
+	// RiskWildcard means the rule uses a wildcard for its verbs, resources,
+	// API groups or non-resource URLs
+	RiskWildcard = "wildcard"
+
+	// RiskEscalate means the rule allows escalating or binding roles with
+	// permissions the subject does not hold
+	RiskEscalate = "escalate"
+
+	// RiskImpersonate means the rule allows impersonating other users,
+	// groups or ServiceAccounts
+	RiskImpersonate = "impersonate"
+
+	// RiskModifyBindings means the rule allows creating or modifying role
+	// bindings
+	RiskModifyBindings = "modifyBindings"
+
+	// RiskReadSecrets means the rule allows reading Secrets, including
+	// ServiceAccount tokens
+	RiskReadSecrets = "readSecrets"
+
+	// RiskCreateWorkloads means the rule allows creating pods (directly or
+	// through workloads), which may run as any ServiceAccount in the
+	// namespace
+	RiskCreateWorkloads = "createWorkloads"
+
+	// RiskExecPods means the rule allows executing commands in, or attaching
+	// to, running pods
+	RiskExecPods = "execPods"
+
+	// RiskCreateTokens means the rule allows creating ServiceAccount tokens
+	RiskCreateTokens = "createTokens"
+
+	// RiskNodeProxy means the rule allows proxying requests to the kubelet
+	// API of nodes
+	RiskNodeProxy = "nodeProxy"
+
+	// RiskModifyWebhooks means the rule allows creating or modifying
+	// admission webhooks, which can intercept and modify any object
+	RiskModifyWebhooks = "modifyWebhooks"
+
+	// RiskApproveCertificates means the rule allows approving certificate
+	// signing requests
+	RiskApproveCertificates = "approveCertificates"
+)
+
+// Groups of ServiceAccounts, and the prefix of their usernames
+const (
+	allAuthenticatedGroup   = "system:authenticated"
+	allServiceAccountsGroup = "system:serviceaccounts"
+	serviceAccountUserName  = "system:serviceaccount:"
+)
+
+// rbacAccess is access to a list of resources in an API group with a list of
+// verbs
+type rbacAccess struct {
+	group     string
+	resources []string
+	verbs     []string
+}
+
+// rbacRisks are the risks of RBAC rules (other than RiskWildcard), and the
+// access that poses each of them
+var rbacRisks = []struct {
+	risk   string
+	access []rbacAccess
+}{
+	{RiskEscalate, []rbacAccess{
+		{rbacv1.GroupName, []string{"roles", "clusterroles"}, []string{"escalate", "bind"}},
+	}},
+	{RiskImpersonate, []rbacAccess{
+		{"", []string{"users", "groups", "serviceaccounts"}, []string{"impersonate"}},
+		{"authentication.k8s.io", []string{"userextras", "uids"}, []string{"impersonate"}},
+	}},
+	{RiskModifyBindings, []rbacAccess{
+		{rbacv1.GroupName, []string{"rolebindings", "clusterrolebindings"}, []string{"create", "update", "patch"}},
+	}},
+	{RiskReadSecrets, []rbacAccess{
+		{"", []string{"secrets"}, []string{"get", "list", "watch"}},
+	}},
+	{RiskCreateWorkloads, []rbacAccess{
+		{"", []string{"pods", "replicationcontrollers"}, []string{"create"}},
+		{"apps", []string{"deployments", "daemonsets", "statefulsets", "replicasets"}, []string{"create"}},
+		{"batch", []string{"jobs", "cronjobs"}, []string{"create"}},
+	}},
+	{RiskExecPods, []rbacAccess{
+		{"", []string{"pods/exec", "pods/attach"}, []string{"create", "get"}},
+	}},
+	{RiskCreateTokens, []rbacAccess{
+		{"", []string{"serviceaccounts/token"}, []string{"create"}},
+	}},
+	{RiskNodeProxy, []rbacAccess{
+		{"", []string{"nodes/proxy"}, []string{"get", "create"}},
+	}},
+	{RiskModifyWebhooks, []rbacAccess{
+		{
+			"admissionregistration.k8s.io",
+			[]string{"mutatingwebhookconfigurations", "validatingwebhookconfigurations"},
+			[]string{"create", "update", "patch"},
+		},
+	}},
+	{RiskApproveCertificates, []rbacAccess{
+		{"certificates.k8s.io", []string{"certificatesigningrequests/approval"}, []string{"update", "patch"}},
+		{"certificates.k8s.io", []string{"signers"}, []string{"approve"}},
+	}},
+}
+
+// ServiceAccountPermissions is the effective permission summary of a
+// ServiceAccount. Permissions are the RBAC rules granted to it by role
+// bindings directly or by its username, while rules granted to the groups it
+// belongs to are summarized once per group (see GroupPermissions) and
+// referenced by Groups.
+type ServiceAccountPermissions struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// UID is the UID of the ServiceAccount, empty if it was not collected
+	UID string `json:"uid,omitempty"`
+
+	Permissions []Permission `json:"permissions"`
+
+	// Groups are the groups the ServiceAccount belongs to that are granted
+	// permissions, e.g. "system:serviceaccounts"
+	Groups []string `json:"groups,omitempty"`
+
+	// Risks is the sorted list of risks posed by all permissions, including
+	// those of its groups
+	Risks []string `json:"risks"`
+}
+
+// GroupPermissions is the permission summary of a group of ServiceAccounts
+// ("system:serviceaccounts", "system:serviceaccounts:<namespace>" or
+// "system:authenticated"), i.e. all RBAC rules granted to the group by role
+// bindings.
+type GroupPermissions struct {
+	Group       string       `json:"group"`
+	Permissions []Permission `json:"permissions"`
+
+	// Risks is the sorted list of risks posed by all permissions
+	Risks []string `json:"risks"`
+}
+
+// Permission is a single RBAC rule granted to a ServiceAccount
+type Permission struct {
+	rbacv1.PolicyRule
+
+	// Namespace is the namespace the rule applies to, empty if it applies
+	// cluster-wide
+	Namespace string `json:"namespace,omitempty"`
+
+	// Binding and Role are the binding granting the rule, and the role it
+	// is defined by
+	Binding PermissionSource `json:"binding"`
+	Role    PermissionSource `json:"role"`
+
+	// Subject is the subject of the binding matching the ServiceAccount, in
+	// the form "<kind>:<name>" (e.g. "Group:system:serviceaccounts")
+	Subject string `json:"subject"`
+
+	// Risks is the list of risks posed by the rule
+	Risks []string `json:"risks,omitempty"`
+}
+
+// PermissionSource identifies an RBAC binding or role
+type PermissionSource struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// RBACFilter computes the effective permissions of every ServiceAccount from
+// the collected Roles, ClusterRoles and their bindings, and stores them under
+// the "k8s_rbac_permissions" key. Permissions granted to groups of
+// ServiceAccounts are stored once per group under the
+// "k8s_rbac_group_permissions" key. Rules of aggregated ClusterRoles include
+// those of the ClusterRoles matching their aggregation rules. ServiceAccounts
+// referenced by bindings are included even if they were not collected.
+func RBACFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	var (
+		roles               = make(map[string]*rbacv1.Role)
+		clusterRoles        []*rbacv1.ClusterRole
+		roleBindings        []*rbacv1.RoleBinding
+		clusterRoleBindings []*rbacv1.ClusterRoleBinding
+		accounts            = make(map[string]*ServiceAccountPermissions)
+		groups              = make(map[string]*GroupPermissions)
+	)
+
+	for _, value := range data["k8s_objects"] {
+		obj, ok := value.(k8s.KubernetesObject)
+		if !ok || (obj.Group != rbacv1.GroupName && obj.Kind != "ServiceAccount") {
+			continue
+		}
+
+		var target interface{}
+		switch obj.Kind {
+		case "Role":
+			target = &rbacv1.Role{}
+		case "ClusterRole":
+			target = &rbacv1.ClusterRole{}
+		case "RoleBinding":
+			target = &rbacv1.RoleBinding{}
+		case "ClusterRoleBinding":
+			target = &rbacv1.ClusterRoleBinding{}
+		case "ServiceAccount":
+			account := accountPermissions(accounts, obj.Namespace, obj.Name)
+			account.UID = obj.UID
+			continue
+		default:
+			continue
+		}
+
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, target)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("kind", obj.Kind).
+				Str("namespace", obj.Namespace).
+				Str("name", obj.Name).
+				Msg("Failed parsing RBAC object")
+			continue
+		}
+
+		switch typed := target.(type) {
+		case *rbacv1.Role:
+			roles[typed.Namespace+"/"+typed.Name] = typed
+		case *rbacv1.ClusterRole:
+			clusterRoles = append(clusterRoles, typed)
+		case *rbacv1.RoleBinding:
+			roleBindings = append(roleBindings, typed)
+		case *rbacv1.ClusterRoleBinding:
+			clusterRoleBindings = append(clusterRoleBindings, typed)
+		}
+	}
+
+	clusterRoleRules := make(map[string][]rbacv1.PolicyRule, len(clusterRoles))
+	for _, role := range clusterRoles {
+		clusterRoleRules[role.Name] = aggregatedRules(role, clusterRoles)
+	}
+
+	// bindings are resolved to a list of grants, applied to ServiceAccounts
+	// and groups once all ServiceAccounts are known
+	type grant struct {
+		namespace string
+		binding   PermissionSource
+		role      PermissionSource
+		rules     []rbacv1.PolicyRule
+		subjects  []rbacv1.Subject
+	}
+
+	var grants []grant
+	for _, binding := range roleBindings {
+		role := PermissionSource{Kind: binding.RoleRef.Kind, Name: binding.RoleRef.Name}
+		var rules []rbacv1.PolicyRule
+		if role.Kind == "Role" {
+			role.Namespace = binding.Namespace
+			if r, ok := roles[binding.Namespace+"/"+role.Name]; ok {
+				rules = r.Rules
+			}
+		} else {
+			rules = clusterRoleRules[role.Name]
+		}
+
+		grants = append(grants, grant{
+			namespace: binding.Namespace,
+			binding:   PermissionSource{Kind: "RoleBinding", Namespace: binding.Namespace, Name: binding.Name},
+			role:      role,
+			rules:     rules,
+			subjects:  binding.Subjects,
+		})
+	}
+	for _, binding := range clusterRoleBindings {
+		grants = append(grants, grant{
+			binding:  PermissionSource{Kind: "ClusterRoleBinding", Name: binding.Name},
+			role:     PermissionSource{Kind: binding.RoleRef.Kind, Name: binding.RoleRef.Name},
+			rules:    clusterRoleRules[binding.RoleRef.Name],
+			subjects: binding.Subjects,
+		})
+	}
+
+	// ServiceAccounts referenced by bindings are included even if not
+	// collected
+	for _, g := range grants {
+		for _, subject := range g.subjects {
+			if namespace, name, ok := subjectServiceAccount(subject, g.binding.Namespace); ok {
+				accountPermissions(accounts, namespace, name)
+			}
+		}
+	}
+
+	for _, g := range grants {
+		for _, subject := range g.subjects {
+			var permissions *[]Permission
+			if namespace, name, ok := subjectServiceAccount(subject, g.binding.Namespace); ok {
+				permissions = &accounts[namespace+"/"+name].Permissions
+			} else if subject.Kind == rbacv1.GroupKind && isServiceAccountGroup(subject.Name) {
+				permissions = &groupPermissions(groups, subject.Name).Permissions
+			} else {
+				continue
+			}
+
+			for _, rule := range g.rules {
+				*permissions = append(*permissions, Permission{
+					PolicyRule: rule,
+					Namespace:  g.namespace,
+					Binding:    g.binding,
+					Role:       g.role,
+					Subject:    subject.Kind + ":" + subject.Name,
+					Risks:      ruleRisks(rule),
+				})
+			}
+		}
+	}
+
+	groupNames := make([]string, 0, len(groups))
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	groupSummaries := make([]interface{}, 0, len(groupNames))
+	for _, name := range groupNames {
+		group := groups[name]
+		group.Risks = permissionRisks(group.Permissions, nil)
+		groupSummaries = append(groupSummaries, group)
+	}
+
+	keys := make([]string, 0, len(accounts))
+	for key := range accounts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	permissions := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		account := accounts[key]
+
+		var inherited []Permission
+		for _, name := range accountGroups(account.Namespace) {
+			if group, ok := groups[name]; ok {
+				account.Groups = append(account.Groups, name)
+				inherited = append(inherited, group.Permissions...)
+			}
+		}
+		account.Risks = permissionRisks(account.Permissions, inherited)
+
+		permissions = append(permissions, account)
+	}
+
+	data["k8s_rbac_permissions"] = permissions
+	data["k8s_rbac_group_permissions"] = groupSummaries
+
+	log.Info().
+		Int("serviceAccounts", len(permissions)).
+		Int("groups", len(groupSummaries)).
+		Msg("Finished computing ServiceAccount permissions")
+
+	return nil
+}
+
+// accountPermissions returns the permission summary of a ServiceAccount,
+// creating it if needed
+func accountPermissions(accounts map[string]*ServiceAccountPermissions, namespace, name string) *ServiceAccountPermissions {
+	key := namespace + "/" + name
+	account, ok := accounts[key]
+	if !ok {
+		account = &ServiceAccountPermissions{
+			Namespace:   namespace,
+			Name:        name,
+			Permissions: []Permission{},
+		}
+		accounts[key] = account
+	}
+	return account
+}
+
+// subjectServiceAccount returns the namespace and name of the ServiceAccount
+// referenced by a binding subject, either directly or by its username
+func subjectServiceAccount(subject rbacv1.Subject, bindingNamespace string) (namespace, name string, ok bool) {
+	switch subject.Kind {
+	case rbacv1.ServiceAccountKind:
+		namespace = subject.Namespace
+		if namespace == "" {
+			namespace = bindingNamespace
+		}
+		return namespace, subject.Name, true
+	case rbacv1.UserKind:
+		parts := strings.Split(strings.TrimPrefix(subject.Name, serviceAccountUserName), ":")
+		if strings.HasPrefix(subject.Name, serviceAccountUserName) && len(parts) == 2 {
+			return parts[0], parts[1], true
+		}
+	}
+	return "", "", false
+}
+
+// groupPermissions returns the permission summary of a group, creating it if
+// needed
+func groupPermissions(groups map[string]*GroupPermissions, name string) *GroupPermissions {
+	group, ok := groups[name]
+	if !ok {
+		group = &GroupPermissions{
+			Group:       name,
+			Permissions: []Permission{},
+		}
+		groups[name] = group
+	}
+	return group
+}
+
+// isServiceAccountGroup checks whether a group includes ServiceAccounts: the
+// "system:serviceaccounts" and "system:authenticated" groups include all of
+// them, and the "system:serviceaccounts:<namespace>" groups include those of
+// a namespace
+func isServiceAccountGroup(name string) bool {
+	return name == allAuthenticatedGroup || name == allServiceAccountsGroup ||
+		strings.HasPrefix(name, allServiceAccountsGroup+":")
+}
+
+// accountGroups returns the groups that ServiceAccounts of a namespace belong
+// to, in the order they are referenced by ServiceAccountPermissions.Groups
+func accountGroups(namespace string) []string {
+	return []string{
+		allAuthenticatedGroup,
+		allServiceAccountsGroup,
+		fmt.Sprintf("%s:%s", allServiceAccountsGroup, namespace),
+	}
+}
+
+// permissionRisks returns the sorted list of risks posed by lists of
+// permissions
+func permissionRisks(lists ...[]Permission) []string {
+	seen := make(map[string]bool)
+	for _, permissions := range lists {
+		for _, permission := range permissions {
+			for _, risk := range permission.Risks {
+				seen[risk] = true
+			}
+		}
+	}
+
+	risks := make([]string, 0, len(seen))
+	for risk := range seen {
+		risks = append(risks, risk)
+	}
+	sort.Strings(risks)
+
+	return risks
+}
+
+// aggregatedRules returns the rules of a ClusterRole, along with the rules of
+// all ClusterRoles matching its aggregation rule (if any)
+func aggregatedRules(role *rbacv1.ClusterRole, clusterRoles []*rbacv1.ClusterRole) []rbacv1.PolicyRule {
+	rules := append([]rbacv1.PolicyRule{}, role.Rules...)
+	if role.AggregationRule == nil {
+		return rules
+	}
+
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		seen[rule.String()] = true
+	}
+
+	for _, labelSelector := range role.AggregationRule.ClusterRoleSelectors {
+		labelSelector := labelSelector
+		selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+		if err != nil || selector.Empty() {
+			continue
+		}
+
+		for _, component := range clusterRoles {
+			if component.Name == role.Name || !selector.Matches(labels.Set(component.Labels)) {
+				continue
+			}
+			for _, rule := range component.Rules {
+				if !seen[rule.String()] {
+					seen[rule.String()] = true
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+
+	return rules
+}
+
+// ruleRisks returns the risks posed by an RBAC rule
+func ruleRisks(rule rbacv1.PolicyRule) []string {
+	var risks []string
+	if containsWildcard(rule.Verbs) || containsWildcard(rule.Resources) ||
+		containsWildcard(rule.APIGroups) || containsWildcard(rule.NonResourceURLs) {
+		risks = append(risks, RiskWildcard)
+	}
+
+	for _, entry := range rbacRisks {
+		if ruleAllowsAny(rule, entry.access) {
+			risks = append(risks, entry.risk)
+		}
+	}
+
+	return risks
+}
+
+func ruleAllowsAny(rule rbacv1.PolicyRule, access []rbacAccess) bool {
+	for _, a := range access {
+		if !ruleMatches(rule.APIGroups, a.group) {
+			continue
+		}
+		for _, resource := range a.resources {
+			if !ruleMatchesResource(rule.Resources, resource) {
+				continue
+			}
+			for _, verb := range a.verbs {
+				if ruleMatches(rule.Verbs, verb) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ruleMatches checks whether a list of rule values (verbs or API groups)
+// includes a value, either explicitly or by a wildcard
+func ruleMatches(values []string, value string) bool {
+	for _, v := range values {
+		if v == rbacv1.VerbAll || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleMatchesResource checks whether a list of rule resources includes a
+// resource, either explicitly, by a wildcard, or by a subresource wildcard
+// (e.g. "*/exec" for "pods/exec")
+func ruleMatchesResource(resources []string, resource string) bool {
+	subresource := ""
+	if i := strings.Index(resource, "/"); i != -1 {
+		subresource = resource[i+1:]
+	}
+
+	for _, r := range resources {
+		if r == rbacv1.ResourceAll || r == resource || (subresource != "" && r == "*/"+subresource) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsWildcard(values []string) bool {
+	for _, v := range values {
+		if v == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func rbacObject(kind, namespace, name string, fields map[string]interface{}) k8s.KubernetesObject {
+	group, apiVersion := "rbac.authorization.k8s.io", "rbac.authorization.k8s.io/v1"
+	if kind == "ServiceAccount" {
+		group, apiVersion = "", "v1"
+	}
+
+	metadata := map[string]interface{}{"name": name, "uid": kind + "/" + name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	if labels, ok := fields["labels"]; ok {
+		metadata["labels"] = labels
+		delete(fields, "labels")
+	}
+
+	object := map[string]interface{}{"apiVersion": apiVersion, "kind": kind, "metadata": metadata}
+	for key, value := range fields {
+		object[key] = value
+	}
+
+	return k8s.KubernetesObject{
+		Group:     group,
+		Kind:      kind,
+		Namespace: namespace,
+		Name:      name,
+		UID:       kind + "/" + name,
+		Object:    object,
+	}
+}
+
+func rule(groups, resources, verbs []interface{}) map[string]interface{} {
+	return map[string]interface{}{"apiGroups": groups, "resources": resources, "verbs": verbs}
+}
+
+func TestRBACFilter(t *testing.T) {
+	logger := zerolog.Nop()
+
+	roleRef := func(kind, name string) map[string]interface{} {
+		return map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": kind, "name": name}
+	}
+	subject := func(kind, namespace, name string) map[string]interface{} {
+		s := map[string]interface{}{"kind": kind, "name": name}
+		if namespace != "" {
+			s["namespace"] = namespace
+		}
+		return s
+	}
+
+	data := map[string][]interface{}{
+		"k8s_objects": {
+			rbacObject("ServiceAccount", "ci", "deployer", nil),
+			rbacObject("ServiceAccount", "monitoring", "prometheus", nil),
+			rbacObject("Role", "ci", "deploy", map[string]interface{}{
+				"rules": []interface{}{
+					rule([]interface{}{"apps"}, []interface{}{"deployments"}, []interface{}{"create", "update"}),
+				},
+			}),
+			rbacObject("RoleBinding", "ci", "deployer", map[string]interface{}{
+				"roleRef": roleRef("Role", "deploy"),
+				"subjects": []interface{}{
+					subject("ServiceAccount", "", "deployer"),
+					subject("User", "", "system:serviceaccount:build:builder"),
+				},
+			}),
+			rbacObject("ClusterRole", "", "monitoring", map[string]interface{}{
+				"aggregationRule": map[string]interface{}{
+					"clusterRoleSelectors": []interface{}{
+						map[string]interface{}{
+							"matchLabels": map[string]interface{}{"aggregate-to-monitoring": "true"},
+						},
+					},
+				},
+			}),
+			rbacObject("ClusterRole", "", "monitoring-pods", map[string]interface{}{
+				"labels": map[string]interface{}{"aggregate-to-monitoring": "true"},
+				"rules": []interface{}{
+					rule([]interface{}{""}, []interface{}{"pods"}, []interface{}{"get", "list"}),
+				},
+			}),
+			rbacObject("ClusterRole", "", "monitoring-nodes", map[string]interface{}{
+				"labels": map[string]interface{}{"aggregate-to-monitoring": "true"},
+				"rules": []interface{}{
+					rule([]interface{}{""}, []interface{}{"nodes", "nodes/proxy"}, []interface{}{"get"}),
+				},
+			}),
+			rbacObject("ClusterRoleBinding", "", "monitoring", map[string]interface{}{
+				"roleRef":  roleRef("ClusterRole", "monitoring"),
+				"subjects": []interface{}{subject("Group", "", "system:serviceaccounts:monitoring")},
+			}),
+			rbacObject("ClusterRole", "", "secret-reader", map[string]interface{}{
+				"rules": []interface{}{
+					rule([]interface{}{""}, []interface{}{"secrets"}, []interface{}{"get"}),
+				},
+			}),
+			rbacObject("RoleBinding", "kube-system", "secret-readers", map[string]interface{}{
+				"roleRef":  roleRef("ClusterRole", "secret-reader"),
+				"subjects": []interface{}{subject("Group", "", "system:serviceaccounts")},
+			}),
+			rbacObject("ClusterRole", "", "admin", map[string]interface{}{
+				"rules": []interface{}{
+					rule([]interface{}{"*"}, []interface{}{"*"}, []interface{}{"*"}),
+				},
+			}),
+			rbacObject("ClusterRoleBinding", "", "legacy-admin", map[string]interface{}{
+				"roleRef":  roleRef("ClusterRole", "admin"),
+				"subjects": []interface{}{subject("ServiceAccount", "ci", "deployer")},
+			}),
+		},
+	}
+
+	err := RBACFilter(context.Background(), &config.Config{Log: &logger}, data)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	accounts := data["k8s_rbac_permissions"]
+	assert.MustBeEqual(t, 3, len(accounts), "all ServiceAccounts must be summarized")
+
+	builder := accounts[0].(*ServiceAccountPermissions)
+	assert.Equal(t, "build/builder", builder.Namespace+"/"+builder.Name, "accounts must be sorted")
+	assert.Equal(t, "", builder.UID, "accounts not collected must not have a UID")
+	assert.MustBeEqual(t, 1, len(builder.Permissions), "builder must have one direct permission")
+	assert.Equal(t, "ci", builder.Permissions[0].Namespace, "RoleBinding permissions must be namespaced")
+	assert.Equal(t, "User:system:serviceaccount:build:builder", builder.Permissions[0].Subject, "subject must match")
+	assert.DeepEqual(t, []string{"system:serviceaccounts"}, builder.Groups, "builder groups must match")
+	assert.DeepEqual(t, []string{RiskCreateWorkloads, RiskReadSecrets}, builder.Risks, "builder risks must include group risks")
+
+	deployer := accounts[1].(*ServiceAccountPermissions)
+	assert.Equal(t, "ci/deployer", deployer.Namespace+"/"+deployer.Name, "accounts must be sorted")
+	assert.Equal(t, "ServiceAccount/deployer", deployer.UID, "collected accounts must have a UID")
+	assert.MustBeEqual(t, 2, len(deployer.Permissions), "deployer must have two direct permissions")
+	assert.Equal(t, "ServiceAccount:deployer", deployer.Permissions[0].Subject, "subject must match")
+	assert.Equal(t, "", deployer.Permissions[1].Namespace, "ClusterRoleBinding permissions must be cluster-wide")
+	assert.Equal(t, "legacy-admin", deployer.Permissions[1].Binding.Name, "binding must match")
+	assert.Equal(t, "admin", deployer.Permissions[1].Role.Name, "role must match")
+	assert.DeepEqual(t, []string{
+		RiskApproveCertificates,
+		RiskCreateTokens,
+		RiskCreateWorkloads,
+		RiskEscalate,
+		RiskExecPods,
+		RiskImpersonate,
+		RiskModifyBindings,
+		RiskModifyWebhooks,
+		RiskNodeProxy,
+		RiskReadSecrets,
+		RiskWildcard,
+	}, deployer.Risks, "deployer risks must match")
+
+	prometheus := accounts[2].(*ServiceAccountPermissions)
+	assert.Equal(t, "monitoring/prometheus", prometheus.Namespace+"/"+prometheus.Name, "accounts must be sorted")
+	assert.Equal(t, 0, len(prometheus.Permissions), "group permissions must not be copied to accounts")
+	assert.DeepEqual(t, []string{
+		"system:serviceaccounts",
+		"system:serviceaccounts:monitoring",
+	}, prometheus.Groups, "prometheus groups must match")
+	assert.DeepEqual(t, []string{RiskNodeProxy, RiskReadSecrets}, prometheus.Risks, "prometheus risks must match")
+
+	groups := data["k8s_rbac_group_permissions"]
+	assert.MustBeEqual(t, 2, len(groups), "all granted groups must be summarized")
+
+	all := groups[0].(*GroupPermissions)
+	assert.Equal(t, "system:serviceaccounts", all.Group, "groups must be sorted")
+	assert.MustBeEqual(t, 1, len(all.Permissions), "group must have one permission")
+	assert.Equal(t, "Group:system:serviceaccounts", all.Permissions[0].Subject, "group subjects must match")
+	assert.Equal(t, "kube-system", all.Permissions[0].Namespace, "RoleBinding of ClusterRole must be namespaced")
+	assert.DeepEqual(t, []string{RiskReadSecrets}, all.Risks, "group risks must match")
+
+	monitoring := groups[1].(*GroupPermissions)
+	assert.Equal(t, "system:serviceaccounts:monitoring", monitoring.Group, "groups must be sorted")
+	assert.MustBeEqual(t, 2, len(monitoring.Permissions), "group must have two permissions")
+	assert.Equal(t, "monitoring", monitoring.Permissions[0].Role.Name, "aggregated rules must be granted")
+	assert.DeepEqual(t, []string{"pods"}, monitoring.Permissions[0].Resources, "aggregated rules must match")
+	assert.DeepEqual(t, []string{"nodes", "nodes/proxy"}, monitoring.Permissions[1].Resources, "aggregated rules must match")
+	assert.DeepEqual(t, []string{RiskNodeProxy}, monitoring.Risks, "group risks must match")
+}
+
+func TestRuleRisks(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "read-only",
+			rule:     rule([]interface{}{""}, []interface{}{"pods", "configmaps"}, []interface{}{"get", "list"}),
+			expected: nil,
+		},
+		{
+			name:     "subresource wildcard",
+			rule:     rule([]interface{}{""}, []interface{}{"*/exec"}, []interface{}{"create"}),
+			expected: []string{RiskExecPods},
+		},
+		{
+			name:     "wildcard verbs",
+			rule:     rule([]interface{}{"rbac.authorization.k8s.io"}, []interface{}{"clusterrolebindings"}, []interface{}{"*"}),
+			expected: []string{RiskWildcard, RiskModifyBindings},
+		},
+		{
+			name:     "other group",
+			rule:     rule([]interface{}{"example.com"}, []interface{}{"secrets"}, []interface{}{"get"}),
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obj := rbacObject("ClusterRole", "", test.name, map[string]interface{}{
+				"rules": []interface{}{test.rule},
+			})
+			data := map[string][]interface{}{
+				"k8s_objects": {
+					obj,
+					rbacObject("ClusterRoleBinding", "", test.name, map[string]interface{}{
+						"roleRef": map[string]interface{}{"kind": "ClusterRole", "name": test.name},
+						"subjects": []interface{}{
+							map[string]interface{}{"kind": "ServiceAccount", "namespace": "default", "name": "test"},
+						},
+					}),
+				},
+			}
+
+			logger := zerolog.Nop()
+			err := RBACFilter(context.Background(), &config.Config{Log: &logger}, data)
+			assert.MustBeNil(t, err, "error must be nil")
+
+			account := data["k8s_rbac_permissions"][0].(*ServiceAccountPermissions)
+			assert.MustBeEqual(t, 1, len(account.Permissions), "rule must be granted")
+			assert.DeepEqual(t, test.expected, account.Permissions[0].Risks, "risks must match")
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/thoas/go-funk"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// APIVersionsFilter records the API versions used by the manifests of Helm
+// releases (including those found in Argo applications) in the served versions
+// list of each resource type. It only runs when served versions have been
+// collected (see config.Config.CollectAllVersions). Types of legacy groups
+// that are no longer served (e.g. extensions/v1beta1 Ingresses) are recorded
+// in the entry of the type replacing them, according to the deprecations
+// table. Other types used in manifests but not served by the API server at all
+// are added to the list with no served versions.
+func APIVersionsFilter(
+	ctx context.Context,
+	conf *config.Config,
+	data map[string][]interface{},
+) error {
+	if _, ok := data["k8s_api_versions"]; !ok {
+		return nil
+	}
+
+	byGroupKind := make(map[schema.GroupKind]*k8s.ResourceVersions, len(data["k8s_api_versions"]))
+	for _, value := range data["k8s_api_versions"] {
+		entry, ok := value.(*k8s.ResourceVersions)
+		if !ok {
+			continue
+		}
+		byGroupKind[schema.GroupKind{Group: entry.Group, Kind: entry.Kind}] = entry
+	}
+
+	for _, value := range data["helm_releases"] {
+		rel, ok := value.(*helm.Release)
+		if !ok {
+			continue
+		}
+
+		releaseName := fmt.Sprintf("%s/%s", rel.Namespace, rel.Name)
+		for _, resource := range helm.ParseManifest(rel.Manifest) {
+			gvk := resource.GroupVersionKind()
+
+			entry, ok := byGroupKind[gvk.GroupKind()]
+			if !ok {
+				entry, ok = byGroupKind[replacementGroupKind(gvk)]
+			}
+			if !ok {
+				entry = &k8s.ResourceVersions{
+					Group:          gvk.Group,
+					Kind:           gvk.Kind,
+					ServedVersions: []string{},
+				}
+				byGroupKind[gvk.GroupKind()] = entry
+				data["k8s_api_versions"] = append(data["k8s_api_versions"], entry)
+
+				log.Warn().
+					Str("release", releaseName).
+					Str("apiVersion", resource.APIVersion).
+					Str("kind", resource.Kind).
+					Msg("Helm release uses an API that is not served by the cluster")
+			}
+
+			if entry.HelmManifestVersions == nil {
+				entry.HelmManifestVersions = make(map[string][]string)
+			}
+
+			key := entry.UsedVersionKey(gvk.GroupVersion())
+			releases := entry.HelmManifestVersions[key]
+			if !funk.ContainsString(releases, releaseName) {
+				entry.HelmManifestVersions[key] = append(releases, releaseName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// replacementGroupKind returns the group and kind replacing a deprecated API
+// according to the deprecations table, e.g. networking.k8s.io Ingresses for
+// extensions/v1beta1 Ingresses. APIs without a replacement are returned as is.
+func replacementGroupKind(gvk schema.GroupVersionKind) schema.GroupKind {
+	api, ok := deprecatedAPIs[gvk]
+	if !ok || api.Replacement == "" {
+		return gvk.GroupKind()
+	}
+
+	replacement, err := schema.ParseGroupVersion(api.Replacement)
+	if err != nil {
+		return gvk.GroupKind()
+	}
+
+	return schema.GroupKind{Group: replacement.Group, Kind: gvk.Kind}
+}
@@ -0,0 +1,83 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/helm"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+func TestAPIVersionsFilter(t *testing.T) {
+	ingresses := &k8s.ResourceVersions{
+		Group:            "networking.k8s.io",
+		Resource:         "ingresses",
+		Kind:             "Ingress",
+		PreferredVersion: "v1",
+		ServedVersions:   []string{"v1"},
+	}
+	deployments := &k8s.ResourceVersions{
+		Group:            "apps",
+		Resource:         "deployments",
+		Kind:             "Deployment",
+		PreferredVersion: "v1",
+		ServedVersions:   []string{"v1"},
+	}
+
+	data := map[string][]interface{}{
+		"k8s_api_versions": {ingresses, deployments},
+		"helm_releases": {
+			&helm.Release{Release: &release.Release{
+				Name:      "legacy",
+				Namespace: "default",
+				Manifest: `---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+`,
+			}},
+		},
+	}
+
+	err := APIVersionsFilter(context.Background(), &config.Config{}, data)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	assert.DeepEqual(
+		t,
+		map[string][]string{"extensions/v1beta1": {"default/legacy"}},
+		ingresses.HelmManifestVersions,
+		"legacy ingresses must be recorded with served ingresses",
+	)
+	assert.DeepEqual(
+		t,
+		map[string][]string{"extensions/v1beta1": {"default/legacy"}, "v1": {"default/legacy"}},
+		deployments.HelmManifestVersions,
+		"legacy deployments must be recorded with served deployments",
+	)
+
+	assert.MustBeEqual(t, 3, len(data["k8s_api_versions"]), "only unserved types must be added")
+	widgets := data["k8s_api_versions"][2].(*k8s.ResourceVersions)
+	assert.Equal(t, "example.com", widgets.Group, "unserved type group must match")
+	assert.DeepEqual(t, []string{}, widgets.ServedVersions, "unserved type must have no served versions")
+	assert.DeepEqual(t, map[string][]string{"v1": {"default/legacy"}}, widgets.HelmManifestVersions, "unserved type usage must match")
+}
@@ -0,0 +1,201 @@
+package helm
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// Types of chart repositories
+const (
+	RepositoryHTTP  = "http"
+	RepositoryOCI   = "oci"
+	RepositoryAlias = "alias"
+	RepositoryLocal = "local"
+)
+
+// ChartInfo summarizes the metadata of the chart from which a Helm release was
+// installed: where it came from, who maintains it, and which subcharts it
+// pulled in.
+type ChartInfo struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	AppVersion string `json:"appVersion,omitempty"`
+
+	// Repository is the repository from which the chart was installed. Helm
+	// does not record it in releases, so it is only known for releases
+	// created from Argo applications, and for charts found in a repository
+	// index (see config.Config.HelmRepositoryIndexes)
+	Repository *Repository `json:"repository,omitempty"`
+
+	Home         string              `json:"home,omitempty"`
+	Sources      []string            `json:"sources,omitempty"`
+	Maintainers  []*chart.Maintainer `json:"maintainers,omitempty"`
+	Annotations  map[string]string   `json:"annotations,omitempty"`
+	Dependencies []Dependency        `json:"dependencies,omitempty"`
+}
+
+// Dependency is a subchart declared by the chart of a Helm release
+type Dependency struct {
+	Name  string `json:"name"`
+	Alias string `json:"alias,omitempty"`
+
+	// Version is the version (or version range) declared by the chart
+	Version string `json:"version,omitempty"`
+
+	// ResolvedVersion is the version of the subchart that was bundled with
+	// the chart, if it was enabled
+	ResolvedVersion string `json:"resolvedVersion,omitempty"`
+
+	// Repository is the repository of the subchart
+	Repository *Repository `json:"repository,omitempty"`
+
+	Condition string   `json:"condition,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+
+	// Enabled is true if the subchart was enabled when the release was
+	// installed or upgraded
+	Enabled bool `json:"enabled"`
+}
+
+// Repository identifies a chart repository
+type Repository struct {
+	// Type is the type of the repository ("http", "oci", "alias" or "local")
+	Type string `json:"type"`
+
+	// URL is the repository's URL, minus any credentials
+	URL string `json:"url"`
+
+	// ID is a normalized identifier of the repository, which is equal for all
+	// URLs referencing the same repository regardless of scheme, case and
+	// trailing slashes, e.g. "http:charts.bitnami.com/bitnami" or
+	// "oci:registry-1.docker.io/bitnamicharts"
+	ID string `json:"id"`
+}
+
+// NewChartInfo summarizes the metadata of the provided chart. It returns nil
+// if the chart has no metadata.
+func NewChartInfo(ch *chart.Chart) *ChartInfo {
+	if ch == nil || ch.Metadata == nil {
+		return nil
+	}
+
+	info := &ChartInfo{
+		Name:        ch.Metadata.Name,
+		Version:     ch.Metadata.Version,
+		AppVersion:  ch.Metadata.AppVersion,
+		Home:        ch.Metadata.Home,
+		Sources:     ch.Metadata.Sources,
+		Maintainers: ch.Metadata.Maintainers,
+		Annotations: ch.Metadata.Annotations,
+	}
+
+	// subcharts are renamed to their alias when bundled
+	bundled := make(map[string]string)
+	for _, sub := range ch.Dependencies() {
+		if sub.Metadata != nil {
+			bundled[sub.Metadata.Name] = sub.Metadata.Version
+		}
+	}
+
+	for _, dep := range ch.Metadata.Dependencies {
+		if dep == nil {
+			continue
+		}
+
+		name := dep.Name
+		if dep.Alias != "" {
+			name = dep.Alias
+		}
+		resolvedVersion, ok := bundled[name]
+
+		info.Dependencies = append(info.Dependencies, Dependency{
+			Name:            dep.Name,
+			Alias:           dep.Alias,
+			Version:         dep.Version,
+			ResolvedVersion: resolvedVersion,
+			Repository:      NormalizeRepository(dep.Repository),
+			Condition:       dep.Condition,
+			Tags:            dep.Tags,
+			Enabled:         ok || dep.Enabled,
+		})
+	}
+
+	sort.SliceStable(info.Dependencies, func(i, j int) bool {
+		return info.Dependencies[i].Name < info.Dependencies[j].Name
+	})
+
+	return info
+}
+
+// NormalizeRepository parses a chart repository reference, as it appears in
+// chart dependencies or Argo applications, and returns a normalized
+// identifier. Supported references are HTTP(S) repository URLs, OCI registry
+// URLs (with or without the "oci://" scheme), repository aliases ("@name" or
+// "alias:name") and local paths ("file://..."). It returns nil for empty
+// references.
+func NormalizeRepository(ref string) *Repository {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(ref, "@"):
+		return &Repository{Type: RepositoryAlias, URL: ref, ID: RepositoryAlias + ":" + ref[1:]}
+	case strings.HasPrefix(ref, "alias:"):
+		name := strings.TrimPrefix(ref, "alias:")
+		return &Repository{Type: RepositoryAlias, URL: ref, ID: RepositoryAlias + ":" + name}
+	case strings.HasPrefix(ref, "file://"):
+		return &Repository{Type: RepositoryLocal, URL: ref, ID: RepositoryLocal + ":" + strings.TrimPrefix(ref, "file://")}
+	}
+
+	local := &Repository{Type: RepositoryLocal, URL: ref, ID: RepositoryLocal + ":" + ref}
+
+	raw := ref
+	if !strings.Contains(ref, "://") {
+		// OCI registries are referenced without a scheme in Argo applications,
+		// anything else without a scheme is a path
+		if host := strings.SplitN(ref, "/", 2)[0]; !strings.ContainsAny(host, ".:") || strings.HasPrefix(host, ".") {
+			return local
+		}
+		raw = "oci://" + ref
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return local
+	}
+
+	var repoType string
+	switch strings.ToLower(u.Scheme) {
+	case "oci":
+		repoType = RepositoryOCI
+	case "http", "https":
+		repoType = RepositoryHTTP
+	default:
+		return local
+	}
+
+	// never keep credentials embedded in repository URLs
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(port == "443" || (port == "80" && u.Scheme == "http")) {
+		host += ":" + port
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	path = strings.TrimSuffix(path, "/index.yaml")
+	path = strings.TrimSuffix(path, ".git")
+
+	return &Repository{
+		Type: repoType,
+		URL:  u.String(),
+		ID:   repoType + ":" + host + path,
+	}
+}
@@ -4,25 +4,39 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/infralight/k8s-collector/collector/config"
 )
 
-// Collector is a struct implementing the DataCollector interface. It wraps a
-// Helm SDK configuration object.
+// ConfigurationFunc returns a Helm SDK configuration object whose storage
+// backend is scoped to the provided namespace (or to all namespaces, if empty)
+type ConfigurationFunc func(namespace string) (*action.Configuration, error)
+
+// Collector is a struct implementing the DataCollector interface. It wraps
+// Helm SDK configuration objects, and a Kubernetes API client used to find
+// the namespaces from which releases are collected.
 type Collector struct {
-	sdkConfig *action.Configuration
+	sdkConfig ConfigurationFunc
+	api       kubernetes.Interface
 }
 
-// New creates a new instance of the Collector struct. A Helm SDK configuration
-// object must be provided.
-func New(sdkConfig *action.Configuration) *Collector {
+// New creates a new instance of the Collector struct. A function returning
+// Helm SDK configuration objects, and a Kubernetes API client, must be
+// provided.
+func New(sdkConfig ConfigurationFunc, api kubernetes.Interface) *Collector {
 	return &Collector{
 		sdkConfig: sdkConfig,
+		api:       api,
 	}
 }
 
@@ -34,19 +48,31 @@ func New(sdkConfig *action.Configuration) *Collector {
 // docs: https://helm.sh/docs/topics/advanced/#storage-backends
 func DefaultConfiguration(pf action.DebugLog) (c *Collector, err error) {
 	settings := cli.New()
-
-	conf := new(action.Configuration)
+	getter := settings.RESTClientGetter()
 
 	if pf == nil {
 		pf = log.Printf
 	}
 
-	err = conf.Init(settings.RESTClientGetter(), "", os.Getenv("HELM_DRIVER"), pf)
+	restConfig, err := getter.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed loading Kubernetes configuration: %w", err)
+	}
+
+	api, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed loading default Helm configuration: %w", err)
+		return nil, fmt.Errorf("failed creating Kubernetes client: %w", err)
 	}
 
-	return New(conf), nil
+	return New(func(namespace string) (*action.Configuration, error) {
+		conf := new(action.Configuration)
+		err := conf.Init(getter, namespace, os.Getenv("HELM_DRIVER"), pf)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading default Helm configuration: %w", err)
+		}
+
+		return conf, nil
+	}, api), nil
 }
 
 // Source is required by the DataCollector interface to return a name for the
@@ -55,28 +81,220 @@ func (c *Collector) Source() string {
 	return "Helm SDK"
 }
 
+// Release wraps a Helm release with additional information collected about
+// it. The release's own fields are embedded, so it is encoded to JSON in the
+// same format as a plain Helm release, plus the additional fields.
+type Release struct {
+	*release.Release
+
+	// ChartInfo summarizes the metadata of the release's chart, including its
+	// dependencies and (if known) the repository it was installed from
+	ChartInfo *ChartInfo `json:"chartInfo,omitempty"`
+
+	// ChartStatus compares the release's chart version with the versions
+	// available in the Helm repository indexes listed in
+	// config.Config.HelmRepositoryIndexes, if the chart is found in one.
+	// Releases created from Argo CD Applications are only checked if they
+	// install charts from Helm repositories
+	ChartStatus *ChartStatus `json:"chartStatus,omitempty"`
+
+	// History lists the revisions of the release, newest first, up to
+	// config.Config.HelmHistoryMax revisions
+	History []Revision `json:"history,omitempty"`
+
+	// StuckPending is true when the release has been in a pending state for
+	// longer than config.Config.HelmPendingTimeout
+	StuckPending bool `json:"stuckPending"`
+
+	// Size is the size of the release, in bytes, when encoded to JSON (after
+	// dropping chart files, redacting sensitive values and linking resources).
+	// It is computed once all filters have run (see filter.HelmSizeFilter)
+	Size int `json:"size"`
+
+	// Resources lists the resources rendered in the release's manifest, linked
+	// to the live objects collected from the cluster
+	Resources []LinkedResource `json:"resources,omitempty"`
+}
+
+// Revision is a summary of a single revision of a Helm release
+type Revision struct {
+	Revision      int            `json:"revision"`
+	ChartVersion  string         `json:"chartVersion,omitempty"`
+	AppVersion    string         `json:"appVersion,omitempty"`
+	Status        release.Status `json:"status"`
+	FirstDeployed helmtime.Time  `json:"firstDeployed"`
+	LastDeployed  helmtime.Time  `json:"lastDeployed"`
+	Deleted       helmtime.Time  `json:"deleted"`
+	Description   string         `json:"description,omitempty"`
+}
+
 // Run executes the collector with the provided configuration object, and
-// returns a list of collected Helm releases from the Kubernetes cluster.
-func (c *Collector) Run(ctx context.Context, _ *config.Config) (
+// returns a list of collected Helm releases from the Kubernetes cluster. The
+// latest revision of every release is collected, regardless of its status.
+// Releases are only read from the namespaces allowed by the configuration
+// (see config.Config.Namespace and config.Config.IgnoreNamespaces), so the
+// collector only requires permissions to list secrets in those namespaces.
+//
+// When config.Config.HelmSource is "objects", the collector does nothing, and
+// releases are decoded from the objects collected by the Kubernetes collector
+// instead (see filter.HelmStorageFilter).
+func (c *Collector) Run(ctx context.Context, conf *config.Config) (
 	keyName string,
 	data []interface{},
 	err error,
 ) {
+	if conf.HelmSource == SourceObjects {
+		log.Debug().Msg("Skipping Helm SDK, releases are read from collected objects")
+		return "helm_releases", []interface{}{}, nil
+	}
+
 	log.Debug().Msg("Starting collect Helm repositories")
-	client := action.NewList(c.sdkConfig)
-	client.Deployed = true
 
-	results, err := client.Run()
+	namespaces, err := c.namespaces(ctx, conf)
 	if err != nil {
-		return "helm_releases", data, fmt.Errorf("list failed: %w", err)
+		return "helm_releases", data, fmt.Errorf("failed listing namespaces: %w", err)
+	}
+
+	var revisions []*release.Release
+	failed := 0
+	for _, namespace := range namespaces {
+		nsRevisions, err := c.revisions(namespace)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("namespace", namespace).
+				Msg("Failed listing Helm releases in namespace")
+			failed++
+			continue
+		}
+
+		revisions = append(revisions, nsRevisions...)
 	}
 
-	releases := make([]interface{}, len(results))
-	for i, rel := range results {
-		releases[i] = rel
+	if failed > 0 && failed == len(namespaces) {
+		return "helm_releases", data, fmt.Errorf("list failed in all %d namespaces", failed)
 	}
 
-	log.Info().Int("amount", len(releases)).Msg("Finished collecting Helm repositories")
+	releases := BuildReleases(conf, revisions)
+
+	log.Info().
+		Int("amount", len(releases)).
+		Int("namespaces", len(namespaces)).
+		Msg("Finished collecting Helm repositories")
 
 	return "helm_releases", releases, nil
 }
+
+// namespaces returns the namespaces from which Helm releases are collected.
+// A single empty namespace is returned when releases are collected from all
+// namespaces at once.
+func (c *Collector) namespaces(ctx context.Context, conf *config.Config) ([]string, error) {
+	if conf.Namespace != "" {
+		return []string{conf.Namespace}, nil
+	}
+
+	if len(conf.IgnoreNamespaces) == 0 {
+		return []string{""}, nil
+	}
+
+	list, err := c.api.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make([]string, 0, len(list.Items))
+	for _, ns := range list.Items {
+		if !conf.IgnoreNamespace(ns.Name) {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+
+	return namespaces, nil
+}
+
+// revisions loads all revisions of all Helm releases in the provided namespace
+// from the storage backend, in a single request
+func (c *Collector) revisions(namespace string) ([]*release.Release, error) {
+	sdkConfig, err := c.sdkConfig(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdkConfig.Releases.List(func(*release.Release) bool {
+		return true
+	})
+}
+
+// BuildReleases accepts all revisions of a set of Helm releases, and returns
+// the latest revision of every release, shaped according to the provided
+// configuration and wrapped with the release's history. Release charts are
+// checked against the configured Helm repository indexes, if any.
+func BuildReleases(conf *config.Config, revisions []*release.Release) []interface{} {
+	releaseutil.Reverse(revisions, releaseutil.SortByRevision)
+
+	history := make(map[string][]Revision)
+	var latest []*release.Release
+	for _, rel := range revisions {
+		key := releaseKey(rel)
+		if _, ok := history[key]; !ok {
+			latest = append(latest, rel)
+			history[key] = []Revision{}
+		}
+
+		if len(history[key]) >= conf.HelmHistoryMax {
+			continue
+		}
+
+		history[key] = append(history[key], newRevision(rel))
+	}
+
+	releaseutil.SortByName(latest)
+
+	shaper := newShaper(conf)
+	indexes := LoadRepositoryIndexes(conf)
+
+	releases := make([]interface{}, len(latest))
+	for i, rel := range latest {
+		shaped := &Release{
+			Release:      shaper.shape(rel),
+			ChartInfo:    NewChartInfo(rel.Chart),
+			StuckPending: isStuckPending(rel, conf.HelmPendingTimeout),
+		}
+		shaped.CheckChart(indexes)
+		if len(history[releaseKey(rel)]) > 0 {
+			shaped.History = history[releaseKey(rel)]
+		}
+		releases[i] = shaped
+	}
+
+	return releases
+}
+
+func newRevision(rel *release.Release) Revision {
+	revision := Revision{Revision: rel.Version}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		revision.ChartVersion = rel.Chart.Metadata.Version
+		revision.AppVersion = rel.Chart.Metadata.AppVersion
+	}
+	if rel.Info != nil {
+		revision.Status = rel.Info.Status
+		revision.FirstDeployed = rel.Info.FirstDeployed
+		revision.LastDeployed = rel.Info.LastDeployed
+		revision.Deleted = rel.Info.Deleted
+		revision.Description = rel.Info.Description
+	}
+
+	return revision
+}
+
+func releaseKey(rel *release.Release) string {
+	return fmt.Sprintf("%s/%s", rel.Namespace, rel.Name)
+}
+
+func isStuckPending(rel *release.Release, timeout time.Duration) bool {
+	if rel.Info == nil || !rel.Info.Status.IsPending() {
+		return false
+	}
+
+	return time.Since(rel.Info.LastDeployed.Time) > timeout
+}
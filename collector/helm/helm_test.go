@@ -0,0 +1,50 @@
+package helm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+func TestNamespaces(t *testing.T) {
+	api := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+	)
+
+	var tests = []struct {
+		name          string
+		conf          config.Config
+		expNamespaces []string
+	}{
+		{
+			name:          "When no namespaces are configured, all namespaces should be read at once",
+			expNamespaces: []string{""},
+		},
+		{
+			name:          "When watching a namespace, only it should be read",
+			conf:          config.Config{Namespace: "apps", IgnoreNamespaces: []string{"kube-system"}},
+			expNamespaces: []string{"apps"},
+		},
+		{
+			name:          "When ignoring namespaces, all other namespaces should be read",
+			conf:          config.Config{IgnoreNamespaces: []string{"kube-system"}},
+			expNamespaces: []string{"apps", "default"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			namespaces, err := New(nil, api).namespaces(context.Background(), &test.conf)
+			assert.MustBeNil(t, err, "error must be nil")
+			assert.DeepEqual(t, test.expNamespaces, namespaces, "namespaces must match")
+		})
+	}
+}
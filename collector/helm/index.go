@@ -0,0 +1,260 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+// ChartStatus describes how the chart version of a Helm release compares to
+// the versions available in a Helm repository index.
+type ChartStatus struct {
+	// Repository is the repository whose index lists the chart
+	Repository *Repository `json:"repository,omitempty"`
+
+	// LatestVersion is the latest version of the chart in the index.
+	// Pre-release versions are only considered if the installed version is a
+	// pre-release itself
+	LatestVersion    string `json:"latestVersion"`
+	LatestAppVersion string `json:"latestAppVersion,omitempty"`
+
+	// Outdated is true if the installed version is older than LatestVersion
+	Outdated bool `json:"outdated"`
+
+	// VersionsBehind is the number of versions in the index that are newer
+	// than the installed version
+	VersionsBehind int `json:"versionsBehind"`
+
+	// Distance is the semantic version distance between the installed
+	// version and LatestVersion
+	Distance VersionDistance `json:"distance"`
+
+	// Yanked is true if the installed version was removed from the index
+	// (either marked as removed or missing altogether)
+	Yanked bool `json:"yanked"`
+
+	// Deprecated is true if the chart, or its installed version, is marked as
+	// deprecated in the index
+	Deprecated bool `json:"deprecated"`
+}
+
+// VersionDistance is the distance between two semantic versions. Only the
+// most significant differing component is set, e.g. the distance between
+// 1.2.3 and 2.0.1 is one major version.
+type VersionDistance struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+// RepositoryIndex is a Helm repository index file loaded from the local file
+// system
+type RepositoryIndex struct {
+	Path       string
+	Repository *Repository
+	index      *repo.IndexFile
+}
+
+// LoadRepositoryIndexes loads the Helm repository index files listed in
+// config.Config.HelmRepositoryIndexes. Files that fail loading are skipped.
+func LoadRepositoryIndexes(conf *config.Config) []*RepositoryIndex {
+	indexes := make([]*RepositoryIndex, 0, len(conf.HelmRepositoryIndexes))
+	for _, line := range conf.HelmRepositoryIndexes {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		idx, err := loadRepositoryIndex(conf, fields[0])
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("path", fields[0]).
+				Msg("Failed loading Helm repository index")
+			continue
+		}
+		if len(fields) > 1 {
+			idx.Repository = NormalizeRepository(fields[1])
+		}
+
+		indexes = append(indexes, idx)
+	}
+
+	return indexes
+}
+
+func loadRepositoryIndex(conf *config.Config, indexPath string) (*RepositoryIndex, error) {
+	data, err := conf.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var index repo.IndexFile
+	err = yaml.Unmarshal(data, &index)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing index: %w", err)
+	}
+	if index.Entries == nil {
+		return nil, fmt.Errorf("index has no entries")
+	}
+
+	// sort the versions of every chart from newest to oldest
+	index.SortEntries()
+
+	idx := &RepositoryIndex{Path: indexPath, index: &index}
+
+	// infer the repository from the absolute URL of any chart in the index
+	for _, versions := range index.Entries {
+		for _, version := range versions {
+			for _, chartURL := range version.URLs {
+				if strings.Contains(chartURL, "://") {
+					idx.Repository = NormalizeRepository(chartURL[:strings.LastIndex(chartURL, "/")])
+					return idx, nil
+				}
+			}
+		}
+	}
+
+	return idx, nil
+}
+
+// findIndex finds the index listing the chart of a release. If several indexes
+// list a chart by the same name, the index of the chart's repository (if
+// known) is preferred, followed by indexes listing the installed version.
+func findIndex(indexes []*RepositoryIndex, info *ChartInfo) *RepositoryIndex {
+	var candidates []*RepositoryIndex
+	for _, idx := range indexes {
+		if _, ok := idx.index.Entries[info.Name]; ok {
+			candidates = append(candidates, idx)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	if info.Repository != nil {
+		for _, idx := range candidates {
+			if idx.Repository != nil && idx.Repository.ID == info.Repository.ID {
+				return idx
+			}
+		}
+	}
+
+	var found *RepositoryIndex
+	for _, idx := range candidates {
+		if idx.index.Has(info.Name, info.Version) {
+			if found != nil {
+				// ambiguous
+				return nil
+			}
+			found = idx
+		}
+	}
+
+	return found
+}
+
+// CheckChart sets the chart status of a release from the repository index
+// listing its chart (see ChartStatus), if any. If the repository the chart was
+// installed from is unknown, it is set to the index's repository.
+func (rel *Release) CheckChart(indexes []*RepositoryIndex) {
+	if rel.ChartInfo == nil {
+		return
+	}
+
+	idx := findIndex(indexes, rel.ChartInfo)
+	if idx == nil {
+		return
+	}
+
+	rel.ChartStatus = idx.chartStatus(rel.ChartInfo)
+	if rel.ChartInfo.Repository == nil {
+		rel.ChartInfo.Repository = idx.Repository
+	}
+}
+
+// chartStatus compares the installed version of a chart with the versions
+// listed in the provided index
+func (idx *RepositoryIndex) chartStatus(info *ChartInfo) *ChartStatus {
+	versions := idx.index.Entries[info.Name]
+	status := &ChartStatus{Repository: idx.Repository}
+
+	installed, err := semver.NewVersion(info.Version)
+	if err != nil {
+		log.Debug().
+			Err(err).
+			Str("chart", info.Name).
+			Str("version", info.Version).
+			Msg("Installed chart version is not a semantic version")
+	}
+
+	var latest *semver.Version
+	listed := false
+	for _, entry := range versions {
+		if entry.Metadata == nil {
+			continue
+		}
+
+		if entry.Version == info.Version {
+			listed = true
+			status.Yanked = entry.Removed
+			status.Deprecated = status.Deprecated || entry.Deprecated
+		}
+		if entry.Removed {
+			continue
+		}
+
+		version, err := semver.NewVersion(entry.Version)
+		if err != nil {
+			continue
+		}
+		if version.Prerelease() != "" && (installed == nil || installed.Prerelease() == "") {
+			continue
+		}
+
+		if latest == nil {
+			// versions are sorted from newest to oldest
+			latest = version
+			status.LatestVersion = entry.Version
+			status.LatestAppVersion = entry.AppVersion
+			status.Deprecated = status.Deprecated || entry.Deprecated
+		}
+
+		if installed != nil && version.GreaterThan(installed) {
+			status.VersionsBehind++
+		}
+	}
+
+	if !listed && latest != nil && installed != nil && !installed.GreaterThan(latest) {
+		// the version is missing from the index, rather than newer than it
+		status.Yanked = true
+	}
+
+	if latest != nil && installed != nil && latest.GreaterThan(installed) {
+		status.Outdated = true
+		status.Distance = versionDistance(installed, latest)
+	}
+
+	return status
+}
+
+func versionDistance(from, to *semver.Version) VersionDistance {
+	switch {
+	case to.Major() != from.Major():
+		return VersionDistance{Major: int(to.Major()) - int(from.Major())}
+	case to.Minor() != from.Minor():
+		return VersionDistance{Minor: int(to.Minor()) - int(from.Minor())}
+	default:
+		return VersionDistance{Patch: int(to.Patch()) - int(from.Patch())}
+	}
+}
@@ -0,0 +1,134 @@
+package helm
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+const testIndex = `apiVersion: v1
+entries:
+  redis:
+    - name: redis
+      version: 16.1.0
+      appVersion: 6.2.6
+      urls: [https://charts.example.com/stable/redis-16.1.0.tgz]
+    - name: redis
+      version: 17.0.0-rc.1
+      urls: [https://charts.example.com/stable/redis-17.0.0-rc.1.tgz]
+    - name: redis
+      version: 16.0.1
+      urls: [https://charts.example.com/stable/redis-16.0.1.tgz]
+    - name: redis
+      version: 16.0.0
+      removed: true
+      urls: [https://charts.example.com/stable/redis-16.0.0.tgz]
+    - name: redis
+      version: 15.7.0
+      urls: [https://charts.example.com/stable/redis-15.7.0.tgz]
+  legacy:
+    - name: legacy
+      version: 2.0.0
+      deprecated: true
+      urls: [https://charts.example.com/stable/legacy-2.0.0.tgz]
+`
+
+func TestChartStatus(t *testing.T) {
+	logger := zerolog.Nop()
+
+	conf := &config.Config{
+		Log:                   &logger,
+		FS:                    fstest.MapFS{"etc/config/index.yaml": &fstest.MapFile{Data: []byte(testIndex)}},
+		ConfigDir:             config.DefaultConfigDir,
+		HelmRepositoryIndexes: []string{"index.yaml", "missing.yaml"},
+	}
+
+	indexes := LoadRepositoryIndexes(conf)
+	assert.MustBeEqual(t, 1, len(indexes), "a single index must be loaded")
+	assert.DeepEqual(t, NormalizeRepository("https://charts.example.com/stable"), indexes[0].Repository, "repository must be inferred")
+
+	var tests = []struct {
+		name      string
+		chart     string
+		version   string
+		expStatus *ChartStatus
+	}{
+		{
+			name:    "When a newer version exists, the chart should be outdated",
+			chart:   "redis",
+			version: "15.7.0",
+			expStatus: &ChartStatus{
+				LatestVersion:    "16.1.0",
+				LatestAppVersion: "6.2.6",
+				Outdated:         true,
+				VersionsBehind:   2,
+				Distance:         VersionDistance{Major: 1},
+			},
+		},
+		{
+			name:    "When the installed version was removed, the chart should be yanked",
+			chart:   "redis",
+			version: "16.0.0",
+			expStatus: &ChartStatus{
+				LatestVersion:    "16.1.0",
+				LatestAppVersion: "6.2.6",
+				Outdated:         true,
+				VersionsBehind:   2,
+				Distance:         VersionDistance{Minor: 1},
+				Yanked:           true,
+			},
+		},
+		{
+			name:    "When the installed version is the latest, the chart should be up to date",
+			chart:   "redis",
+			version: "16.1.0",
+			expStatus: &ChartStatus{
+				LatestVersion:    "16.1.0",
+				LatestAppVersion: "6.2.6",
+			},
+		},
+		{
+			name:    "When the installed version is newer than the index, it should not be yanked",
+			chart:   "redis",
+			version: "16.2.0",
+			expStatus: &ChartStatus{
+				LatestVersion:    "16.1.0",
+				LatestAppVersion: "6.2.6",
+			},
+		},
+		{
+			name:    "When the chart is deprecated, it should be flagged",
+			chart:   "legacy",
+			version: "2.0.0",
+			expStatus: &ChartStatus{
+				LatestVersion: "2.0.0",
+				Deprecated:    true,
+			},
+		},
+		{
+			name:    "When the chart is not in any index, there should be no status",
+			chart:   "nginx",
+			version: "1.0.0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := &ChartInfo{Name: test.chart, Version: test.version}
+
+			var status *ChartStatus
+			if idx := findIndex(indexes, info); idx != nil {
+				status = idx.chartStatus(info)
+			}
+
+			if test.expStatus != nil {
+				test.expStatus.Repository = indexes[0].Repository
+			}
+			assert.DeepEqual(t, test.expStatus, status, "status must match")
+		})
+	}
+}
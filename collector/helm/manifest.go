@@ -0,0 +1,107 @@
+package helm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// ManifestResource is a single Kubernetes resource rendered in the manifest of
+// a Helm release.
+type ManifestResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// LinkedResource is a resource rendered in the manifest of a Helm release,
+// linked to the live object it created in the cluster (if it exists).
+type LinkedResource struct {
+	ManifestResource
+
+	// UID is the unique identifier of the live object
+	UID string `json:"uid,omitempty"`
+
+	// Missing is true when the resource does not exist in the cluster
+	Missing bool `json:"missing"`
+}
+
+// GroupVersionKind returns the group, version and kind of the resource
+func (r ManifestResource) GroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(r.APIVersion, r.Kind)
+}
+
+// ManifestObject is a single Kubernetes resource rendered in the manifest of a
+// Helm release, along with its full content.
+type ManifestObject struct {
+	ManifestResource
+
+	// Object is the rendered object, as decoded from the manifest
+	Object map[string]interface{}
+}
+
+// ParseManifest parses the manifest of a Helm release (a multi-document YAML
+// string) into the list of resources it renders, in the order they appear in
+// the manifest. Empty documents and documents that cannot be parsed as
+// Kubernetes objects are skipped.
+func ParseManifest(manifest string) []ManifestResource {
+	objects := ParseManifestObjects(manifest)
+
+	resources := make([]ManifestResource, len(objects))
+	for i, obj := range objects {
+		resources[i] = obj.ManifestResource
+	}
+
+	return resources
+}
+
+// ParseManifestObjects is similar to ParseManifest, but also returns the full
+// content of every rendered object.
+func ParseManifestObjects(manifest string) []ManifestObject {
+	docs := releaseutil.SplitManifests(manifest)
+
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	objects := make([]ManifestObject, 0, len(docs))
+	for _, key := range keys {
+		var doc map[string]interface{}
+		err := yaml.Unmarshal([]byte(docs[key]), &doc)
+		if err != nil {
+			log.Debug().
+				Err(err).
+				Msg("Skipping manifest document that failed parsing")
+			continue
+		}
+
+		apiVersion, _ := doc["apiVersion"].(string)
+		kind, _ := doc["kind"].(string)
+		if apiVersion == "" || kind == "" {
+			continue
+		}
+
+		metadata, _ := doc["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		namespace, _ := metadata["namespace"].(string)
+
+		objects = append(objects, ManifestObject{
+			ManifestResource: ManifestResource{
+				APIVersion: strings.TrimSpace(apiVersion),
+				Kind:       strings.TrimSpace(kind),
+				Namespace:  namespace,
+				Name:       name,
+			},
+			Object: doc,
+		})
+	}
+
+	return objects
+}
@@ -0,0 +1,337 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/yaml"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+// Methods of redacting sensitive Helm values
+const (
+	RedactionMask = "mask"
+	RedactionHash = "hash"
+	RedactionNone = "none"
+)
+
+// redactedValue is the value replacing sensitive Helm values when masking them
+const redactedValue = "[REDACTED]"
+
+// hashedValuePrefix prefixes the hashes replacing sensitive Helm values when
+// hashing them
+const hashedValuePrefix = "sha256:"
+
+// manifestSeparator matches the lines separating the documents of a Helm
+// release manifest
+var manifestSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// shaper modifies Helm releases before they are sent to Firefly, according to
+// the collector's configuration: it drops chart templates and files, redacts
+// sensitive values (and the values of Secrets rendered in the manifest), and
+// optionally reduces values to their diff from the chart's defaults.
+type shaper struct {
+	conf          *config.Config
+	sensitiveKeys []*regexp.Regexp
+}
+
+func newShaper(conf *config.Config) *shaper {
+	s := &shaper{conf: conf}
+
+	if conf.HelmRedaction == RedactionNone {
+		return s
+	}
+
+	for _, pattern := range conf.HelmSensitiveKeys {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("pattern", pattern).
+				Msg("Ignoring invalid sensitive Helm values pattern")
+			continue
+		}
+		s.sensitiveKeys = append(s.sensitiveKeys, re)
+	}
+
+	return s
+}
+
+// shape returns a shaped copy of the provided release. The original release is
+// not modified.
+func (s *shaper) shape(rel *release.Release) *release.Release {
+	shaped := *rel
+
+	if rel.Chart != nil {
+		shapedChart := *rel.Chart
+		if !s.conf.HelmKeepChartFiles {
+			shapedChart.Templates = nil
+			shapedChart.Files = nil
+			shapedChart.Schema = nil
+		}
+		shaped.Chart = &shapedChart
+	}
+
+	if s.conf.HelmValuesDiffOnly && shaped.Chart != nil {
+		computed, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("release", releaseKey(rel)).
+				Msg("Failed computing Helm release values, keeping user-supplied values")
+		} else {
+			shaped.Config = diffValues(computed, rel.Chart.Values)
+			shaped.Chart.Values = nil
+		}
+	}
+
+	shaped.Config = s.redact(shaped.Config)
+	if shaped.Chart != nil {
+		shaped.Chart.Values = s.redact(shaped.Chart.Values)
+	}
+
+	if s.conf.HelmRedaction != RedactionNone {
+		shaped.Manifest = s.redactManifest(rel.Manifest)
+	}
+
+	return &shaped
+}
+
+// redactManifest returns the provided manifest with the values of all Secrets
+// redacted, as they are usually rendered from sensitive values. Documents
+// other than Secrets, and the separators and comments between documents, are
+// kept as is.
+func (s *shaper) redactManifest(manifest string) string {
+	if !strings.Contains(manifest, "Secret") {
+		return manifest
+	}
+
+	var b strings.Builder
+	start := 0
+	separators := append(manifestSeparator.FindAllStringIndex(manifest, -1), []int{len(manifest), len(manifest)})
+	for _, separator := range separators {
+		b.WriteString(s.redactManifestDocument(manifest[start:separator[0]]))
+		b.WriteString(manifest[separator[0]:separator[1]])
+		start = separator[1]
+	}
+
+	return b.String()
+}
+
+// redactManifestDocument redacts the data and stringData values of a single
+// manifest document if it is a Secret, keeping its leading comments (e.g. the
+// "# Source:" comment added by Helm)
+func (s *shaper) redactManifestDocument(doc string) string {
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal([]byte(doc), &obj); err != nil || obj["kind"] != "Secret" {
+		return doc
+	}
+
+	for _, key := range []string{"data", "stringData"} {
+		values, ok := obj[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, value := range values {
+			values[name] = s.redactValue(value)
+		}
+	}
+
+	encoded, err := yaml.Marshal(obj)
+	if err != nil {
+		// never send a Secret that could not be redacted
+		return "\n"
+	}
+
+	var prefix strings.Builder
+	for _, line := range strings.SplitAfter(doc, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		prefix.WriteString(line)
+	}
+	if prefix.Len() == 0 {
+		prefix.WriteString("\n")
+	}
+
+	return prefix.String() + string(encoded)
+}
+
+// RedactedEqual compares a value that may have been redacted by the collector
+// (see config.Config.HelmRedaction) to a live value. Hashed values are
+// compared to the hash of the live value, and masked values are assumed to be
+// equal to it. The second return value is false if the value is not redacted.
+func RedactedEqual(value, live interface{}) (equal bool, redacted bool) {
+	str, ok := value.(string)
+	switch {
+	case !ok:
+		return false, false
+	case str == redactedValue:
+		return true, true
+	case strings.HasPrefix(str, hashedValuePrefix):
+		encoded, _ := json.Marshal(live)
+		return str == hashValue(encoded), true
+	}
+
+	return false, false
+}
+
+func hashValue(encoded []byte) string {
+	return fmt.Sprintf("%s%x", hashedValuePrefix, sha256.Sum256(encoded))
+}
+
+// redact returns a copy of the provided values, with the values of all keys
+// matching one of the sensitive key patterns redacted
+func (s *shaper) redact(values map[string]interface{}) map[string]interface{} {
+	if values == nil || len(s.sensitiveKeys) == 0 {
+		return values
+	}
+
+	redacted := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if s.isSensitive(key) {
+			redacted[key] = s.redactValue(value)
+			continue
+		}
+
+		redacted[key] = s.redactNested(value)
+	}
+
+	return redacted
+}
+
+func (s *shaper) redactNested(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return s.redact(typed)
+	case chartutil.Values:
+		return s.redact(typed)
+	case []interface{}:
+		list := make([]interface{}, len(typed))
+		for i, item := range typed {
+			list[i] = s.redactNested(item)
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+func (s *shaper) redactValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if typed == "" {
+			return typed
+		}
+	case bool:
+		// booleans such as "existingSecret: false" are not sensitive
+		return typed
+	case map[string]interface{}, chartutil.Values, []interface{}:
+		// redact every value nested under a sensitive key
+		return s.redactAll(typed)
+	}
+
+	if s.conf.HelmRedaction == RedactionHash {
+		encoded, _ := json.Marshal(value)
+		return hashValue(encoded)
+	}
+
+	return redactedValue
+}
+
+func (s *shaper) redactAll(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(typed))
+		for key, nested := range typed {
+			redacted[key] = s.redactAll(nested)
+		}
+		return redacted
+	case chartutil.Values:
+		return s.redactAll(map[string]interface{}(typed))
+	case []interface{}:
+		list := make([]interface{}, len(typed))
+		for i, item := range typed {
+			list[i] = s.redactAll(item)
+		}
+		return list
+	default:
+		return s.redactValue(value)
+	}
+}
+
+func (s *shaper) isSensitive(key string) bool {
+	for _, re := range s.sensitiveKeys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diffValues returns the values from computed that differ from the values in
+// defaults. Nested maps are compared recursively.
+func diffValues(computed, defaults map[string]interface{}) map[string]interface{} {
+	diff := make(map[string]interface{})
+	for key, value := range computed {
+		defaultValue, ok := defaults[key]
+		if !ok {
+			diff[key] = value
+			continue
+		}
+
+		valueMap, valueIsMap := asMap(value)
+		defaultMap, defaultIsMap := asMap(defaultValue)
+		if valueIsMap && defaultIsMap {
+			if nested := diffValues(valueMap, defaultMap); len(nested) > 0 {
+				diff[key] = nested
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(value, defaultValue) {
+			diff[key] = value
+		}
+	}
+
+	return diff
+}
+
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return typed, true
+	case chartutil.Values:
+		return typed, true
+	default:
+		return nil, false
+	}
+}
+
+// ReleaseSize returns the size, in bytes, of the provided release when encoded
+// to JSON
+func ReleaseSize(rel *Release) int {
+	encoded, err := json.Marshal(rel)
+	if err != nil {
+		return 0
+	}
+
+	return len(encoded)
+}
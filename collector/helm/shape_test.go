@@ -0,0 +1,205 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+func TestShape(t *testing.T) {
+	logger := zerolog.Nop()
+
+	newRelease := func() *release.Release {
+		return &release.Release{
+			Name:      "db",
+			Namespace: "default",
+			Config: map[string]interface{}{
+				"replicas": 3,
+				"auth": map[string]interface{}{
+					"username":       "admin",
+					"password":       "hunter2",
+					"existingSecret": false,
+				},
+				"extraEnv": []interface{}{
+					map[string]interface{}{"name": "API_TOKEN", "apiToken": "abc"},
+				},
+			},
+			Chart: &chart.Chart{
+				Metadata:  &chart.Metadata{Name: "db", Version: "1.0.0"},
+				Templates: []*chart.File{{Name: "templates/deployment.yaml"}},
+				Files:     []*chart.File{{Name: "README.md"}},
+				Values: map[string]interface{}{
+					"replicas": 1,
+					"auth": map[string]interface{}{
+						"username": "admin",
+						"password": "",
+					},
+				},
+			},
+		}
+	}
+
+	var tests = []struct {
+		name      string
+		conf      config.Config
+		expConfig map[string]interface{}
+		expValues map[string]interface{}
+		expFiles  bool
+	}{
+		{
+			name: "When using defaults, chart files should be dropped and sensitive values masked",
+			conf: config.Config{
+				HelmSensitiveKeys: config.DefaultHelmSensitiveKeys,
+				HelmRedaction:     RedactionMask,
+			},
+			expConfig: map[string]interface{}{
+				"replicas": 3,
+				"auth": map[string]interface{}{
+					"username":       "admin",
+					"password":       redactedValue,
+					"existingSecret": false,
+				},
+				"extraEnv": []interface{}{
+					map[string]interface{}{"name": "API_TOKEN", "apiToken": redactedValue},
+				},
+			},
+			expValues: map[string]interface{}{
+				"replicas": 1,
+				"auth": map[string]interface{}{
+					"username": "admin",
+					"password": "",
+				},
+			},
+		},
+		{
+			name: "When hashing, sensitive values should be replaced with their hash",
+			conf: config.Config{
+				HelmSensitiveKeys:  []string{"^password$"},
+				HelmRedaction:      RedactionHash,
+				HelmKeepChartFiles: true,
+				HelmValuesDiffOnly: true,
+			},
+			expConfig: map[string]interface{}{
+				"replicas": 3,
+				"auth": map[string]interface{}{
+					"password":       "sha256:4ddbb67bf993867e13253c146a339ed3b33ea5b895543569278e99d5b3c2b7d5",
+					"existingSecret": false,
+				},
+				"extraEnv": []interface{}{
+					map[string]interface{}{"name": "API_TOKEN", "apiToken": "abc"},
+				},
+			},
+			expFiles: true,
+		},
+		{
+			name: "When redaction is disabled, values should be kept as-is",
+			conf: config.Config{
+				HelmSensitiveKeys: config.DefaultHelmSensitiveKeys,
+				HelmRedaction:     RedactionNone,
+			},
+			expConfig: newRelease().Config,
+			expValues: newRelease().Chart.Values,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.conf.Log = &logger
+			rel := newRelease()
+
+			shaped := newShaper(&test.conf).shape(rel)
+			assert.DeepEqual(t, test.expConfig, shaped.Config, "values must match")
+			assert.DeepEqual(t, test.expValues, shaped.Chart.Values, "chart values must match")
+			assert.Equal(t, test.expFiles, shaped.Chart.Templates != nil, "templates must be kept or dropped")
+			assert.Equal(t, test.expFiles, shaped.Chart.Files != nil, "files must be kept or dropped")
+			assert.DeepEqual(t, newRelease(), rel, "original release must not be modified")
+		})
+	}
+}
+
+func TestShapeManifest(t *testing.T) {
+	logger := zerolog.Nop()
+
+	// "aHVudGVyMg==" is "hunter2" as rendered by b64enc
+	manifest := `---
+# Source: db/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+data:
+  password: aHVudGVyMg==
+stringData:
+  token: hunter2
+---
+# Source: db/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: db
+data:
+  username: admin
+`
+
+	var tests = []struct {
+		name     string
+		conf     config.Config
+		expected string
+	}{
+		{
+			name: "When masking, secret values in the manifest should be masked",
+			conf: config.Config{HelmRedaction: RedactionMask},
+			expected: `---
+# Source: db/templates/secret.yaml
+apiVersion: v1
+data:
+  password: '[REDACTED]'
+kind: Secret
+metadata:
+  name: db
+stringData:
+  token: '[REDACTED]'
+---
+# Source: db/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: db
+data:
+  username: admin
+`,
+		},
+		{
+			name:     "When redaction is disabled, the manifest should be kept as-is",
+			conf:     config.Config{HelmRedaction: RedactionNone},
+			expected: manifest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.conf.Log = &logger
+			rel := &release.Release{Name: "db", Namespace: "default", Manifest: manifest}
+
+			shaped := newShaper(&test.conf).shape(rel)
+			assert.Equal(t, test.expected, shaped.Manifest, "manifest must match")
+			assert.Equal(t, manifest, rel.Manifest, "original manifest must not be modified")
+		})
+	}
+
+	shaped := newShaper(&config.Config{Log: &logger, HelmRedaction: RedactionHash}).
+		shape(&release.Release{Manifest: manifest})
+	for _, secret := range []string{"hunter2", "aHVudGVyMg=="} {
+		assert.False(t, strings.Contains(shaped.Manifest, secret), "hashed manifest must not include secret values")
+	}
+
+	equal, redacted := RedactedEqual(ParseManifestObjects(shaped.Manifest)[0].Object["data"].(map[string]interface{})["password"], "aHVudGVyMg==")
+	assert.True(t, redacted, "hashed value must be recognized")
+	assert.True(t, equal, "hashed value must equal the live value")
+}
@@ -0,0 +1,64 @@
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// Sources from which Helm releases are read
+const (
+	SourceSDK     = "sdk"
+	SourceObjects = "objects"
+)
+
+// StorageOwnerLabel is the label (and its value) set by Helm on the secrets
+// and configmaps in which releases are stored
+const (
+	StorageOwnerLabel = "owner"
+	StorageOwner      = "helm"
+)
+
+// StorageSecretType is the type of secrets in which Helm releases are stored
+const StorageSecretType = "helm.sh/release.v1"
+
+// gzipMagic is the header of gzip-compressed data
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// DecodeRelease decodes a Helm release from the "release" key of a Helm
+// storage secret or configmap. The value is expected in the format written by
+// Helm's storage drivers: a base64-encoded, optionally gzip-compressed, JSON
+// document. Note that the data of secrets is base64-encoded once more by the
+// Kubernetes API, and must be decoded before calling this function.
+func DecodeRelease(data string) (*release.Release, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding release: %w", err)
+	}
+
+	if bytes.HasPrefix(b, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("failed decompressing release: %w", err)
+		}
+		defer r.Close()
+
+		b, err = io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed decompressing release: %w", err)
+		}
+	}
+
+	var rel release.Release
+	err = json.Unmarshal(b, &rel)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing release: %w", err)
+	}
+
+	return &rel, nil
+}
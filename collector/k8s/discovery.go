@@ -0,0 +1,68 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"k8s.io/client-go/discovery"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+// ErrTooManyDiscoveryFailures is an error returned when more API group-versions
+// failed discovery than allowed by config.Config.MaxDiscoveryFailures
+var ErrTooManyDiscoveryFailures = errors.New("too many API group-versions failed discovery")
+
+// UnavailableAPI is an API group-version that the API server failed to provide
+// discovery information for, usually because the aggregated API server serving
+// it is down. Resources of unavailable APIs are not collected.
+type UnavailableAPI struct {
+	GroupVersion string `json:"groupVersion"`
+	Error        string `json:"error"`
+}
+
+// HandleDiscoveryError accepts an error returned from one of the discovery
+// client's methods. If the error indicates that discovery only failed for some
+// API group-versions, the partial results returned with it can still be used:
+// the failing group-versions are returned as a list of unavailable APIs, and a
+// nil error is returned, as long as the number of failures does not exceed the
+// configured threshold. Any other error is returned as-is. Unavailable APIs are
+// not logged, as discovery is performed by multiple collectors.
+func HandleDiscoveryError(conf *config.Config, err error) (
+	unavailable []UnavailableAPI,
+	retErr error,
+) {
+	if err == nil {
+		return nil, nil
+	}
+
+	var failed *discovery.ErrGroupDiscoveryFailed
+	if !errors.As(err, &failed) {
+		return nil, err
+	}
+
+	unavailable = make([]UnavailableAPI, 0, len(failed.Groups))
+	for groupVersion, groupErr := range failed.Groups {
+		unavailable = append(unavailable, UnavailableAPI{
+			GroupVersion: groupVersion.String(),
+			Error:        groupErr.Error(),
+		})
+	}
+
+	sort.Slice(unavailable, func(i, j int) bool {
+		return unavailable[i].GroupVersion < unavailable[j].GroupVersion
+	})
+
+	if conf.MaxDiscoveryFailures >= 0 && len(unavailable) > conf.MaxDiscoveryFailures {
+		return unavailable, fmt.Errorf(
+			"%w (%d failed, %d allowed): %s",
+			ErrTooManyDiscoveryFailures,
+			len(unavailable),
+			conf.MaxDiscoveryFailures,
+			err,
+		)
+	}
+
+	return unavailable, nil
+}
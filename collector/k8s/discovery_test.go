@@ -0,0 +1,89 @@
+package k8s
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+func TestHandleDiscoveryError(t *testing.T) {
+	logger := zerolog.Nop()
+
+	metricsErr := errors.New("the server is currently unable to handle the request")
+	groupErr := &discovery.ErrGroupDiscoveryFailed{
+		Groups: map[schema.GroupVersion]error{
+			{Group: "metrics.k8s.io", Version: "v1beta1"}:   metricsErr,
+			{Group: "custom.metrics.k8s.io", Version: "v1"}: metricsErr,
+		},
+	}
+	otherErr := errors.New("connection refused")
+
+	var tests = []struct {
+		name           string
+		err            error
+		maxFailures    int
+		expUnavailable []UnavailableAPI
+		expErr         error
+	}{
+		{
+			name: "When there is no error, nothing should be unavailable",
+		},
+		{
+			name:   "When discovery failed entirely, the error should be returned as-is",
+			err:    otherErr,
+			expErr: otherErr,
+		},
+		{
+			name:        "When failures are within the threshold, unavailable APIs should be returned without error",
+			err:         groupErr,
+			maxFailures: 2,
+			expUnavailable: []UnavailableAPI{
+				{GroupVersion: "custom.metrics.k8s.io/v1", Error: metricsErr.Error()},
+				{GroupVersion: "metrics.k8s.io/v1beta1", Error: metricsErr.Error()},
+			},
+		},
+		{
+			name:        "When the threshold is negative, failures should never be fatal",
+			err:         groupErr,
+			maxFailures: -1,
+			expUnavailable: []UnavailableAPI{
+				{GroupVersion: "custom.metrics.k8s.io/v1", Error: metricsErr.Error()},
+				{GroupVersion: "metrics.k8s.io/v1beta1", Error: metricsErr.Error()},
+			},
+		},
+		{
+			name:        "When failures exceed the threshold, an error should be returned",
+			err:         groupErr,
+			maxFailures: 1,
+			expUnavailable: []UnavailableAPI{
+				{GroupVersion: "custom.metrics.k8s.io/v1", Error: metricsErr.Error()},
+				{GroupVersion: "metrics.k8s.io/v1beta1", Error: metricsErr.Error()},
+			},
+			expErr: ErrTooManyDiscoveryFailures,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := &config.Config{
+				Log:                  &logger,
+				MaxDiscoveryFailures: test.maxFailures,
+			}
+
+			unavailable, err := HandleDiscoveryError(conf, test.err)
+			if test.expErr != nil {
+				assert.MustNotBeNil(t, err, "error must not be nil")
+				assert.True(t, errors.Is(err, test.expErr), "error must match")
+			} else {
+				assert.MustBeNil(t, err, "error must be nil")
+			}
+			assert.DeepEqual(t, test.expUnavailable, unavailable, "unavailable APIs must match")
+		})
+	}
+}
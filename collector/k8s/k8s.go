@@ -2,49 +2,94 @@ package k8s
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/rs/zerolog/log"
-	"k8s.io/client-go/kubernetes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
 
 	"github.com/infralight/k8s-collector/collector/config"
 )
 
-// Collector is a struct implementing the DataCollector interface. It wraps a
-// Kubernetes API client object.
+// Collector is a struct implementing the DataCollector interface. It wraps
+// the discovery, dynamic and metadata clients of the Kubernetes API.
 type Collector struct {
-	// client object for the Kubernetes API server
-	api kubernetes.Interface
+	// discovery client for the Kubernetes API server
+	discovery discovery.DiscoveryInterface
+
+	// dynamic client for the Kubernetes API server, used to list full objects
+	dynamic dynamic.Interface
+
+	// metadata client for the Kubernetes API server, used to list objects of
+	// resource types that are configured for metadata-only collection
+	metadata metadata.Interface
+
+	// API group-versions that failed discovery during the last run
+	unavailableAPIs []UnavailableAPI
+
+	// served and used versions of each resource type during the last run
+	// (only when collecting all versions)
+	versions map[schema.GroupResource]*ResourceVersions
+
+	// outcome of collecting each resource type during the last run
+	statuses []CollectionStatus
 }
 
-// New creates a new instance of the Collector struct. A Kubernetes API client
-// object must be provided. This can either be a client for a real API server,
-// a fake client from k8s.io/client-go/kubernetes/fake, or any object that
-// implements the kubernetes.Interface interface.
-func New(api kubernetes.Interface) *Collector {
+// New creates a new instance of the Collector struct. Discovery, dynamic and
+// metadata clients must be provided. These can either be clients for a real
+// API server, fake clients from k8s.io/client-go/discovery/fake,
+// k8s.io/client-go/dynamic/fake and k8s.io/client-go/metadata/fake, or any
+// objects implementing the respective interfaces.
+func New(
+	discoveryClient discovery.DiscoveryInterface,
+	dynamicClient dynamic.Interface,
+	metadataClient metadata.Interface,
+) *Collector {
 	return &Collector{
-		api: api,
+		discovery: discoveryClient,
+		dynamic:   dynamicClient,
+		metadata:  metadataClient,
 	}
 }
 
 // DefaultConfiguration creates a Collector instance with default configuration
 // to connect to a local Kubernetes API Server. When running outside of the
 // Kubernetes cluster, the path to the kubeconfig file must be provided. If
-// empty, the default in-cluster configuration is used.
+// empty, the default in-cluster configuration is used. The dynamic and
+// metadata clients count the sizes of list responses, which are reported in
+// the collection status of each resource type.
 func DefaultConfiguration(apiConfig *rest.Config) (
 	collector *Collector,
 	err error,
 ) {
-	// Create a new instance of the Kubernetes API client
-	api, err := kubernetes.NewForConfig(apiConfig)
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(apiConfig)
+	if err != nil {
+		return collector, fmt.Errorf("failed getting K8s discovery client: %w", err)
+	}
+
+	listConfig := rest.CopyConfig(apiConfig)
+	listConfig.WrapTransport = transport.Wrappers(listConfig.WrapTransport, countResponseSize)
+
+	dynamicClient, err := dynamic.NewForConfig(listConfig)
+	if err != nil {
+		return collector, fmt.Errorf("failed getting K8s dynamic client: %w", err)
+	}
+
+	metadataClient, err := metadata.NewForConfig(listConfig)
 	if err != nil {
-		return collector, fmt.Errorf("failed getting K8s client set: %w", err)
+		return collector, fmt.Errorf("failed getting K8s metadata client: %w", err)
 	}
 
-	return New(api), nil
+	return New(discoveryClient, dynamicClient, metadataClient), nil
 }
 
 // Source is required by the DataCollector interface to return a name for the
@@ -53,18 +98,57 @@ func (f *Collector) Source() string {
 	return "K8s API Server"
 }
 
-// Object is a pointless struct type that we have no choice but create due to
-// an issue with how the official Kubernetes client encodes objects to JSON.
-// The "Kind" attribute that each object has is in an embedded struct that is
-// set with the following struct tag: json:",inline". The problem is that the
-// "inline" struct tag is still in proposal status and not supported by Go,
-// (see here: https://github.com/golang/go/issues/6213), and so JSON objects are
-// missing the "kind" attribute. This is just a workaround to ensure we also
-// send the kind.
-
+// KubernetesObject wraps a single object collected from the Kubernetes API
+// server. The object itself is kept as-is in its unstructured form, while the
+// attributes identifying it (its group, version, resource, kind, namespace,
+// name, UID and resource version) are provided as typed fields, so consumers
+// do not have to dig them out of the object.
 type KubernetesObject struct {
-	Kind   string      `json:"kind"`
-	Object interface{} `json:"object"`
+	Group           string                 `json:"group"`
+	Version         string                 `json:"version"`
+	Resource        string                 `json:"resource"`
+	Kind            string                 `json:"kind"`
+	Namespace       string                 `json:"namespace,omitempty"`
+	Name            string                 `json:"name"`
+	UID             string                 `json:"uid"`
+	ResourceVersion string                 `json:"resourceVersion"`
+	Object          map[string]interface{} `json:"object"`
+
+	// MetadataOnly is true when only the object's metadata was requested from
+	// the API server (see config.Config.MetadataOnlyResources), meaning the
+	// spec and status were intentionally omitted
+	MetadataOnly bool `json:"metadataOnly,omitempty"`
+
+	// ManagedFieldsAPIVersions is the list of API versions used by the
+	// object's managed fields (only when config.Config.CollectAllVersions is
+	// enabled)
+	ManagedFieldsAPIVersions []string `json:"managedFieldsApiVersions,omitempty"`
+
+	// HelmRelease is the Helm release whose manifest renders the object, if
+	// any
+	HelmRelease *ReleaseRef `json:"helmRelease,omitempty"`
+}
+
+// ReleaseRef identifies a specific revision of a Helm release
+type ReleaseRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Revision  int    `json:"revision"`
+}
+
+// GroupVersionResource returns the group, version and resource of the object
+func (o KubernetesObject) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    o.Group,
+		Version:  o.Version,
+		Resource: o.Resource,
+	}
+}
+
+// APIVersion returns the object's API version, in the format used in the
+// "apiVersion" field of Kubernetes objects (e.g. "v1" or "apps/v1")
+func (o KubernetesObject) APIVersion() string {
+	return schema.GroupVersion{Group: o.Group, Version: o.Version}.String()
 }
 
 // Run executes the collector with the provided configuration object, and
@@ -76,19 +160,42 @@ func (f *Collector) Run(ctx context.Context, conf *config.Config) (
 ) {
 	log.Debug().Msg("Starting collect Kubernetes objects")
 
-	apiResourcesList, err := f.api.Discovery().ServerPreferredResources()
+	apiResourcesList, err := f.discovery.ServerPreferredResources()
+	f.unavailableAPIs, err = HandleDiscoveryError(conf, err)
+	for _, api := range f.unavailableAPIs {
+		log.Warn().
+			Str("error", api.Error).
+			Str("ApiVersion", api.GroupVersion).
+			Msg("API is unavailable, its resources will not be collected")
+	}
 	if err != nil {
 		return "k8s_objects", nil, fmt.Errorf("failed receiving Kubernetes resources: %w", err)
 	}
 
+	f.statuses = nil
+	f.versions = nil
+	if conf.CollectAllVersions {
+		f.versions = f.resourceVersions(apiResourcesList)
+	}
+
 	for _, apiResource := range apiResourcesList {
+		groupVersion, err := schema.ParseGroupVersion(apiResource.GroupVersion)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("ApiVersion", apiResource.GroupVersion).
+				Msg("Ignoring resources with invalid group version")
+			continue
+		}
+
 		for _, resource := range apiResource.APIResources {
-			var uri string
-			if apiResource.GroupVersion == "v1" && apiResource.APIVersion == "" {
-				// The URL for api v1 is different from the external apis
-				uri = "api/v1"
-			} else {
-				uri = fmt.Sprintf("apis/%s", apiResource.GroupVersion)
+			gvr := groupVersion.WithResource(resource.Name)
+
+			status := CollectionStatus{
+				Group:    gvr.Group,
+				Version:  gvr.Version,
+				Resource: gvr.Resource,
+				Kind:     resource.Kind,
 			}
 
 			toFetch := conf.AllowedResources[resource.Name]
@@ -98,78 +205,72 @@ func (f *Collector) Run(ctx context.Context, conf *config.Config) (
 			if !toFetch && !isCRD {
 				// Skipping a resource due to policy
 				log.Warn().
-					Str("ApiVersion", uri).
+					Str("ApiVersion", apiResource.GroupVersion).
 					Str("kind", resource.Kind).
 					Msg("Ignoring resources due to policy")
+				status.Status = CollectionStatusSkipped
+				status.Reason = "resource type is not in the list of allowed resources"
+				f.statuses = append(f.statuses, status)
 				continue
 			}
 
 			if !strings.Contains(resource.Verbs.String(), "list") {
 				log.Debug().
-					Str("ApiVersion", uri).
+					Str("ApiVersion", apiResource.GroupVersion).
 					Str("Kind", resource.Kind).
 					Msg("Ignoring resources due to policy")
+				status.Status = CollectionStatusNotListable
+				status.Reason = "resource type does not support the list verb"
+				f.statuses = append(f.statuses, status)
 				continue
 			}
 
-			itemsResponse := f.api.Discovery().
-				RESTClient().
-				Get().
-				RequestURI(uri).
-				Resource(resource.Name).
-				Do(ctx)
+			metadataOnly := conf.MetadataOnlyResources[resource.Name]
+			status.MetadataOnly = metadataOnly
 
-			var responseCode int
-			itemsResponse.StatusCode(&responseCode)
-			if responseCode != 200 {
-				log.Warn().
-					Err(itemsResponse.Error()).
-					Str("ApiVersion", uri).
-					Str("kind", resource.Kind).
-					Msg("Error receiving response while listing resources")
-				continue
-			}
-
-			type ResourcesListResponse struct {
-				Kind       string                   `json:"kind"`
-				APIVersion string                   `json:"apiVersion"`
-				Items      []map[string]interface{} `json:"items"`
+			// the size of the list response is counted by the clients'
+			// transport, rather than by encoding every item again
+			var items []map[string]interface{}
+			listCtx := withResponseSize(ctx, &status.Bytes)
+			if metadataOnly {
+				items, err = f.listMetadata(listCtx, gvr, resource.Kind)
+			} else {
+				items, err = f.listObjects(listCtx, gvr)
 			}
-
-			var itemsDict = ResourcesListResponse{}
-
-			responseData, err := itemsResponse.Raw()
 			if err != nil {
 				log.Warn().
 					Err(err).
-					Str("ApiVersion", uri).
+					Str("ApiVersion", apiResource.GroupVersion).
 					Str("kind", resource.Kind).
-					Msg("Error reading response while listing resources")
+					Msg("Error receiving response while listing resources")
+				status.Status = CollectionStatusFailed
+				status.Reason = err.Error()
+				status.Bytes = 0
+				var apiStatus apierrors.APIStatus
+				if errors.As(err, &apiStatus) {
+					status.Code = int(apiStatus.Status().Code)
+				}
+				f.statuses = append(f.statuses, status)
 				continue
 			}
 
-			err = json.Unmarshal(responseData, &itemsDict)
-			if err != nil {
-				log.Warn().
-					Err(err).
-					Str("ApiVersion", uri).
-					Str("kind", resource.Kind).
-					Msg("Failed loading json resources from response")
+			for _, item := range items {
+				obj := newKubernetesObject(gvr, item, metadataOnly)
+				if conf.CollectAllVersions {
+					recordManagedFieldsVersions(&obj, f.versions[gvr.GroupResource()])
+				}
+				objects = append(objects, obj)
 			}
 
-			for _, item := range itemsDict.Items {
-				item["apiVersion"] = apiResource.GroupVersion
-				item["kind"] = resource.Kind
-				objects = append(objects, KubernetesObject{
-					Kind:   resource.Kind,
-					Object: item,
-				})
-			}
+			status.Status = CollectionStatusCollected
+			status.Count = len(items)
+			f.statuses = append(f.statuses, status)
 
 			log.Debug().
-				Int("items", len(itemsDict.Items)).
-				Str("ApiVersion", uri).
+				Int("items", len(items)).
+				Str("ApiVersion", apiResource.GroupVersion).
 				Str("kind", resource.Kind).
+				Bool("metadataOnly", metadataOnly).
 				Msg("Found items for resource")
 		}
 	}
@@ -177,11 +278,110 @@ func (f *Collector) Run(ctx context.Context, conf *config.Config) (
 	log.Info().
 		Int("items", len(objects)).
 		Int("apis", len(apiResourcesList)).
+		Int("unavailableApis", len(f.unavailableAPIs)).
 		Msg("Finished Kubernetes cluster fetching")
 
 	return "k8s_objects", objects, nil
 }
 
+// Supplemental implements the SupplementalDataCollector interface, returning
+// the list of API group-versions that failed discovery during the last run
+// under the "k8s_unavailable_apis" key, the outcome of collecting each
+// resource type under the "k8s_collection_status" key, and when collecting
+// all versions, the served and used versions of each resource type under the
+// "k8s_api_versions" key.
+func (f *Collector) Supplemental() map[string][]interface{} {
+	unavailable := make([]interface{}, len(f.unavailableAPIs))
+	for i, api := range f.unavailableAPIs {
+		unavailable[i] = api
+	}
+
+	statuses := make([]interface{}, len(f.statuses))
+	for i, status := range f.statuses {
+		statuses[i] = status
+	}
+
+	supplemental := map[string][]interface{}{
+		"k8s_unavailable_apis":  unavailable,
+		"k8s_collection_status": statuses,
+	}
+
+	if f.versions != nil {
+		supplemental["k8s_api_versions"] = sortedResourceVersions(f.versions)
+	}
+
+	return supplemental
+}
+
+// listObjects lists all objects of the provided resource type via the dynamic
+// client
+func (f *Collector) listObjects(ctx context.Context, gvr schema.GroupVersionResource) (
+	items []map[string]interface{},
+	err error,
+) {
+	list, err := f.dynamic.Resource(gvr).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	items = make([]map[string]interface{}, len(list.Items))
+	for i := range list.Items {
+		items[i] = list.Items[i].Object
+	}
+
+	return items, nil
+}
+
+// listMetadata lists the metadata of all objects of the provided resource type
+// via the metadata client, which requests the objects from the API server as a
+// PartialObjectMetadataList. The items are returned with the API version and
+// kind of the resource type rather than those of PartialObjectMetadata.
+func (f *Collector) listMetadata(ctx context.Context, gvr schema.GroupVersionResource, kind string) (
+	items []map[string]interface{},
+	err error,
+) {
+	list, err := f.metadata.Resource(gvr).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	items = make([]map[string]interface{}, len(list.Items))
+	for i := range list.Items {
+		items[i], err = runtime.DefaultUnstructuredConverter.ToUnstructured(&list.Items[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed converting object metadata: %w", err)
+		}
+		items[i]["apiVersion"] = gvr.GroupVersion().String()
+		items[i]["kind"] = kind
+	}
+
+	return items, nil
+}
+
+func newKubernetesObject(
+	gvr schema.GroupVersionResource,
+	item map[string]interface{},
+	metadataOnly bool,
+) KubernetesObject {
+	obj := KubernetesObject{
+		Group:        gvr.Group,
+		Version:      gvr.Version,
+		Resource:     gvr.Resource,
+		Object:       item,
+		MetadataOnly: metadataOnly,
+	}
+	obj.Kind, _ = item["kind"].(string)
+
+	if meta, ok := item["metadata"].(map[string]interface{}); ok {
+		obj.Namespace, _ = meta["namespace"].(string)
+		obj.Name, _ = meta["name"].(string)
+		obj.UID, _ = meta["uid"].(string)
+		obj.ResourceVersion, _ = meta["resourceVersion"].(string)
+	}
+
+	return obj
+}
+
 func isCoreAPIGroup(groupVersion string) bool {
 	return !strings.Contains(groupVersion, ".") || strings.Contains(groupVersion, ".k8s.io")
 }
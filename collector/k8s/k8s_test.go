@@ -0,0 +1,287 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	fakemetadata "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+// preferredDiscovery is a fake discovery client whose preferred resources are
+// all of its resources (the upstream fake always returns an empty list)
+type preferredDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (d preferredDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return d.Resources, nil
+}
+
+var testResources = []*metav1.APIResourceList{
+	{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: []string{"get", "list"}},
+			{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: []string{"get", "list"}},
+			{Name: "bindings", Kind: "Binding", Namespaced: true, Verbs: []string{"create"}},
+		},
+	},
+	{
+		GroupVersion: "coordination.k8s.io/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "leases", Kind: "Lease", Namespaced: true, Verbs: []string{"get", "list"}},
+		},
+	},
+	{
+		GroupVersion: "example.com/v1alpha1",
+		APIResources: []metav1.APIResource{
+			{Name: "widgets", Kind: "Widget", Namespaced: false, Verbs: []string{"get", "list"}},
+		},
+	},
+}
+
+func newTestObject(apiVersion, kind, namespace, name, uid string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetUID(apitypes.UID(uid))
+	obj.SetResourceVersion("1")
+	_ = unstructured.SetNestedField(obj.Object, "value", "spec", "field")
+	return obj
+}
+
+func TestRun(t *testing.T) {
+	logger := zerolog.Nop()
+
+	objects := []runtime.Object{
+		newTestObject("v1", "Pod", "default", "pod", "pod-uid"),
+		newTestObject("v1", "Secret", "default", "secret", "secret-uid"),
+		newTestObject("coordination.k8s.io/v1", "Lease", "kube-system", "lease", "lease-uid"),
+		newTestObject("example.com/v1alpha1", "Widget", "", "widget", "widget-uid"),
+	}
+
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Version: "v1", Resource: "pods"}:                                 "PodList",
+			{Version: "v1", Resource: "secrets"}:                              "SecretList",
+			{Group: "coordination.k8s.io", Version: "v1", Resource: "leases"}: "LeaseList",
+			{Group: "example.com", Version: "v1alpha1", Resource: "widgets"}:  "WidgetList",
+		},
+		objects...,
+	)
+
+	metadataScheme := runtime.NewScheme()
+	assert.MustBeNil(t, metav1.AddMetaToScheme(metadataScheme), "scheme must be built")
+	metadataClient := fakemetadata.NewSimpleMetadataClient(metadataScheme, &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "kube-system",
+			Name:            "lease",
+			UID:             "lease-uid",
+			ResourceVersion: "1",
+		},
+	})
+
+	discoveryClient := preferredDiscovery{
+		FakeDiscovery: &fakediscovery.FakeDiscovery{
+			Fake: &k8stesting.Fake{Resources: testResources},
+		},
+	}
+
+	conf := &config.Config{
+		Log: &logger,
+		AllowedResources: map[string]bool{
+			"pods":     true,
+			"bindings": true,
+			"leases":   true,
+		},
+		MetadataOnlyResources: map[string]bool{
+			"leases": true,
+		},
+	}
+
+	keyName, data, err := New(discoveryClient, dynamicClient, metadataClient).
+		Run(context.Background(), conf)
+	assert.MustBeNil(t, err, "error must be nil")
+	assert.Equal(t, "k8s_objects", keyName, "key name must match")
+	assert.MustBeEqual(t, 3, len(data), "number of objects must match")
+
+	collected := make(map[string]KubernetesObject, len(data))
+	for _, item := range data {
+		obj := item.(KubernetesObject)
+		collected[obj.UID] = obj
+	}
+
+	pod, ok := collected["pod-uid"]
+	assert.MustBeTrue(t, ok, "pod must be collected")
+	assert.Equal(t, schema.GroupVersionResource{Version: "v1", Resource: "pods"}, pod.GroupVersionResource(), "pod GVR must match")
+	assert.Equal(t, "Pod", pod.Kind, "pod kind must match")
+	assert.Equal(t, "default", pod.Namespace, "pod namespace must match")
+	assert.Equal(t, "pod", pod.Name, "pod name must match")
+	assert.Equal(t, "1", pod.ResourceVersion, "pod resource version must match")
+	assert.Equal(t, "v1", pod.Object["apiVersion"], "pod object API version must match")
+	assert.False(t, pod.MetadataOnly, "pod must be fully collected")
+
+	lease, ok := collected["lease-uid"]
+	assert.MustBeTrue(t, ok, "lease must be collected")
+	assert.Equal(t, "coordination.k8s.io/v1", lease.APIVersion(), "lease API version must match")
+	assert.Equal(t, "Lease", lease.Object["kind"], "lease object kind must match")
+	assert.True(t, lease.MetadataOnly, "lease must be collected as metadata only")
+	_, hasSpec := lease.Object["spec"]
+	assert.False(t, hasSpec, "lease must not include a spec")
+
+	widget, ok := collected["widget-uid"]
+	assert.MustBeTrue(t, ok, "custom resources must be collected regardless of policy")
+	assert.Equal(t, "example.com", widget.Group, "widget group must match")
+	assert.Equal(t, "", widget.Namespace, "widget must not have a namespace")
+
+	_, ok = collected["secret-uid"]
+	assert.False(t, ok, "secrets must not be collected due to policy")
+}
+
+func TestRunMetadataOnly(t *testing.T) {
+	logger := zerolog.Nop()
+
+	// the full lease is available from the dynamic client, but must not be
+	// requested from it
+	lease := newTestObject("coordination.k8s.io/v1", "Lease", "kube-system", "lease", "lease-uid")
+	_ = unstructured.SetNestedField(lease.Object, "holder", "status", "holderIdentity")
+
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Group: "coordination.k8s.io", Version: "v1", Resource: "leases"}: "LeaseList",
+			{Group: "example.com", Version: "v1alpha1", Resource: "widgets"}:  "WidgetList",
+		},
+		lease,
+	)
+
+	metadataScheme := runtime.NewScheme()
+	assert.MustBeNil(t, metav1.AddMetaToScheme(metadataScheme), "scheme must be built")
+	metadataClient := fakemetadata.NewSimpleMetadataClient(metadataScheme, &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "kube-system",
+			Name:            "lease",
+			UID:             "lease-uid",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"app": "controller"},
+		},
+	})
+
+	discoveryClient := preferredDiscovery{
+		FakeDiscovery: &fakediscovery.FakeDiscovery{
+			Fake: &k8stesting.Fake{Resources: testResources},
+		},
+	}
+
+	conf := &config.Config{
+		Log:                   &logger,
+		AllowedResources:      map[string]bool{"leases": true},
+		MetadataOnlyResources: map[string]bool{"leases": true},
+	}
+
+	_, data, err := New(discoveryClient, dynamicClient, metadataClient).Run(context.Background(), conf)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	var collected *KubernetesObject
+	for _, item := range data {
+		if obj := item.(KubernetesObject); obj.UID == "lease-uid" {
+			collected = &obj
+		}
+	}
+	assert.MustBeTrue(t, collected != nil, "lease must be collected")
+	assert.True(t, collected.MetadataOnly, "lease must be collected as metadata only")
+	assert.Equal(t, "lease", collected.Name, "lease name must match")
+	assert.Equal(t, "1", collected.ResourceVersion, "lease resource version must match")
+	assert.DeepEqual(
+		t,
+		map[string]string{"app": "controller"},
+		(&unstructured.Unstructured{Object: collected.Object}).GetLabels(),
+		"lease labels must be collected",
+	)
+
+	_, hasSpec := collected.Object["spec"]
+	assert.False(t, hasSpec, "lease must not include a spec")
+	_, hasStatus := collected.Object["status"]
+	assert.False(t, hasStatus, "lease must not include a status")
+
+	for _, action := range dynamicClient.Actions() {
+		assert.False(
+			t,
+			action.GetResource().Resource == "leases",
+			"leases must not be listed with the dynamic client",
+		)
+	}
+}
+
+func TestRunCollectionStatus(t *testing.T) {
+	logger := zerolog.Nop()
+
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Version: "v1", Resource: "pods"}:                                "PodList",
+			{Group: "example.com", Version: "v1alpha1", Resource: "widgets"}: "WidgetList",
+		},
+		newTestObject("v1", "Pod", "default", "pod", "pod-uid"),
+	)
+	dynamicClient.PrependReactor("list", "widgets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), "", nil)
+	})
+
+	discoveryClient := preferredDiscovery{
+		FakeDiscovery: &fakediscovery.FakeDiscovery{
+			Fake: &k8stesting.Fake{Resources: testResources},
+		},
+	}
+
+	conf := &config.Config{
+		Log: &logger,
+		AllowedResources: map[string]bool{
+			"pods":     true,
+			"bindings": true,
+		},
+	}
+
+	collector := New(discoveryClient, dynamicClient, fakemetadata.NewSimpleMetadataClient(runtime.NewScheme()))
+	_, data, err := collector.Run(context.Background(), conf)
+	assert.MustBeNil(t, err, "error must be nil")
+	assert.MustBeEqual(t, 1, len(data), "number of objects must match")
+
+	statuses := make(map[string]CollectionStatus)
+	for _, value := range collector.Supplemental()["k8s_collection_status"] {
+		status := value.(CollectionStatus)
+		statuses[status.Resource] = status
+	}
+	assert.MustBeEqual(t, 5, len(statuses), "every preferred type must have a status")
+
+	pods := statuses["pods"]
+	assert.Equal(t, CollectionStatusCollected, pods.Status, "pods must be collected")
+	assert.Equal(t, 1, pods.Count, "pod count must match")
+
+	assert.Equal(t, CollectionStatusSkipped, statuses["secrets"].Status, "secrets must be skipped by policy")
+	assert.Equal(t, CollectionStatusSkipped, statuses["leases"].Status, "leases must be skipped by policy")
+	assert.Equal(t, CollectionStatusNotListable, statuses["bindings"].Status, "bindings must not be listable")
+
+	widgets := statuses["widgets"]
+	assert.Equal(t, CollectionStatusFailed, widgets.Status, "widgets must fail")
+	assert.Equal(t, 403, widgets.Code, "widgets failure code must match")
+	assert.Equal(t, 0, widgets.Count, "widget count must be zero")
+}
@@ -0,0 +1,57 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// responseSizeKey is the context key under which the counter of response
+// body sizes is stored
+type responseSizeKey struct{}
+
+// withResponseSize returns a copy of the provided context in which the sizes
+// of the bodies of all responses received by the Kubernetes clients are added
+// to the provided counter. Sizes are only counted by clients whose transport
+// was wrapped by countResponseSize.
+func withResponseSize(ctx context.Context, size *int) context.Context {
+	return context.WithValue(ctx, responseSizeKey{}, size)
+}
+
+// countResponseSize wraps an HTTP transport so that the sizes of response
+// bodies are counted for requests whose context carries a counter (see
+// withResponseSize). Bodies are counted as they are read, i.e. after they are
+// decompressed.
+func countResponseSize(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+
+		if size, ok := req.Context().Value(responseSizeKey{}).(*int); ok {
+			resp.Body = &countingReader{ReadCloser: resp.Body, size: size}
+		}
+
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// countingReader adds the number of bytes read from a response body to a
+// counter
+type countingReader struct {
+	io.ReadCloser
+	size *int
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	*r.size += n
+	return n, err
+}
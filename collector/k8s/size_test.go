@@ -0,0 +1,57 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+	"k8s.io/client-go/rest"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+func TestRunResponseSize(t *testing.T) {
+	logger := zerolog.Nop()
+
+	const pods = `{"apiVersion": "v1", "kind": "PodList", "metadata": {"resourceVersion": "1"}, "items": [` +
+		`{"apiVersion": "v1", "kind": "Pod", "metadata": {"namespace": "default", "name": "pod", "uid": "pod-uid"}}]}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api":
+			_, _ = io.WriteString(w, `{"kind": "APIVersions", "versions": ["v1"]}`)
+		case "/apis":
+			_, _ = io.WriteString(w, `{"kind": "APIGroupList", "apiVersion": "v1", "groups": []}`)
+		case "/api/v1":
+			_, _ = io.WriteString(w, `{"kind": "APIResourceList", "groupVersion": "v1", "resources": [`+
+				`{"name": "pods", "kind": "Pod", "namespaced": true, "verbs": ["get", "list"]}]}`)
+		case "/api/v1/pods":
+			_, _ = io.WriteString(w, pods)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector, err := DefaultConfiguration(&rest.Config{Host: server.URL})
+	assert.MustBeNil(t, err, "collector must be created")
+
+	_, data, err := collector.Run(context.Background(), &config.Config{
+		Log:              &logger,
+		AllowedResources: map[string]bool{"pods": true},
+	})
+	assert.MustBeNil(t, err, "error must be nil")
+	assert.MustBeEqual(t, 1, len(data), "number of objects must match")
+
+	statuses := collector.Supplemental()["k8s_collection_status"]
+	assert.MustBeEqual(t, 1, len(statuses), "number of statuses must match")
+	status := statuses[0].(CollectionStatus)
+	assert.Equal(t, CollectionStatusCollected, status.Status, "pods must be collected")
+	assert.Equal(t, len(pods), status.Bytes, "pod size must be the size of the list response")
+}
@@ -0,0 +1,49 @@
+package k8s
+
+// Outcomes of collecting a resource type
+const (
+	// CollectionStatusCollected means objects of the type were listed
+	// successfully (possibly with zero objects)
+	CollectionStatusCollected = "collected"
+
+	// CollectionStatusSkipped means the type was skipped due to the
+	// collector's configuration
+	CollectionStatusSkipped = "skipped"
+
+	// CollectionStatusNotListable means the type does not support the list
+	// verb
+	CollectionStatusNotListable = "notListable"
+
+	// CollectionStatusFailed means listing objects of the type failed, e.g.
+	// due to missing RBAC permissions
+	CollectionStatusFailed = "failed"
+)
+
+// CollectionStatus describes the outcome of collecting a single resource type
+// (in its preferred version) from the Kubernetes API server.
+type CollectionStatus struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	Kind     string `json:"kind"`
+
+	// Status is one of the CollectionStatus constants
+	Status string `json:"status"`
+
+	// Reason explains why the type was not collected
+	Reason string `json:"reason,omitempty"`
+
+	// Code is the HTTP status code returned by the API server when listing
+	// the type failed
+	Code int `json:"code,omitempty"`
+
+	// Count is the number of objects collected
+	Count int `json:"count"`
+
+	// Bytes is the size, in bytes, of the response to the list request of the
+	// type (only counted by clients created with DefaultConfiguration)
+	Bytes int `json:"bytes"`
+
+	// MetadataOnly is true if only object metadata was collected
+	MetadataOnly bool `json:"metadataOnly,omitempty"`
+}
@@ -0,0 +1,183 @@
+package k8s
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResourceVersions describes the versions in which the API server serves a
+// resource type, and the versions actually in use by objects of that type.
+// These are only collected when config.Config.CollectAllVersions is enabled.
+type ResourceVersions struct {
+	Group    string `json:"group"`
+	Resource string `json:"resource,omitempty"`
+	Kind     string `json:"kind"`
+
+	// PreferredVersion is the version in which objects are collected
+	PreferredVersion string `json:"preferredVersion,omitempty"`
+
+	// ServedVersions are all versions served by the API server for the
+	// resource, ordered by the server's priority
+	ServedVersions []string `json:"servedVersions"`
+
+	// ManagedFieldsVersions maps each version used in the managed fields of
+	// objects of this type to the number of objects using it (see
+	// UsedVersionKey)
+	ManagedFieldsVersions map[string]int `json:"managedFieldsVersions,omitempty"`
+
+	// HelmManifestVersions maps each version used in Helm release manifests
+	// for this type to the list of releases (as "namespace/name") using it
+	// (see UsedVersionKey)
+	HelmManifestVersions map[string][]string `json:"helmManifestVersions,omitempty"`
+}
+
+// UsedVersionKey returns the key under which a used version of the resource is
+// recorded: the version alone if it belongs to the resource's group, or the
+// full group-version if it belongs to a legacy group the resource moved from
+// (e.g. "extensions/v1beta1" for networking.k8s.io Ingresses)
+func (entry *ResourceVersions) UsedVersionKey(groupVersion schema.GroupVersion) string {
+	if groupVersion.Group == entry.Group {
+		return groupVersion.Version
+	}
+	return groupVersion.String()
+}
+
+// resourceVersions builds the list of versions served by the API server for
+// every resource type. The preferred resources list is used to determine the
+// version in which each resource is collected.
+func (f *Collector) resourceVersions(preferred []*metav1.APIResourceList) map[schema.GroupResource]*ResourceVersions {
+	versions := make(map[schema.GroupResource]*ResourceVersions)
+
+	groups, resourceLists, err := f.discovery.ServerGroupsAndResources()
+	if err != nil {
+		// partial results are still usable, unavailable APIs are already
+		// reported by the main discovery call
+		log.Warn().
+			Err(err).
+			Msg("Failed discovering all served versions of resources")
+	}
+
+	listsByGroupVersion := make(map[string]*metav1.APIResourceList, len(resourceLists))
+	for _, list := range resourceLists {
+		listsByGroupVersion[list.GroupVersion] = list
+	}
+
+	for _, group := range groups {
+		for _, version := range group.Versions {
+			list, ok := listsByGroupVersion[version.GroupVersion]
+			if !ok {
+				continue
+			}
+
+			for _, resource := range list.APIResources {
+				if strings.Contains(resource.Name, "/") {
+					// subresources are served with their parent resource
+					continue
+				}
+
+				gr := schema.GroupResource{Group: group.Name, Resource: resource.Name}
+				entry, ok := versions[gr]
+				if !ok {
+					entry = &ResourceVersions{
+						Group:    group.Name,
+						Resource: resource.Name,
+						Kind:     resource.Kind,
+					}
+					versions[gr] = entry
+				}
+				entry.ServedVersions = append(entry.ServedVersions, version.Version)
+			}
+		}
+	}
+
+	for _, list := range preferred {
+		groupVersion, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, resource := range list.APIResources {
+			if entry, ok := versions[groupVersion.WithResource(resource.Name).GroupResource()]; ok {
+				entry.PreferredVersion = groupVersion.Version
+			}
+		}
+	}
+
+	return versions
+}
+
+// sortedResourceVersions returns the provided resource versions as a list
+// sorted by group and resource
+func sortedResourceVersions(versions map[schema.GroupResource]*ResourceVersions) []interface{} {
+	entries := make([]*ResourceVersions, 0, len(versions))
+	for _, entry := range versions {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Group != entries[j].Group {
+			return entries[i].Group < entries[j].Group
+		}
+		return entries[i].Resource < entries[j].Resource
+	})
+
+	list := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		list[i] = entry
+	}
+
+	return list
+}
+
+// recordManagedFieldsVersions finds the API versions used by the managed
+// fields of the provided object, stores them in the object, and counts them
+// in the resource's versions entry.
+func recordManagedFieldsVersions(obj *KubernetesObject, entry *ResourceVersions) {
+	meta, ok := obj.Object["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	managedFields, ok := meta["managedFields"].([]interface{})
+	if !ok {
+		return
+	}
+
+	seen := make(map[string]bool, len(managedFields))
+	for _, field := range managedFields {
+		fieldMap, ok := field.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		apiVersion, _ := fieldMap["apiVersion"].(string)
+		if apiVersion == "" || seen[apiVersion] {
+			continue
+		}
+		seen[apiVersion] = true
+		obj.ManagedFieldsAPIVersions = append(obj.ManagedFieldsAPIVersions, apiVersion)
+
+		if entry == nil {
+			continue
+		}
+
+		// the managed fields of an object always describe the object itself,
+		// even if they were written through a legacy group (e.g. an Ingress
+		// created as extensions/v1beta1)
+		groupVersion, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			continue
+		}
+
+		if entry.ManagedFieldsVersions == nil {
+			entry.ManagedFieldsVersions = make(map[string]int)
+		}
+		entry.ManagedFieldsVersions[entry.UsedVersionKey(groupVersion)]++
+	}
+
+	sort.Strings(obj.ManagedFieldsAPIVersions)
+}
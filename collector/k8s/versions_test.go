@@ -0,0 +1,38 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+)
+
+func TestRecordManagedFieldsVersions(t *testing.T) {
+	entry := &ResourceVersions{
+		Group:          "networking.k8s.io",
+		Resource:       "ingresses",
+		Kind:           "Ingress",
+		ServedVersions: []string{"v1"},
+	}
+
+	for _, apiVersions := range [][]string{
+		{"networking.k8s.io/v1", "extensions/v1beta1"},
+		{"networking.k8s.io/v1", "networking.k8s.io/v1"},
+	} {
+		var managedFields []interface{}
+		for _, apiVersion := range apiVersions {
+			managedFields = append(managedFields, map[string]interface{}{"apiVersion": apiVersion})
+		}
+		obj := &KubernetesObject{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"managedFields": managedFields},
+		}}
+
+		recordManagedFieldsVersions(obj, entry)
+	}
+
+	assert.DeepEqual(
+		t,
+		map[string]int{"v1": 2, "extensions/v1beta1": 1},
+		entry.ManagedFieldsVersions,
+		"legacy group versions must be counted with the served resource",
+	)
+}
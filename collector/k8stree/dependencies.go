@@ -0,0 +1,195 @@
+package k8stree
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// defaultServiceAccount is the ServiceAccount of pods that do not specify one
+const defaultServiceAccount = "default"
+
+// podSpecPath returns the path of the pod spec of a Pod, or of the pod
+// template of a workload, or nil if the object has none
+func podSpecPath(obj unstructured.Unstructured) []string {
+	switch obj.GetKind() {
+	case "Pod":
+		return []string{"spec"}
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job":
+		return []string{"spec", "template", "spec"}
+	case "CronJob":
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	}
+	return nil
+}
+
+// podDependencyRelations returns edges from a workload to the ConfigMaps,
+// Secrets and PersistentVolumeClaims its pods consume (through volumes,
+// projected volumes, envFrom and env.valueFrom), and to the ServiceAccount
+// and image pull Secrets its pods use. Edges are attached at the workload
+// level: objects created from the pod template of a controller (e.g. Pods of
+// a ReplicaSet, or Jobs of a CronJob) are skipped, as their dependencies are
+// those of the controller. Dependencies that were not collected (e.g. Secrets, which are
+// not collected by default) are added to the graph as missing nodes.
+func podDependencyRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	path := podSpecPath(obj)
+	if path == nil || hasPodTemplateController(obj) {
+		return nil
+	}
+
+	spec, ok, _ := unstructured.NestedMap(obj.Object, path...)
+	if !ok {
+		return nil
+	}
+
+	namespace := obj.GetNamespace()
+	var edges []Edge
+	addEdge := func(edgeType, kind, name string) {
+		if name == "" {
+			return
+		}
+		edges = append(edges, Edge{
+			Type: edgeType,
+			From: string(obj.GetUID()),
+			To:   idx.reference("", kind, namespace, name),
+		})
+	}
+
+	volumes, _, _ := unstructured.NestedSlice(spec, "volumes")
+	for _, volume := range volumes {
+		volume, ok := volume.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		addEdge(EdgeMounts, "ConfigMap", nestedString(volume, "configMap", "name"))
+		addEdge(EdgeMounts, "Secret", nestedString(volume, "secret", "secretName"))
+		addEdge(EdgeMounts, "PersistentVolumeClaim", nestedString(volume, "persistentVolumeClaim", "claimName"))
+
+		sources, _, _ := unstructured.NestedSlice(volume, "projected", "sources")
+		for _, source := range sources {
+			source, ok := source.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			addEdge(EdgeMounts, "ConfigMap", nestedString(source, "configMap", "name"))
+			addEdge(EdgeMounts, "Secret", nestedString(source, "secret", "name"))
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		containers, _, _ := unstructured.NestedSlice(spec, field)
+		for _, container := range containers {
+			container, ok := container.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			envFrom, _, _ := unstructured.NestedSlice(container, "envFrom")
+			for _, source := range envFrom {
+				source, ok := source.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				addEdge(EdgeMounts, "ConfigMap", nestedString(source, "configMapRef", "name"))
+				addEdge(EdgeMounts, "Secret", nestedString(source, "secretRef", "name"))
+			}
+
+			env, _, _ := unstructured.NestedSlice(container, "env")
+			for _, variable := range env {
+				variable, ok := variable.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				addEdge(EdgeMounts, "ConfigMap", nestedString(variable, "valueFrom", "configMapKeyRef", "name"))
+				addEdge(EdgeMounts, "Secret", nestedString(variable, "valueFrom", "secretKeyRef", "name"))
+			}
+		}
+	}
+
+	pullSecrets, _, _ := unstructured.NestedSlice(spec, "imagePullSecrets")
+	for _, secret := range pullSecrets {
+		if secret, ok := secret.(map[string]interface{}); ok {
+			addEdge(EdgeUses, "Secret", nestedString(secret, "name"))
+		}
+	}
+
+	serviceAccount := nestedString(spec, "serviceAccountName")
+	if serviceAccount == "" {
+		serviceAccount = nestedString(spec, "serviceAccount")
+	}
+	if serviceAccount == "" {
+		serviceAccount = defaultServiceAccount
+	}
+	addEdge(EdgeUses, "ServiceAccount", serviceAccount)
+
+	return edges
+}
+
+// nodeRelations returns an edge from a scheduled Pod to its Node
+func nodeRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if obj.GetKind() != "Pod" {
+		return nil
+	}
+
+	nodeName := nestedString(obj.Object, "spec", "nodeName")
+	if nodeName == "" {
+		return nil
+	}
+
+	return []Edge{{
+		Type: EdgeRunsOn,
+		From: string(obj.GetUID()),
+		To:   idx.reference("", "Node", "", nodeName),
+	}}
+}
+
+// reference returns the UID of an object referenced by name. If the object
+// was not collected, a missing node is added to the graph, identified by the
+// object's group, kind, namespace and name (see missingUID).
+func (idx *objectIndex) reference(group, kind, namespace, name string) string {
+	if obj, ok := idx.lookupGroup(namespace, group, kind, name); ok {
+		return string(obj.GetUID())
+	}
+
+	// the version of missing objects is unknown, except for core objects
+	var apiVersion string
+	if group == "" {
+		apiVersion = "v1"
+	}
+
+	return idx.addExtraNode(Node{
+		UID:        missingUID(group, kind, namespace, name),
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Namespace:  namespace,
+		Name:       name,
+		Missing:    true,
+	})
+}
+
+// addExtraNode adds a node that is not a collected object to the graph, if
+// not already added, and returns its UID
+func (idx *objectIndex) addExtraNode(node Node) string {
+	if _, ok := idx.extra[node.UID]; !ok {
+		idx.extra[node.UID] = len(idx.extraNodes)
+		idx.extraNodes = append(idx.extraNodes, node)
+	}
+
+	return node.UID
+}
+
+// missingUID returns the identifier of a missing object referenced by name,
+// in the form "<group>/<kind>/<namespace>/<name>" (with an empty group for
+// the core API group, and an empty namespace for cluster-scoped objects)
+func missingUID(group, kind, namespace, name string) string {
+	return strings.Join([]string{group, kind, namespace, name}, "/")
+}
+
+func nestedString(obj map[string]interface{}, fields ...string) string {
+	value, _, _ := unstructured.NestedString(obj, fields...)
+	return value
+}
@@ -0,0 +1,392 @@
+package k8stree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// SnapshotVersion is the version of the snapshot format written by
+// NewSnapshot. Snapshots of other versions cannot be diffed.
+const SnapshotVersion = 1
+
+// Types of changes found by DiffSnapshots
+const (
+	// ChangeAdded is an object that only exists in the newer snapshot
+	ChangeAdded = "added"
+
+	// ChangeRemoved is an object that only exists in the older snapshot
+	ChangeRemoved = "removed"
+
+	// ChangeMoved is an object whose parents in the trees changed, e.g. a
+	// ReplicaSet adopted by another Deployment, or an orphaned object
+	ChangeMoved = "moved"
+
+	// ChangeRelationshipAdded is a relationship between two objects existing
+	// in both snapshots that only exists in the newer snapshot
+	ChangeRelationshipAdded = "relationshipAdded"
+
+	// ChangeRelationshipRemoved is a relationship between two objects
+	// existing in both snapshots that only exists in the older snapshot
+	ChangeRelationshipRemoved = "relationshipRemoved"
+)
+
+// Snapshot is the structure of the objects trees (and optionally, the
+// relationships of the object graph) of a single fetching, without the
+// objects themselves, so that it can be persisted and compared to the
+// snapshot of a later fetching.
+type Snapshot struct {
+	Version int            `json:"version"`
+	Nodes   []SnapshotNode `json:"nodes"`
+
+	// Edges are the relationships of the object graph, except for ownership
+	// edges, which are represented by the nodes' parents
+	Edges []Edge `json:"edges"`
+}
+
+// SnapshotNode is an object in a snapshot, along with the UIDs of its
+// parents in the trees (none for roots). Missing and external nodes of the
+// object graph have no parents.
+type SnapshotNode struct {
+	Node
+	Parents []string `json:"parents,omitempty"`
+}
+
+// Change is a single structural difference between two snapshots
+type Change struct {
+	// Type is one of the Change constants
+	Type string `json:"type"`
+
+	// Node is the added, removed or moved object, or the source of the added
+	// or removed relationship
+	Node Node `json:"node"`
+
+	// Parents are the parents of an added or moved object, or the parents a
+	// removed object had
+	Parents []Node `json:"parents,omitempty"`
+
+	// PreviousParents are the parents a moved object had
+	PreviousParents []Node `json:"previousParents,omitempty"`
+
+	// Relationship is the edge type of an added or removed relationship
+	Relationship string `json:"relationship,omitempty"`
+
+	// Target is the target of an added or removed relationship
+	Target *Node `json:"target,omitempty"`
+}
+
+// NewSnapshot creates a snapshot of the provided trees. If a graph of the
+// same objects is provided, its relationships (and its missing and external
+// nodes) are included as well.
+func NewSnapshot(trees []ObjectsTree, graph *Graph) *Snapshot {
+	snapshot := &Snapshot{
+		Version: SnapshotVersion,
+		Nodes:   []SnapshotNode{},
+		Edges:   []Edge{},
+	}
+	positions := make(map[string]int)
+
+	var add func(tree ObjectsTree, parent string)
+	add = func(tree ObjectsTree, parent string) {
+		i, ok := positions[tree.UID]
+		if !ok {
+			obj := unstructured.Unstructured{Object: tree.Object}
+			i = len(snapshot.Nodes)
+			positions[tree.UID] = i
+			snapshot.Nodes = append(snapshot.Nodes, SnapshotNode{
+				Node: Node{
+					UID:        tree.UID,
+					APIVersion: obj.GetAPIVersion(),
+					Kind:       tree.Kind,
+					Namespace:  obj.GetNamespace(),
+					Name:       tree.Name,
+				},
+			})
+		}
+		if parent != "" && !includes(snapshot.Nodes[i].Parents, parent) {
+			snapshot.Nodes[i].Parents = append(snapshot.Nodes[i].Parents, parent)
+		}
+
+		for _, child := range tree.Children {
+			add(child, tree.UID)
+		}
+	}
+
+	for _, tree := range trees {
+		add(tree, "")
+	}
+
+	if graph == nil {
+		return snapshot
+	}
+
+	for _, node := range graph.Nodes {
+		if _, ok := positions[node.UID]; !ok {
+			positions[node.UID] = len(snapshot.Nodes)
+			snapshot.Nodes = append(snapshot.Nodes, SnapshotNode{Node: node})
+		}
+	}
+	for _, edge := range graph.Edges {
+		if edge.Type != EdgeOwns {
+			snapshot.Edges = append(snapshot.Edges, edge)
+		}
+	}
+
+	return snapshot
+}
+
+// DecodeSnapshot parses a snapshot encoded as JSON
+func DecodeSnapshot(data []byte) (*Snapshot, error) {
+	var snapshot Snapshot
+	err := json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing snapshot: %w", err)
+	}
+
+	if snapshot.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, SnapshotVersion)
+	}
+
+	return &snapshot, nil
+}
+
+// LoadSnapshot reads a snapshot from a file. If the file does not exist, the
+// returned error wraps os.ErrNotExist.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeSnapshot(data)
+}
+
+// Save writes the snapshot to a file. The snapshot is first written to a
+// temporary file in the same directory, which then replaces the file, so an
+// interrupted write never leaves a partial snapshot behind.
+func (snapshot *Snapshot) Save(path string) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed encoding snapshot: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed creating snapshot file: %w", err)
+	}
+	defer os.Remove(file.Name()) // nolint: errcheck
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing snapshot file: %w", err)
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// DiffSnapshots returns the structural changes between an older and a newer
+// snapshot. Objects are identified by their UIDs, so an object that was
+// deleted and recreated by the same name is both removed and added.
+//
+// Relationship changes are only reported between objects existing in both
+// snapshots, as the relationships of added and removed objects are implied.
+// Missing and external nodes are never reported as added or removed, but
+// their relationships are.
+//
+// Changes are ordered by type (added, removed, moved, relationship added,
+// relationship removed), then by their order in the snapshots.
+func DiffSnapshots(from, to *Snapshot) []Change {
+	fromNodes := indexSnapshot(from)
+	toNodes := indexSnapshot(to)
+
+	changes := []Change{}
+	changed := make(map[string]bool)
+
+	for _, node := range to.Nodes {
+		if _, ok := fromNodes[node.UID]; !ok && !node.Missing && !node.External {
+			changed[node.UID] = true
+			changes = append(changes, Change{
+				Type:    ChangeAdded,
+				Node:    node.Node,
+				Parents: parentNodes(toNodes, node.Parents),
+			})
+		}
+	}
+
+	for _, node := range from.Nodes {
+		if _, ok := toNodes[node.UID]; !ok && !node.Missing && !node.External {
+			changed[node.UID] = true
+			changes = append(changes, Change{
+				Type:    ChangeRemoved,
+				Node:    node.Node,
+				Parents: parentNodes(fromNodes, node.Parents),
+			})
+		}
+	}
+
+	for _, node := range to.Nodes {
+		previous, ok := fromNodes[node.UID]
+		if !ok || node.Missing || node.External || sameParents(previous.Parents, node.Parents) {
+			continue
+		}
+		changes = append(changes, Change{
+			Type:            ChangeMoved,
+			Node:            node.Node,
+			Parents:         parentNodes(toNodes, node.Parents),
+			PreviousParents: parentNodes(fromNodes, previous.Parents),
+		})
+	}
+
+	fromEdges := make(map[string]bool, len(from.Edges))
+	for _, edge := range from.Edges {
+		fromEdges[edgeKey(edge)] = true
+	}
+	toEdges := make(map[string]bool, len(to.Edges))
+	for _, edge := range to.Edges {
+		toEdges[edgeKey(edge)] = true
+	}
+
+	relationshipChanges := func(changeType string, edges []Edge, other map[string]bool, nodes map[string]SnapshotNode) {
+		for _, edge := range edges {
+			if other[edgeKey(edge)] || changed[edge.From] || changed[edge.To] {
+				continue
+			}
+			target := nodeOf(nodes, edge.To)
+			changes = append(changes, Change{
+				Type:         changeType,
+				Node:         nodeOf(nodes, edge.From),
+				Relationship: edge.Type,
+				Target:       &target,
+			})
+		}
+	}
+	relationshipChanges(ChangeRelationshipAdded, to.Edges, fromEdges, toNodes)
+	relationshipChanges(ChangeRelationshipRemoved, from.Edges, toEdges, fromNodes)
+
+	return changes
+}
+
+// WriteChanges writes changes in a human-readable format, one per line:
+// added objects and relationships are prefixed by "+", removed ones by "-",
+// and moved objects by "~".
+func WriteChanges(w io.Writer, changes []Change) error {
+	var b strings.Builder
+
+	for _, change := range changes {
+		switch change.Type {
+		case ChangeAdded:
+			fmt.Fprintf(&b, "+ %s%s\n", describeNode(change.Node), describeParents(change.Parents))
+		case ChangeRemoved:
+			fmt.Fprintf(&b, "- %s%s\n", describeNode(change.Node), describeParents(change.Parents))
+		case ChangeMoved:
+			fmt.Fprintf(
+				&b,
+				"~ %s: %s -> %s\n",
+				describeNode(change.Node),
+				describeNodes(change.PreviousParents),
+				describeNodes(change.Parents),
+			)
+		case ChangeRelationshipAdded, ChangeRelationshipRemoved:
+			sign := "+"
+			if change.Type == ChangeRelationshipRemoved {
+				sign = "-"
+			}
+			fmt.Fprintf(
+				&b,
+				"%s %s %s %s\n",
+				sign,
+				describeNode(change.Node),
+				change.Relationship,
+				describeNode(*change.Target),
+			)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func indexSnapshot(snapshot *Snapshot) map[string]SnapshotNode {
+	nodes := make(map[string]SnapshotNode, len(snapshot.Nodes))
+	for _, node := range snapshot.Nodes {
+		nodes[node.UID] = node
+	}
+	return nodes
+}
+
+// nodeOf returns the node with the provided UID, or a node with only the UID
+// if it is not in the snapshot
+func nodeOf(nodes map[string]SnapshotNode, uid string) Node {
+	if node, ok := nodes[uid]; ok {
+		return node.Node
+	}
+	return Node{UID: uid}
+}
+
+func parentNodes(nodes map[string]SnapshotNode, parents []string) []Node {
+	if len(parents) == 0 {
+		return nil
+	}
+
+	list := make([]Node, len(parents))
+	for i, uid := range parents {
+		list[i] = nodeOf(nodes, uid)
+	}
+	return list
+}
+
+func sameParents(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func edgeKey(edge Edge) string {
+	return edge.Type + "|" + edge.From + "|" + edge.To
+}
+
+func describeNode(node Node) string {
+	if node.Kind == "" && node.Name == "" {
+		return node.UID
+	}
+	return node.Kind + " " + qualifiedName(node)
+}
+
+func describeNodes(nodes []Node) string {
+	if len(nodes) == 0 {
+		return "(root)"
+	}
+
+	descriptions := make([]string, len(nodes))
+	for i, node := range nodes {
+		descriptions[i] = describeNode(node)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func describeParents(parents []Node) string {
+	if len(parents) == 0 {
+		return ""
+	}
+	return " (under " + describeNodes(parents) + ")"
+}
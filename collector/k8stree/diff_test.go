@@ -0,0 +1,118 @@
+package k8stree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// snapshotOf creates a snapshot of a fixture, after modifying its objects
+// with the provided function
+func snapshotOf(t *testing.T, path string, modify func(objects []interface{}) []interface{}) *Snapshot {
+	objects := modify(loadObjects(t, path))
+
+	graph, err := GetK8sGraph(objects, nil)
+	assert.MustBeNil(t, err, "graph must be built")
+
+	trees, err := GetK8sTree(objects)
+	assert.MustBeNil(t, err, "trees must be built")
+
+	return NewSnapshot(trees, graph)
+}
+
+// findObject returns the object with the provided UID
+func findObject(objects []interface{}, uid string) map[string]interface{} {
+	for _, obj := range objects {
+		obj := obj.(k8s.KubernetesObject).Object
+		if string((&unstructured.Unstructured{Object: obj}).GetUID()) == uid {
+			return obj
+		}
+	}
+	return nil
+}
+
+// mountConfigMap adds a volume of the provided ConfigMap to the pod template
+// of a Deployment
+func mountConfigMap(t *testing.T, deployment map[string]interface{}, name string) {
+	err := unstructured.SetNestedSlice(deployment, []interface{}{
+		map[string]interface{}{
+			"name":      "config",
+			"configMap": map[string]interface{}{"name": name},
+		},
+	}, "spec", "template", "spec", "volumes")
+	assert.MustBeNil(t, err, "volume must be set")
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	from := snapshotOf(t, "testdata/basic.json", func(objects []interface{}) []interface{} {
+		mountConfigMap(t, findObject(objects, "dep-web"), "settings")
+		return objects
+	})
+
+	to := snapshotOf(t, "testdata/basic.json", func(objects []interface{}) []interface{} {
+		mountConfigMap(t, findObject(objects, "dep-web"), "other")
+
+		// orphan the StatefulSet's pod
+		unstructured.RemoveNestedField(findObject(objects, "pod-db-0"), "metadata", "ownerReferences")
+
+		// replace a pod of the ReplicaSet
+		var replaced []interface{}
+		for _, obj := range objects {
+			if (&unstructured.Unstructured{Object: obj.(k8s.KubernetesObject).Object}).GetUID() != "pod-web-2" {
+				replaced = append(replaced, obj)
+			}
+		}
+		pod := unstructured.Unstructured{Object: map[string]interface{}{}}
+		pod.SetAPIVersion("v1")
+		pod.SetKind("Pod")
+		pod.SetNamespace("default")
+		pod.SetName("web-6d4b-klmno")
+		pod.SetUID("pod-web-3")
+		pod.SetOwnerReferences(ownerReferenceTo(unstructured.Unstructured{Object: findObject(objects, "rs-web")}))
+		return append(replaced, k8s.KubernetesObject{Object: pod.Object})
+	})
+
+	changes := DiffSnapshots(from, to)
+
+	var output bytes.Buffer
+	assert.MustBeNil(t, WriteChanges(&output, changes), "changes must be written")
+	assert.Equal(t, `+ Pod default/web-6d4b-klmno (under ReplicaSet default/web-6d4b)
+- Pod default/web-6d4b-fghij (under ReplicaSet default/web-6d4b)
+~ Pod default/db-0: StatefulSet default/db -> (root)
++ Deployment default/web mounts ConfigMap default/other
+- Deployment default/web mounts ConfigMap default/settings
+`, output.String(), "changes must match")
+
+	assert.Equal(t, 0, len(DiffSnapshots(to, to)), "identical snapshots must not differ")
+}
+
+func TestSnapshotPersistence(t *testing.T) {
+	snapshot := snapshotOf(t, "testdata/basic.json", func(objects []interface{}) []interface{} {
+		return objects
+	})
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	_, err := LoadSnapshot(path)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "missing snapshot must not exist")
+
+	assert.MustBeNil(t, snapshot.Save(path), "snapshot must be saved")
+
+	loaded, err := LoadSnapshot(path)
+	assert.MustBeNil(t, err, "snapshot must be loaded")
+	assert.DeepEqual(t, snapshot, loaded, "loaded snapshot must match saved snapshot")
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+	assert.MustBeNil(t, err, "files must be listed")
+	assert.Equal(t, 1, len(files), "temporary files must be removed")
+
+	_, err = DecodeSnapshot([]byte(`{"version": 2, "nodes": []}`))
+	assert.NotNil(t, err, "unsupported versions must fail")
+}
@@ -0,0 +1,355 @@
+package k8stree
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Formats supported by Export
+const (
+	FormatDOT     = "dot"
+	FormatGraphML = "graphml"
+	FormatMermaid = "mermaid"
+)
+
+// ExportFormats is the list of formats supported by Export
+var ExportFormats = []string{FormatDOT, FormatGraphML, FormatMermaid}
+
+// ExportFilter limits the part of a graph that is exported. Roots are the
+// nodes without incoming ownership or binding edges (e.g. a PersistentVolume
+// is not a root, as it is bound by its PersistentVolumeClaim). When any
+// field is set, only roots matching the namespaces and kinds are exported,
+// along with the nodes reachable from them through outgoing edges of any type
+// (up to Depth edges away, if positive).
+type ExportFilter struct {
+	Namespaces []string
+	RootKinds  []string
+	Depth      int
+}
+
+// TreeGraph converts trees to a graph of their ownership edges, so they can
+// be exported. Objects appearing under several owners are only added once.
+func TreeGraph(trees []ObjectsTree) *Graph {
+	graph := &Graph{Nodes: []Node{}, Edges: []Edge{}}
+	seen := make(map[string]bool)
+
+	var add func(tree ObjectsTree)
+	add = func(tree ObjectsTree) {
+		if !seen[tree.UID] {
+			seen[tree.UID] = true
+			obj := unstructured.Unstructured{Object: tree.Object}
+			graph.Nodes = append(graph.Nodes, Node{
+				UID:        tree.UID,
+				APIVersion: obj.GetAPIVersion(),
+				Kind:       tree.Kind,
+				Namespace:  obj.GetNamespace(),
+				Name:       tree.Name,
+			})
+		}
+
+		for _, child := range tree.Children {
+			graph.Edges = append(graph.Edges, Edge{Type: EdgeOwns, From: tree.UID, To: child.UID})
+			add(child)
+		}
+	}
+
+	for _, tree := range trees {
+		add(tree)
+	}
+
+	return graph
+}
+
+// Filter returns the part of the graph selected by the provided filter, or
+// the graph itself if the filter is empty
+func (graph *Graph) Filter(filter ExportFilter) *Graph {
+	if len(filter.Namespaces) == 0 && len(filter.RootKinds) == 0 && filter.Depth <= 0 {
+		return graph
+	}
+
+	owned := make(map[string]bool)
+	outgoing := make(map[string][]int)
+	for i, edge := range graph.Edges {
+		if edge.Type == EdgeOwns || edge.Type == EdgeBinds {
+			owned[edge.To] = true
+		}
+		outgoing[edge.From] = append(outgoing[edge.From], i)
+	}
+
+	// breadth-first traversal from the matching roots, recording the depth
+	// of every reached node
+	depths := make(map[string]int)
+	var queue []string
+	for _, node := range graph.Nodes {
+		if owned[node.UID] ||
+			(len(filter.Namespaces) > 0 && !includes(filter.Namespaces, node.Namespace)) ||
+			(len(filter.RootKinds) > 0 && !includes(filter.RootKinds, node.Kind)) {
+			continue
+		}
+		depths[node.UID] = 0
+		queue = append(queue, node.UID)
+	}
+
+	for len(queue) > 0 {
+		uid := queue[0]
+		queue = queue[1:]
+		if filter.Depth > 0 && depths[uid] >= filter.Depth {
+			continue
+		}
+
+		for _, i := range outgoing[uid] {
+			to := graph.Edges[i].To
+			if _, ok := depths[to]; !ok {
+				depths[to] = depths[uid] + 1
+				queue = append(queue, to)
+			}
+		}
+	}
+
+	filtered := &Graph{Nodes: []Node{}, Edges: []Edge{}}
+	for _, node := range graph.Nodes {
+		if _, ok := depths[node.UID]; ok {
+			filtered.Nodes = append(filtered.Nodes, node)
+		}
+	}
+	for _, edge := range graph.Edges {
+		_, fromOK := depths[edge.From]
+		_, toOK := depths[edge.To]
+		if fromOK && toOK {
+			filtered.Edges = append(filtered.Edges, edge)
+		}
+	}
+
+	return filtered
+}
+
+// Export writes a graph in one of the supported formats (see ExportFormats).
+// Missing nodes and inferred edges are drawn with dashed lines.
+func Export(w io.Writer, graph *Graph, format string) error {
+	switch format {
+	case FormatDOT:
+		return exportDOT(w, graph)
+	case FormatGraphML:
+		return exportGraphML(w, graph)
+	case FormatMermaid:
+		return exportMermaid(w, graph)
+	}
+
+	return fmt.Errorf("unsupported format %q, must be one of %s", format, strings.Join(ExportFormats, ", "))
+}
+
+// qualifiedName returns the name of a node, prefixed by its namespace (if
+// any)
+func qualifiedName(node Node) string {
+	if node.Namespace != "" {
+		return node.Namespace + "/" + node.Name
+	}
+	return node.Name
+}
+
+func exportDOT(w io.Writer, graph *Graph) error {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var b strings.Builder
+	b.WriteString("digraph k8s {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+
+	for _, node := range graph.Nodes {
+		var attributes []string
+		attributes = append(attributes, fmt.Sprintf(`label="%s"`, quote.Replace(node.Kind+"\n"+qualifiedName(node))))
+		if node.Missing {
+			attributes = append(attributes, "style=dashed")
+		}
+		if node.External {
+			attributes = append(attributes, "shape=ellipse")
+		}
+		fmt.Fprintf(&b, "  \"%s\" [%s];\n", quote.Replace(node.UID), strings.Join(attributes, ", "))
+	}
+
+	for _, edge := range graph.Edges {
+		attributes := []string{fmt.Sprintf(`label="%s"`, quote.Replace(edge.Type))}
+		if edge.Inferred {
+			attributes = append(attributes, "style=dashed")
+		}
+		fmt.Fprintf(
+			&b,
+			"  \"%s\" -> \"%s\" [%s];\n",
+			quote.Replace(edge.From),
+			quote.Replace(edge.To),
+			strings.Join(attributes, ", "),
+		)
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// graphML is the document structure of GraphML files
+// (http://graphml.graphdrawing.org/)
+type graphML struct {
+	XMLName xml.Name         `xml:"graphml"`
+	XMLNS   string           `xml:"xmlns,attr"`
+	Keys    []graphMLKey     `xml:"key"`
+	Graph   graphMLGraphBody `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraphBody struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func exportGraphML(w io.Writer, graph *Graph) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "apiVersion", For: "node", AttrName: "apiVersion", AttrType: "string"},
+			{ID: "kind", For: "node", AttrName: "kind", AttrType: "string"},
+			{ID: "namespace", For: "node", AttrName: "namespace", AttrType: "string"},
+			{ID: "name", For: "node", AttrName: "name", AttrType: "string"},
+			{ID: "missing", For: "node", AttrName: "missing", AttrType: "boolean"},
+			{ID: "external", For: "node", AttrName: "external", AttrType: "boolean"},
+			{ID: "type", For: "edge", AttrName: "type", AttrType: "string"},
+			{ID: "controller", For: "edge", AttrName: "controller", AttrType: "boolean"},
+			{ID: "inferred", For: "edge", AttrName: "inferred", AttrType: "boolean"},
+		},
+		Graph: graphMLGraphBody{
+			ID:          "k8s",
+			EdgeDefault: "directed",
+			Nodes:       make([]graphMLNode, 0, len(graph.Nodes)),
+			Edges:       make([]graphMLEdge, 0, len(graph.Edges)),
+		},
+	}
+
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: node.UID,
+			Data: []graphMLData{
+				{Key: "apiVersion", Value: node.APIVersion},
+				{Key: "kind", Value: node.Kind},
+				{Key: "namespace", Value: node.Namespace},
+				{Key: "name", Value: node.Name},
+				{Key: "missing", Value: fmt.Sprint(node.Missing)},
+				{Key: "external", Value: fmt.Sprint(node.External)},
+			},
+		})
+	}
+
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: edge.From,
+			Target: edge.To,
+			Data: []graphMLData{
+				{Key: "type", Value: edge.Type},
+				{Key: "controller", Value: fmt.Sprint(edge.Controller)},
+				{Key: "inferred", Value: fmt.Sprint(edge.Inferred)},
+			},
+		})
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return fmt.Errorf("failed encoding GraphML: %w", err)
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func exportMermaid(w io.Writer, graph *Graph) error {
+	// node identifiers are generated, as UIDs may include characters that
+	// are not allowed in Mermaid identifiers
+	ids := make(map[string]string, len(graph.Nodes))
+	quote := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+
+	var missing, external []string
+	for i, node := range graph.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[node.UID] = id
+
+		fmt.Fprintf(&b, "  %s[\"%s<br/>%s\"]\n", id, quote.Replace(node.Kind), quote.Replace(qualifiedName(node)))
+
+		if node.Missing {
+			missing = append(missing, id)
+		}
+		if node.External {
+			external = append(external, id)
+		}
+	}
+
+	for _, edge := range graph.Edges {
+		from, fromOK := ids[edge.From]
+		to, toOK := ids[edge.To]
+		if !fromOK || !toOK {
+			continue
+		}
+
+		arrow := "-->"
+		if edge.Inferred {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&b, "  %s %s|%s| %s\n", from, arrow, quote.Replace(edge.Type), to)
+	}
+
+	if len(missing) > 0 {
+		b.WriteString("  classDef missing stroke-dasharray: 5 5\n")
+		fmt.Fprintf(&b, "  class %s missing\n", strings.Join(missing, ","))
+	}
+	if len(external) > 0 {
+		b.WriteString("  classDef external fill:#eee\n")
+		fmt.Fprintf(&b, "  class %s external\n", strings.Join(external, ","))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func includes(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
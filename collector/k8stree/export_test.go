@@ -0,0 +1,104 @@
+package k8stree
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+)
+
+func TestExport(t *testing.T) {
+	graph, err := GetK8sGraph(loadObjects(t, "testdata/rbac.json"), nil)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	// include an inferred edge and characters that must be escaped
+	graph.Nodes = append(graph.Nodes, Node{UID: `odd"uid`, Kind: "ConfigMap", Namespace: "ci", Name: `say "hi" <now>`})
+	graph.Edges = append(graph.Edges, Edge{Type: EdgeMounts, From: "sa-deployer", To: `odd"uid`, Inferred: true})
+
+	for format, golden := range map[string]string{
+		FormatDOT:     "testdata/rbac.export.dot",
+		FormatGraphML: "testdata/rbac.export.graphml",
+		FormatMermaid: "testdata/rbac.export.mmd",
+	} {
+		t.Run(format, func(t *testing.T) {
+			var output bytes.Buffer
+			assert.MustBeNil(t, Export(&output, graph, format), "graph must be exported")
+
+			if *update {
+				assert.MustBeNil(t, ioutil.WriteFile(golden, output.Bytes(), 0644), "golden file must be written")
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			assert.MustBeNil(t, err, "golden file must be readable")
+			assert.Equal(t, string(expected), output.String(), "output must match golden file")
+		})
+	}
+
+	assert.NotNil(t, Export(&bytes.Buffer{}, graph, "svg"), "unsupported formats must fail")
+}
+
+func TestGraphFilter(t *testing.T) {
+	graph, err := GetK8sGraph(loadObjects(t, "testdata/basic.json"), nil)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	uids := func(graph *Graph) []string {
+		var list []string
+		for _, node := range graph.Nodes {
+			list = append(list, node.UID)
+		}
+		return list
+	}
+
+	tests := []struct {
+		name     string
+		filter   ExportFilter
+		expected []string
+	}{
+		{
+			name:     "root kind",
+			filter:   ExportFilter{RootKinds: []string{"StatefulSet"}},
+			expected: []string{"sts-db", "pod-db-0", "pvc-db-0", "pv-db-0"},
+		},
+		{
+			name:     "root kind and depth",
+			filter:   ExportFilter{RootKinds: []string{"StatefulSet"}, Depth: 1},
+			expected: []string{"sts-db", "pod-db-0", "pvc-db-0"},
+		},
+		{
+			name:     "namespace of roots",
+			filter:   ExportFilter{Namespaces: []string{"other"}},
+			expected: nil,
+		},
+		{
+			name:     "cluster-scoped roots",
+			filter:   ExportFilter{Namespaces: []string{""}, RootKinds: []string{"PersistentVolume"}},
+			expected: []string{"pv-manual"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.DeepEqual(t, test.expected, uids(graph.Filter(test.filter)), "nodes must match")
+		})
+	}
+
+	assert.True(t, graph.Filter(ExportFilter{}) == graph, "empty filter must return the graph")
+}
+
+func TestTreeGraph(t *testing.T) {
+	trees, err := GetK8sTree(loadObjects(t, "testdata/ownership.json"))
+	assert.MustBeNil(t, err, "error must be nil")
+
+	graph := TreeGraph(trees)
+
+	seen := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		assert.False(t, seen[node.UID], "nodes must not be duplicated")
+		seen[node.UID] = true
+	}
+	for _, edge := range graph.Edges {
+		assert.Equal(t, EdgeOwns, edge.Type, "tree edges must be ownership edges")
+		assert.True(t, seen[edge.From] && seen[edge.To], "edges must connect exported nodes")
+	}
+}
@@ -0,0 +1,369 @@
+package k8stree
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+// Types of relationships between objects
+const (
+	// EdgeOwns is a relationship from an owner to an object it owns or
+	// manages, e.g. a ReplicaSet to its Pods
+	EdgeOwns = "owns"
+
+	// EdgeSelects is a relationship from an object to the objects it selects
+	// or targets, e.g. a Service to the Pods matching its selector
+	EdgeSelects = "selects"
+
+	// EdgeMounts is a relationship from an object to a volume or
+	// configuration object it consumes, e.g. a StatefulSet to its
+	// PersistentVolumeClaims
+	EdgeMounts = "mounts"
+
+	// EdgeBinds is a relationship from an object to an object it is bound
+	// to, e.g. a PersistentVolumeClaim to its PersistentVolume
+	EdgeBinds = "binds"
+
+	// EdgeRoutes is a relationship from an object routing traffic to the
+	// objects receiving it, e.g. an Ingress to its backend Services
+	EdgeRoutes = "routes"
+
+	// EdgeAttaches is a relationship from an object to the object it is
+	// attached to or handled by, e.g. an HTTPRoute to its parent Gateway
+	EdgeAttaches = "attaches"
+
+	// EdgeImplements is a relationship from a controller workload to the
+	// class it implements, e.g. an ingress controller's Deployment to its
+	// IngressClass
+	EdgeImplements = "implements"
+
+	// EdgeUses is a relationship from a workload to an object its pods use
+	// without consuming its contents directly, e.g. its ServiceAccount
+	EdgeUses = "uses"
+
+	// EdgeRunsOn is a relationship from a Pod to the Node it is scheduled on
+	EdgeRunsOn = "runsOn"
+
+	// EdgeGrants is a relationship from an RBAC binding to a subject it
+	// grants a role to (a ServiceAccount, user or group)
+	EdgeGrants = "grants"
+
+	// EdgeAggregates is a relationship from an aggregated ClusterRole to the
+	// ClusterRoles matching its aggregation rule
+	EdgeAggregates = "aggregates"
+)
+
+// Graph is the graph of relationships between Kubernetes objects. Unlike
+// ObjectsTree, an object appears exactly once no matter how many objects it
+// relates to, and relationships other than ownership are represented as well.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Node is an object in the relationships graph
+type Node struct {
+	UID        string `json:"uid"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+
+	// Missing is true if the object is referenced by other objects but was
+	// not collected
+	Missing bool `json:"missing,omitempty"`
+
+	// External is true if the node is not a Kubernetes object, but an
+	// identity managed outside the cluster (i.e. RBAC users and groups)
+	External bool `json:"external,omitempty"`
+}
+
+// Edge is a directed relationship between two objects, identified by their
+// UIDs
+type Edge struct {
+	// Type is one of the Edge constants
+	Type string `json:"type"`
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Controller is true for ownership edges whose owner reference is marked
+	// as the managing controller
+	Controller bool `json:"controller,omitempty"`
+
+	// Inferred is true if the relationship is not explicitly declared by the
+	// objects but inferred from naming conventions
+	Inferred bool `json:"inferred,omitempty"`
+}
+
+// relationFunc finds the relationships of an object to other indexed objects
+type relationFunc func(idx *objectIndex, obj unstructured.Unstructured) []Edge
+
+// relations are all functions used to find relationships between objects
+var relations = []relationFunc{
+	ownerRelations,
+	specialParentRelations,
+	serviceRelations,
+	networkPolicyRelations,
+	podDisruptionBudgetRelations,
+	autoscalerRelations,
+	endpointSliceRelations,
+	ingressRelations,
+	classRelations,
+	gatewayRelations,
+	routeRelations,
+	podDependencyRelations,
+	nodeRelations,
+	bindingRelations,
+	aggregationRelations,
+}
+
+// objectIndex indexes a list of Kubernetes objects for relationship lookups
+type objectIndex struct {
+	objects []unstructured.Unstructured
+
+	// positions of objects by UID
+	byUID map[string]int
+
+	// positions of objects by namespace, group, kind and name
+	byKey map[objectKey]int
+
+	// positions of objects by kind
+	kinds map[string][]int
+
+	// Pods by their labels, and workloads not managed by other controllers
+	// by the labels of their pod templates, per namespace
+	pods      map[string]*labelIndex
+	templates map[string]*labelIndex
+
+	// ClusterRoles by their labels
+	clusterRoles *labelIndex
+
+	// positions of IngressClasses, and of ReferenceGrants by namespace
+	ingressClasses  []int
+	referenceGrants map[string][]int
+
+	parents *specialParents
+
+	// nodes of missing objects referenced by name, and of RBAC identities,
+	// and their positions in extraNodes by UID
+	extraNodes []Node
+	extra      map[string]int
+}
+
+func newObjectIndex(objects []unstructured.Unstructured) *objectIndex {
+	idx := &objectIndex{
+		objects:         objects,
+		byUID:           make(map[string]int, len(objects)),
+		byKey:           make(map[objectKey]int, len(objects)),
+		kinds:           make(map[string][]int),
+		pods:            make(map[string]*labelIndex),
+		templates:       make(map[string]*labelIndex),
+		clusterRoles:    &labelIndex{},
+		referenceGrants: make(map[string][]int),
+		parents:         newSpecialParents(objects),
+		extra:           make(map[string]int),
+	}
+
+	for i, obj := range objects {
+		idx.byUID[string(obj.GetUID())] = i
+		idx.byKey[keyOf(obj)] = i
+		idx.kinds[obj.GetKind()] = append(idx.kinds[obj.GetKind()], i)
+
+		namespace := obj.GetNamespace()
+		switch {
+		case obj.GetKind() == "Pod":
+			if idx.pods[namespace] == nil {
+				idx.pods[namespace] = &labelIndex{}
+			}
+			idx.pods[namespace].add(i, obj.GetLabels())
+		case workloadKinds[obj.GetKind()] && !hasPodTemplateController(obj):
+			templateLabels, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "labels")
+			if idx.templates[namespace] == nil {
+				idx.templates[namespace] = &labelIndex{}
+			}
+			idx.templates[namespace].add(i, templateLabels)
+		case obj.GetKind() == "ClusterRole" && obj.GroupVersionKind().Group == rbacGroup:
+			idx.clusterRoles.add(i, obj.GetLabels())
+		case obj.GetKind() == "IngressClass":
+			idx.ingressClasses = append(idx.ingressClasses, i)
+		case obj.GetKind() == "ReferenceGrant" && obj.GroupVersionKind().Group == gatewayGroup:
+			idx.referenceGrants[namespace] = append(idx.referenceGrants[namespace], i)
+		}
+	}
+
+	return idx
+}
+
+// GetK8sGraph builds the graph of relationships between the provided
+// Kubernetes objects, including those declared by the provided relationship
+// rules (see LoadRelationshipRules). The provided objects are not modified.
+func GetK8sGraph(objects []interface{}, rules []RelationshipRule) (*Graph, error) {
+	unstructuredObjects := make([]unstructured.Unstructured, len(objects))
+	for i, obj := range objects {
+		unstructuredObjects[i] = unstructured.Unstructured{
+			Object: obj.(k8s.KubernetesObject).Object,
+		}
+	}
+
+	idx := newObjectIndex(unstructuredObjects)
+
+	graph := &Graph{
+		Nodes: make([]Node, 0, len(unstructuredObjects)),
+		Edges: []Edge{},
+	}
+	for _, obj := range unstructuredObjects {
+		graph.Nodes = append(graph.Nodes, Node{
+			UID:        string(obj.GetUID()),
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		})
+	}
+
+	objectRelations := make([]relationFunc, len(relations), len(relations)+len(rules))
+	copy(objectRelations, relations)
+	for i := range rules {
+		objectRelations = append(objectRelations, rules[i].relations)
+	}
+
+	type edgeKey struct{ edgeType, from, to string }
+	seen := make(map[edgeKey]bool)
+
+	for _, obj := range unstructuredObjects {
+		for _, relation := range objectRelations {
+			for _, edge := range relation(idx, obj) {
+				if edge.From == "" || edge.To == "" {
+					continue
+				}
+
+				key := edgeKey{edge.Type, edge.From, edge.To}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				graph.Edges = append(graph.Edges, edge)
+			}
+		}
+	}
+
+	// owners that were not collected are added as missing nodes
+	missing := make(map[string]bool)
+	for _, obj := range unstructuredObjects {
+		for _, ownerRef := range obj.GetOwnerReferences() {
+			uid := string(ownerRef.UID)
+			if _, ok := idx.byUID[uid]; ok || uid == "" || missing[uid] {
+				continue
+			}
+			missing[uid] = true
+
+			graph.Nodes = append(graph.Nodes, Node{
+				UID:        uid,
+				APIVersion: ownerRef.APIVersion,
+				Kind:       ownerRef.Kind,
+				Namespace:  idx.ownerNamespace(obj, ownerRef),
+				Name:       ownerRef.Name,
+				Missing:    true,
+			})
+		}
+	}
+
+	graph.Nodes = append(graph.Nodes, idx.extraNodes...)
+
+	return graph, nil
+}
+
+// ownerRelations returns an ownership edge from each of an object's owners
+func ownerRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	var edges []Edge
+	for _, ownerRef := range obj.GetOwnerReferences() {
+		edges = append(edges, Edge{
+			Type:       EdgeOwns,
+			From:       string(ownerRef.UID),
+			To:         string(obj.GetUID()),
+			Controller: ownerRef.Controller != nil && *ownerRef.Controller,
+		})
+	}
+	return edges
+}
+
+// specialParentRelations returns edges from the special parents of Endpoints,
+// PersistentVolumes and PersistentVolumeClaims without owner references (see
+// specialParents), matching the objects trees. Edges to PersistentVolumes are
+// declared by their claimRef or by the claim's volumeName, the others are
+// inferred from names.
+func specialParentRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if len(obj.GetOwnerReferences()) > 0 {
+		return nil
+	}
+
+	var edgeType string
+	switch obj.GetKind() {
+	case "Endpoints":
+		edgeType = EdgeOwns
+	case "PersistentVolume":
+		edgeType = EdgeBinds
+	case "PersistentVolumeClaim":
+		edgeType = EdgeMounts
+	default:
+		return nil
+	}
+
+	var edges []Edge
+	for _, ownerRef := range idx.parents.ownerOf(obj) {
+		edges = append(edges, Edge{
+			Type:     edgeType,
+			From:     string(ownerRef.UID),
+			To:       string(obj.GetUID()),
+			Inferred: edgeType != EdgeBinds,
+		})
+	}
+	return edges
+}
+
+// clusterScopedOwnerKinds are the built-in cluster-scoped kinds that may own
+// namespaced objects
+var clusterScopedOwnerKinds = map[groupKind]bool{
+	{"", "Namespace"}:        true,
+	{"", "Node"}:             true,
+	{"", "PersistentVolume"}: true,
+	{"apiextensions.k8s.io", "CustomResourceDefinition"}:               true,
+	{"apiregistration.k8s.io", "APIService"}:                           true,
+	{"rbac.authorization.k8s.io", "ClusterRole"}:                       true,
+	{"rbac.authorization.k8s.io", "ClusterRoleBinding"}:                true,
+	{"storage.k8s.io", "StorageClass"}:                                 true,
+	{"scheduling.k8s.io", "PriorityClass"}:                             true,
+	{"admissionregistration.k8s.io", "ValidatingWebhookConfiguration"}: true,
+	{"admissionregistration.k8s.io", "MutatingWebhookConfiguration"}:   true,
+}
+
+// ownerNamespace returns the namespace of an object's owner. Owners of
+// cluster-scoped objects are cluster-scoped, while owners of namespaced
+// objects are in the same namespace, unless their kind is cluster-scoped. The
+// scope of the owner's kind is taken from collected objects of the same kind,
+// or if none were collected, from the built-in cluster-scoped kinds.
+func (idx *objectIndex) ownerNamespace(obj unstructured.Unstructured, ownerRef metav1.OwnerReference) string {
+	if obj.GetNamespace() == "" {
+		return ""
+	}
+
+	gv, _ := schema.ParseGroupVersion(ownerRef.APIVersion)
+	for _, i := range idx.kinds[ownerRef.Kind] {
+		if idx.objects[i].GroupVersionKind().Group == gv.Group {
+			if idx.objects[i].GetNamespace() == "" {
+				return ""
+			}
+			return obj.GetNamespace()
+		}
+	}
+
+	if clusterScopedOwnerKinds[groupKind{gv.Group, ownerRef.Kind}] {
+		return ""
+	}
+
+	return obj.GetNamespace()
+}
@@ -0,0 +1,588 @@
+package k8stree
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Health statuses, as defined by Argo's gitops-engine
+// (https://github.com/argoproj/gitops-engine/blob/master/pkg/health/health.go)
+const (
+	// HealthHealthy is an object that is fully operational
+	HealthHealthy = "Healthy"
+
+	// HealthProgressing is an object that is not healthy yet, but may become
+	// healthy given time (e.g. a Deployment during a rollout)
+	HealthProgressing = "Progressing"
+
+	// HealthDegraded is an object that failed, or cannot become healthy
+	// (e.g. a Pod in a crash loop)
+	HealthDegraded = "Degraded"
+
+	// HealthSuspended is an object that is paused or suspended (e.g. a paused
+	// Deployment or a suspended CronJob)
+	HealthSuspended = "Suspended"
+
+	// HealthMissing is an object that is expected to exist but does not, as
+	// reported by the object managing it (e.g. an Argo CD Application)
+	HealthMissing = "Missing"
+
+	// HealthUnknown is an object whose health cannot be determined from its
+	// status
+	HealthUnknown = "Unknown"
+)
+
+// healthSeverity orders health statuses from best to worst, as used when
+// rolling statuses up the trees. Unlike gitops-engine, Unknown is ordered
+// first, so that objects with an unknown status never mask the known status
+// of other objects.
+var healthSeverity = map[string]int{
+	HealthUnknown:     0,
+	HealthHealthy:     1,
+	HealthSuspended:   2,
+	HealthProgressing: 3,
+	HealthMissing:     4,
+	HealthDegraded:    5,
+}
+
+// Health is the health status of an object, along with a message explaining
+// it (if the status is not healthy)
+type Health struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// healthFunc evaluates the health of objects of a specific kind
+type healthFunc func(obj unstructured.Unstructured) *Health
+
+// healthChecks are the health checks of built-in kinds and common CRDs, by
+// group and kind. Objects of other kinds are checked by their conditions (see
+// conditionHealth).
+var healthChecks = map[groupKind]healthFunc{
+	{"apps", "Deployment"}:                     deploymentHealth,
+	{"apps", "StatefulSet"}:                    statefulSetHealth,
+	{"apps", "DaemonSet"}:                      daemonSetHealth,
+	{"apps", "ReplicaSet"}:                     replicaSetHealth,
+	{"", "ReplicationController"}:              replicaSetHealth,
+	{"", "Pod"}:                                podHealth,
+	{"batch", "Job"}:                           jobHealth,
+	{"batch", "CronJob"}:                       cronJobHealth,
+	{"", "PersistentVolumeClaim"}:              persistentVolumeClaimHealth,
+	{"", "PersistentVolume"}:                   persistentVolumeHealth,
+	{"", "Service"}:                            serviceHealth,
+	{"networking.k8s.io", "Ingress"}:           ingressHealth,
+	{"extensions", "Ingress"}:                  ingressHealth,
+	{"autoscaling", "HorizontalPodAutoscaler"}: autoscalerHealth,
+	{"apiregistration.k8s.io", "APIService"}:   apiServiceHealth,
+	{"argoproj.io", "Rollout"}:                 rolloutHealth,
+	{"argoproj.io", "Application"}:             applicationHealth,
+}
+
+// groupKind identifies a kind of objects regardless of version
+type groupKind struct {
+	Group string
+	Kind  string
+}
+
+// ObjectHealth evaluates the health of an object, returning nil for objects
+// without a health check (e.g. ConfigMaps), and for objects whose spec and
+// status were not collected (see config.Config.MetadataOnlyResources).
+func ObjectHealth(obj unstructured.Unstructured) *Health {
+	_, hasSpec := obj.Object["spec"]
+	_, hasStatus := obj.Object["status"]
+	if !hasSpec && !hasStatus {
+		return nil
+	}
+
+	check, ok := healthChecks[groupKind{obj.GroupVersionKind().Group, obj.GetKind()}]
+	if ok {
+		return check(obj)
+	}
+
+	return conditionHealth(obj)
+}
+
+// worseHealth returns the worse of two health statuses, preferring the first
+// if they are equally severe. Nil statuses are ignored.
+func worseHealth(a, b *Health) *Health {
+	if a == nil {
+		return b
+	}
+	if b == nil || healthSeverity[b.Status] <= healthSeverity[a.Status] {
+		return a
+	}
+	return b
+}
+
+// aggregateHealth rolls the health of a tree's descendants up to the tree:
+// the aggregated health of a tree is the worst of its own health and the
+// aggregated health of its children. Statuses coming from descendants are
+// prefixed by the kind and name of the object they belong to (and its
+// namespace, if different from the tree's).
+func aggregateHealth(tree *ObjectsTree) {
+	namespace, _, _ := unstructured.NestedString(tree.Object, "metadata", "namespace")
+
+	tree.AggregatedHealth = tree.Health
+	for _, child := range tree.Children {
+		worst := worseHealth(tree.AggregatedHealth, child.AggregatedHealth)
+		if worst == tree.AggregatedHealth {
+			continue
+		}
+
+		health := *worst
+		if worst == child.Health {
+			childNamespace, _, _ := unstructured.NestedString(child.Object, "metadata", "namespace")
+			name := child.Name
+			if childNamespace != "" && childNamespace != namespace {
+				name = childNamespace + "/" + name
+			}
+			health.Message = strings.TrimSuffix(fmt.Sprintf("%s %s: %s", child.Kind, name, health.Message), ": ")
+		}
+		tree.AggregatedHealth = &health
+	}
+}
+
+func healthy() *Health {
+	return &Health{Status: HealthHealthy}
+}
+
+func progressing(format string, args ...interface{}) *Health {
+	return &Health{Status: HealthProgressing, Message: fmt.Sprintf(format, args...)}
+}
+
+func degraded(format string, args ...interface{}) *Health {
+	return &Health{Status: HealthDegraded, Message: fmt.Sprintf(format, args...)}
+}
+
+func suspended(format string, args ...interface{}) *Health {
+	return &Health{Status: HealthSuspended, Message: fmt.Sprintf(format, args...)}
+}
+
+func nestedInt(obj map[string]interface{}, fields ...string) int64 {
+	value, _, _ := unstructured.NestedFieldNoCopy(obj, fields...)
+	switch value := value.(type) {
+	case int64:
+		return value
+	case int:
+		return int64(value)
+	case float64:
+		return int64(value)
+	}
+	return 0
+}
+
+func nestedBool(obj map[string]interface{}, fields ...string) bool {
+	value, _, _ := unstructured.NestedBool(obj, fields...)
+	return value
+}
+
+// replicas returns the desired number of replicas of a workload, which
+// defaults to 1
+func replicas(obj unstructured.Unstructured) int64 {
+	if _, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "replicas"); !found {
+		return 1
+	}
+	return nestedInt(obj.Object, "spec", "replicas")
+}
+
+// generationObserved checks whether the controller of an object has observed
+// its latest generation
+func generationObserved(obj unstructured.Unstructured) bool {
+	return obj.GetGeneration() <= nestedInt(obj.Object, "status", "observedGeneration")
+}
+
+// condition is an entry of an object's status.conditions
+type condition struct {
+	Status  string
+	Reason  string
+	Message string
+}
+
+// findCondition returns the condition of the provided type from an object's
+// status
+func findCondition(obj unstructured.Unstructured, conditionType string) (condition, bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, item := range conditions {
+		item, ok := item.(map[string]interface{})
+		if !ok || nestedString(item, "type") != conditionType {
+			continue
+		}
+		return condition{
+			Status:  nestedString(item, "status"),
+			Reason:  nestedString(item, "reason"),
+			Message: nestedString(item, "message"),
+		}, true
+	}
+	return condition{}, false
+}
+
+func deploymentHealth(obj unstructured.Unstructured) *Health {
+	if nestedBool(obj.Object, "spec", "paused") {
+		return suspended("Deployment is paused")
+	}
+
+	if !generationObserved(obj) {
+		return progressing("Waiting for rollout to finish: observed deployment generation less than desired generation")
+	}
+
+	if cond, ok := findCondition(obj, "Progressing"); ok && cond.Reason == "ProgressDeadlineExceeded" {
+		return degraded("Deployment %q exceeded its progress deadline", obj.GetName())
+	}
+
+	desired := replicas(obj)
+	current := nestedInt(obj.Object, "status", "replicas")
+	updated := nestedInt(obj.Object, "status", "updatedReplicas")
+	available := nestedInt(obj.Object, "status", "availableReplicas")
+
+	switch {
+	case updated < desired:
+		return progressing(
+			"Waiting for rollout to finish: %d out of %d new replicas have been updated...",
+			updated,
+			desired,
+		)
+	case current > updated:
+		return progressing(
+			"Waiting for rollout to finish: %d old replicas are pending termination...",
+			current-updated,
+		)
+	case available < updated:
+		return progressing(
+			"Waiting for rollout to finish: %d of %d updated replicas are available...",
+			available,
+			updated,
+		)
+	}
+
+	return healthy()
+}
+
+func statefulSetHealth(obj unstructured.Unstructured) *Health {
+	if nestedInt(obj.Object, "status", "observedGeneration") == 0 || !generationObserved(obj) {
+		return progressing("Waiting for statefulset spec update to be observed...")
+	}
+
+	desired := replicas(obj)
+	ready := nestedInt(obj.Object, "status", "readyReplicas")
+	if ready < desired {
+		return progressing("Waiting for %d pods to be ready...", desired-ready)
+	}
+
+	strategy := nestedString(obj.Object, "spec", "updateStrategy", "type")
+	if strategy == "OnDelete" {
+		return healthy()
+	}
+
+	if partition := nestedInt(obj.Object, "spec", "updateStrategy", "rollingUpdate", "partition"); partition > 0 {
+		updated := nestedInt(obj.Object, "status", "updatedReplicas")
+		if updated < desired-partition {
+			return progressing(
+				"Waiting for partitioned roll out to finish: %d out of %d new pods have been updated...",
+				updated,
+				desired-partition,
+			)
+		}
+		return healthy()
+	}
+
+	currentRevision := nestedString(obj.Object, "status", "currentRevision")
+	updateRevision := nestedString(obj.Object, "status", "updateRevision")
+	if updateRevision != "" && currentRevision != updateRevision {
+		return progressing(
+			"waiting for statefulset rolling update to complete %d pods at revision %s...",
+			nestedInt(obj.Object, "status", "updatedReplicas"),
+			updateRevision,
+		)
+	}
+
+	return healthy()
+}
+
+func daemonSetHealth(obj unstructured.Unstructured) *Health {
+	if !generationObserved(obj) {
+		return progressing("Waiting for rollout to finish: observed daemon set generation less than desired generation")
+	}
+
+	if nestedString(obj.Object, "spec", "updateStrategy", "type") == "OnDelete" {
+		return healthy()
+	}
+
+	desired := nestedInt(obj.Object, "status", "desiredNumberScheduled")
+	updated := nestedInt(obj.Object, "status", "updatedNumberScheduled")
+	available := nestedInt(obj.Object, "status", "numberAvailable")
+
+	switch {
+	case updated < desired:
+		return progressing(
+			"Waiting for daemon set %q rollout to finish: %d out of %d new pods have been updated...",
+			obj.GetName(),
+			updated,
+			desired,
+		)
+	case available < desired:
+		return progressing(
+			"Waiting for daemon set %q rollout to finish: %d of %d updated pods are available...",
+			obj.GetName(),
+			available,
+			desired,
+		)
+	}
+
+	return healthy()
+}
+
+func replicaSetHealth(obj unstructured.Unstructured) *Health {
+	if !generationObserved(obj) {
+		return progressing("Waiting for rollout to finish: observed replica set generation less than desired generation")
+	}
+
+	if cond, ok := findCondition(obj, "ReplicaFailure"); ok && cond.Status == "True" {
+		return degraded("%s", cond.Message)
+	}
+
+	desired := replicas(obj)
+	available := nestedInt(obj.Object, "status", "availableReplicas")
+	if available < desired {
+		return progressing("Waiting for rollout to finish: %d out of %d new replicas are available...", available, desired)
+	}
+
+	return healthy()
+}
+
+func podHealth(obj unstructured.Unstructured) *Health {
+	restartPolicy := nestedString(obj.Object, "spec", "restartPolicy")
+
+	// containers of pods that are always restarted are considered failed
+	// when waiting due to an error, even if the pod is running
+	if restartPolicy == "" || restartPolicy == "Always" {
+		for _, key := range []string{"initContainerStatuses", "containerStatuses"} {
+			statuses, _, _ := unstructured.NestedSlice(obj.Object, "status", key)
+			for _, status := range statuses {
+				status, ok := status.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				reason := nestedString(status, "state", "waiting", "reason")
+				if strings.HasPrefix(reason, "Err") ||
+					strings.HasSuffix(reason, "Error") ||
+					strings.HasSuffix(reason, "BackOff") {
+					message := nestedString(status, "state", "waiting", "message")
+					if message == "" {
+						message = reason
+					}
+					return degraded("%s", message)
+				}
+			}
+		}
+	}
+
+	message := nestedString(obj.Object, "status", "message")
+
+	switch nestedString(obj.Object, "status", "phase") {
+	case "Pending":
+		return progressing("%s", message)
+	case "Succeeded":
+		return &Health{Status: HealthHealthy, Message: message}
+	case "Failed":
+		if message == "" {
+			message = failedContainerMessage(obj)
+		}
+		return degraded("%s", message)
+	case "Running":
+		if restartPolicy != "" && restartPolicy != "Always" {
+			// the pod runs to completion
+			return progressing("%s", message)
+		}
+		if cond, ok := findCondition(obj, "Ready"); ok && cond.Status == "True" {
+			return &Health{Status: HealthHealthy, Message: message}
+		}
+		return progressing("%s", message)
+	}
+
+	return &Health{Status: HealthUnknown, Message: message}
+}
+
+// failedContainerMessage returns the message of the first container of a
+// failed Pod that terminated unsuccessfully
+func failedContainerMessage(obj unstructured.Unstructured) string {
+	statuses, _, _ := unstructured.NestedSlice(obj.Object, "status", "containerStatuses")
+	for _, status := range statuses {
+		status, ok := status.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if nestedInt(status, "state", "terminated", "exitCode") != 0 {
+			if message := nestedString(status, "state", "terminated", "message"); message != "" {
+				return message
+			}
+			return fmt.Sprintf(
+				"container %q failed with exit code %d",
+				nestedString(status, "name"),
+				nestedInt(status, "state", "terminated", "exitCode"),
+			)
+		}
+	}
+	return ""
+}
+
+func jobHealth(obj unstructured.Unstructured) *Health {
+	if cond, ok := findCondition(obj, "Failed"); ok && cond.Status == "True" {
+		return degraded("%s", cond.Message)
+	}
+	if cond, ok := findCondition(obj, "Complete"); ok && cond.Status == "True" {
+		return &Health{Status: HealthHealthy, Message: cond.Message}
+	}
+	if nestedBool(obj.Object, "spec", "suspend") {
+		return suspended("Job is suspended")
+	}
+	return progressing("Job is running")
+}
+
+func cronJobHealth(obj unstructured.Unstructured) *Health {
+	if nestedBool(obj.Object, "spec", "suspend") {
+		return suspended("CronJob is suspended")
+	}
+	return healthy()
+}
+
+func persistentVolumeClaimHealth(obj unstructured.Unstructured) *Health {
+	switch phase := nestedString(obj.Object, "status", "phase"); phase {
+	case "Bound":
+		return healthy()
+	case "Pending":
+		return progressing("PersistentVolumeClaim is pending")
+	case "Lost":
+		return degraded("PersistentVolumeClaim lost its volume")
+	default:
+		return &Health{Status: HealthUnknown, Message: phase}
+	}
+}
+
+func persistentVolumeHealth(obj unstructured.Unstructured) *Health {
+	switch phase := nestedString(obj.Object, "status", "phase"); phase {
+	case "Bound", "Available", "Released":
+		return healthy()
+	case "Pending":
+		return progressing("PersistentVolume is pending")
+	case "Failed":
+		return degraded(nestedString(obj.Object, "status", "message"))
+	default:
+		return &Health{Status: HealthUnknown, Message: phase}
+	}
+}
+
+// loadBalancerHealth checks whether a load balancer was provisioned
+func loadBalancerHealth(obj unstructured.Unstructured) *Health {
+	ingresses, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+	if len(ingresses) > 0 {
+		return healthy()
+	}
+	return progressing("Waiting for load balancer to be provisioned")
+}
+
+func serviceHealth(obj unstructured.Unstructured) *Health {
+	if nestedString(obj.Object, "spec", "type") == "LoadBalancer" {
+		return loadBalancerHealth(obj)
+	}
+	return healthy()
+}
+
+func ingressHealth(obj unstructured.Unstructured) *Health {
+	return loadBalancerHealth(obj)
+}
+
+func autoscalerHealth(obj unstructured.Unstructured) *Health {
+	for _, conditionType := range []string{"AbleToScale", "ScalingActive"} {
+		cond, ok := findCondition(obj, conditionType)
+		if !ok || cond.Status != "False" {
+			continue
+		}
+		switch cond.Reason {
+		case "FailedGetScale", "FailedUpdateScale", "FailedGetResourceMetric", "InvalidSelector":
+			return degraded("%s", cond.Message)
+		}
+	}
+	return healthy()
+}
+
+func apiServiceHealth(obj unstructured.Unstructured) *Health {
+	cond, ok := findCondition(obj, "Available")
+	if !ok {
+		return progressing("Waiting to be processed")
+	}
+	if cond.Status == "True" {
+		return &Health{Status: HealthHealthy, Message: cond.Message}
+	}
+	return progressing("%s", cond.Message)
+}
+
+// rolloutHealth evaluates Argo Rollouts, which report their own phase
+func rolloutHealth(obj unstructured.Unstructured) *Health {
+	pauseConditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "pauseConditions")
+	if nestedBool(obj.Object, "spec", "paused") || len(pauseConditions) > 0 {
+		return suspended("Rollout is paused")
+	}
+
+	if !generationObserved(obj) {
+		return progressing("Waiting for rollout spec update to be observed")
+	}
+
+	message := nestedString(obj.Object, "status", "message")
+	switch phase := nestedString(obj.Object, "status", "phase"); phase {
+	case "Healthy":
+		return healthy()
+	case "Paused":
+		return suspended("%s", message)
+	case "Progressing":
+		return progressing("%s", message)
+	case "Degraded":
+		return degraded("%s", message)
+	}
+
+	return conditionHealth(obj)
+}
+
+// applicationHealth evaluates Argo CD Applications, which report the health
+// of the objects they manage
+func applicationHealth(obj unstructured.Unstructured) *Health {
+	status := nestedString(obj.Object, "status", "health", "status")
+	if _, ok := healthSeverity[status]; !ok {
+		return &Health{Status: HealthUnknown, Message: status}
+	}
+	return &Health{Status: status, Message: nestedString(obj.Object, "status", "health", "message")}
+}
+
+// conditionHealth evaluates objects by the conditions commonly used by
+// operators (e.g. cert-manager, Flux, Strimzi and Crossplane): suspended
+// objects (spec.suspend) are Suspended, a failed "Synced" condition is
+// Degraded, and otherwise the "Ready" condition determines the status. Objects
+// without a "Ready" condition have no health.
+func conditionHealth(obj unstructured.Unstructured) *Health {
+	if nestedBool(obj.Object, "spec", "suspend") {
+		return suspended("%s is suspended", obj.GetKind())
+	}
+
+	if cond, ok := findCondition(obj, "Synced"); ok && cond.Status == "False" {
+		return degraded("%s", cond.Message)
+	}
+
+	cond, ok := findCondition(obj, "Ready")
+	if !ok {
+		return nil
+	}
+
+	switch cond.Status {
+	case "True":
+		if !generationObserved(obj) && nestedInt(obj.Object, "status", "observedGeneration") > 0 {
+			return progressing("Waiting for spec update to be observed")
+		}
+		return &Health{Status: HealthHealthy, Message: cond.Message}
+	case "False":
+		return degraded("%s", cond.Message)
+	}
+
+	return progressing("%s", cond.Message)
+}
@@ -0,0 +1,260 @@
+package k8stree
+
+import (
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestHealthRollup(t *testing.T) {
+	trees, err := GetK8sTree(loadObjects(t, "testdata/health.json"))
+	assert.MustBeNil(t, err, "error must be nil")
+
+	roots := make(map[string]ObjectsTree)
+	for _, tree := range trees {
+		roots[tree.UID] = tree
+	}
+
+	tests := []struct {
+		uid        string
+		health     *Health
+		aggregated *Health
+	}{
+		{
+			uid: "dep-web",
+			health: &Health{
+				Status:  HealthProgressing,
+				Message: "Waiting for rollout to finish: 1 of 2 updated replicas are available...",
+			},
+			aggregated: &Health{
+				Status:  HealthDegraded,
+				Message: "Pod web-6d4b-fghij: back-off 5m0s restarting failed container=web pod=web-6d4b-fghij",
+			},
+		},
+		{
+			uid:        "cj-report",
+			health:     &Health{Status: HealthSuspended, Message: "CronJob is suspended"},
+			aggregated: &Health{Status: HealthDegraded, Message: "Job report-1: Job has reached the specified backoff limit"},
+		},
+		{
+			uid:        "svc-web",
+			health:     &Health{Status: HealthProgressing, Message: "Waiting for load balancer to be provisioned"},
+			aggregated: &Health{Status: HealthProgressing, Message: "Waiting for load balancer to be provisioned"},
+		},
+		{
+			uid:        "pvc-data",
+			health:     &Health{Status: HealthHealthy},
+			aggregated: &Health{Status: HealthHealthy},
+		},
+		{
+			uid:        "cert-web-tls",
+			health:     &Health{Status: HealthDegraded, Message: "Issuing certificate as Secret does not exist"},
+			aggregated: &Health{Status: HealthDegraded, Message: "Issuing certificate as Secret does not exist"},
+		},
+		{
+			uid: "cm-settings",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.uid, func(t *testing.T) {
+			root, ok := roots[test.uid]
+			assert.MustBeTrue(t, ok, "object must be a root")
+			assert.DeepEqual(t, test.health, root.Health, "health must match")
+			assert.DeepEqual(t, test.aggregated, root.AggregatedHealth, "aggregated health must match")
+		})
+	}
+}
+
+func TestObjectHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		object   map[string]interface{}
+		expected *Health
+	}{
+		{
+			name: "metadata only",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"name": "web"},
+			},
+			expected: nil,
+		},
+		{
+			name: "paused deployment",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"spec":       map[string]interface{}{"paused": true},
+			},
+			expected: &Health{Status: HealthSuspended, Message: "Deployment is paused"},
+		},
+		{
+			name: "deployment exceeding its progress deadline",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"name": "web", "generation": int64(3)},
+				"spec":       map[string]interface{}{"replicas": int64(1)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(3),
+					"conditions": []interface{}{
+						map[string]interface{}{
+							"type":   "Progressing",
+							"status": "False",
+							"reason": "ProgressDeadlineExceeded",
+						},
+					},
+				},
+			},
+			expected: &Health{Status: HealthDegraded, Message: `Deployment "web" exceeded its progress deadline`},
+		},
+		{
+			name: "partitioned statefulset",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "StatefulSet",
+				"spec": map[string]interface{}{
+					"replicas": int64(3),
+					"updateStrategy": map[string]interface{}{
+						"type":          "RollingUpdate",
+						"rollingUpdate": map[string]interface{}{"partition": int64(2)},
+					},
+				},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(1),
+					"readyReplicas":      int64(3),
+					"updatedReplicas":    int64(1),
+					"currentRevision":    "db-1",
+					"updateRevision":     "db-2",
+				},
+			},
+			expected: &Health{Status: HealthHealthy},
+		},
+		{
+			name: "failed pod",
+			object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"spec":       map[string]interface{}{"restartPolicy": "Never"},
+				"status": map[string]interface{}{
+					"phase": "Failed",
+					"containerStatuses": []interface{}{
+						map[string]interface{}{
+							"name":  "migrate",
+							"state": map[string]interface{}{"terminated": map[string]interface{}{"exitCode": int64(2)}},
+						},
+					},
+				},
+			},
+			expected: &Health{Status: HealthDegraded, Message: `container "migrate" failed with exit code 2`},
+		},
+		{
+			name: "pod pulling a missing image",
+			object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"spec":       map[string]interface{}{},
+				"status": map[string]interface{}{
+					"phase": "Pending",
+					"containerStatuses": []interface{}{
+						map[string]interface{}{
+							"name":  "web",
+							"state": map[string]interface{}{"waiting": map[string]interface{}{"reason": "ImagePullBackOff"}},
+						},
+					},
+				},
+			},
+			expected: &Health{Status: HealthDegraded, Message: "ImagePullBackOff"},
+		},
+		{
+			name: "failing autoscaler",
+			object: map[string]interface{}{
+				"apiVersion": "autoscaling/v2beta2",
+				"kind":       "HorizontalPodAutoscaler",
+				"spec":       map[string]interface{}{},
+				"status": map[string]interface{}{
+					"conditions": []interface{}{
+						map[string]interface{}{
+							"type":    "ScalingActive",
+							"status":  "False",
+							"reason":  "FailedGetResourceMetric",
+							"message": "unable to get metrics for resource cpu",
+						},
+					},
+				},
+			},
+			expected: &Health{Status: HealthDegraded, Message: "unable to get metrics for resource cpu"},
+		},
+		{
+			name: "paused argo rollout",
+			object: map[string]interface{}{
+				"apiVersion": "argoproj.io/v1alpha1",
+				"kind":       "Rollout",
+				"spec":       map[string]interface{}{},
+				"status": map[string]interface{}{
+					"pauseConditions": []interface{}{map[string]interface{}{"reason": "CanaryPauseStep"}},
+				},
+			},
+			expected: &Health{Status: HealthSuspended, Message: "Rollout is paused"},
+		},
+		{
+			name: "argo application with missing objects",
+			object: map[string]interface{}{
+				"apiVersion": "argoproj.io/v1alpha1",
+				"kind":       "Application",
+				"spec":       map[string]interface{}{},
+				"status": map[string]interface{}{
+					"health": map[string]interface{}{"status": "Missing"},
+				},
+			},
+			expected: &Health{Status: HealthMissing},
+		},
+		{
+			name: "suspended flux kustomization",
+			object: map[string]interface{}{
+				"apiVersion": "kustomize.toolkit.fluxcd.io/v1beta2",
+				"kind":       "Kustomization",
+				"spec":       map[string]interface{}{"suspend": true},
+			},
+			expected: &Health{Status: HealthSuspended, Message: "Kustomization is suspended"},
+		},
+		{
+			name: "crossplane resource failing to sync",
+			object: map[string]interface{}{
+				"apiVersion": "s3.aws.crossplane.io/v1beta1",
+				"kind":       "Bucket",
+				"spec":       map[string]interface{}{},
+				"status": map[string]interface{}{
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Ready", "status": "True"},
+						map[string]interface{}{"type": "Synced", "status": "False", "message": "access denied"},
+					},
+				},
+			},
+			expected: &Health{Status: HealthDegraded, Message: "access denied"},
+		},
+		{
+			name: "custom resource without conditions",
+			object: map[string]interface{}{
+				"apiVersion": "example.com/v1",
+				"kind":       "Widget",
+				"spec":       map[string]interface{}{},
+			},
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.DeepEqual(
+				t,
+				test.expected,
+				ObjectHealth(unstructured.Unstructured{Object: test.object}),
+				"health must match",
+			)
+		})
+	}
+}
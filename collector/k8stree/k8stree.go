@@ -1,12 +1,14 @@
 package k8stree
 
 import (
-	"fmt"
-	"github.com/infralight/k8s-collector/collector/k8s"
-	"github.com/thoas/go-funk"
+	"strings"
+
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"strings"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/infralight/k8s-collector/collector/k8s"
 )
 
 type ObjectsTree struct {
@@ -15,207 +17,343 @@ type ObjectsTree struct {
 	Kind     string                 `json:"kind"`
 	Name     string                 `json:"name,omitempty"`
 	Object   map[string]interface{} `json:"object"`
+
+	// Health is the health of the object itself, if its kind has a health
+	// check (see ObjectHealth)
+	Health *Health `json:"health,omitempty"`
+
+	// AggregatedHealth is the worst health of the object and all of its
+	// descendants, e.g. a Deployment is Degraded if a Pod of its ReplicaSet
+	// is crash-looping
+	AggregatedHealth *Health `json:"aggregatedHealth,omitempty"`
 }
 
+// GetK8sTree arranges the provided Kubernetes objects in trees according to
+// their owner references. Objects without owners are the roots of the trees.
+// Endpoints, PersistentVolumes and PersistentVolumeClaims without owners are
+// attached to their Service, PersistentVolumeClaim and StatefulSet,
+// respectively (see specialParents.ownerOf). Objects whose owners were not
+// collected become roots of their own trees after all other trees were built.
+// An object with several owners appears under each of them. The provided
+// objects are not modified.
+//
+// The health of every object is evaluated (see ObjectHealth), and the worst
+// health of its descendants is rolled up to it (see aggregateHealth).
+//
+// The trees are built in linear time using an index from every owner's UID to
+// the objects referencing it.
 func GetK8sTree(objects []interface{}) ([]ObjectsTree, error) {
-	var unstructuredObjects []unstructured.Unstructured
-	funk.ForEach(objects, func(obj interface{}) {
-		unstructuredObjects = append(unstructuredObjects, unstructured.Unstructured{
-			Object: obj.(k8s.KubernetesObject).Object.(map[string]interface{}),
-		})
-	})
-
-	sourceParents, remainingUnstructuredObjects := getSourceParents(unstructuredObjects)
+	unstructuredObjects := make([]unstructured.Unstructured, len(objects))
+	for i, obj := range objects {
+		unstructuredObjects[i] = unstructured.Unstructured{
+			Object: obj.(k8s.KubernetesObject).Object,
+		}
+	}
+
+	b := newTreeBuilder(unstructuredObjects)
+
 	var objectsTrees []ObjectsTree
-	var sourceParentTree ObjectsTree
-	var foundChildren []unstructured.Unstructured
-	for _, sourceParent := range sourceParents {
-		sourceParentTree, foundChildren = createTrees(sourceParent, remainingUnstructuredObjects)
-		remainingUnstructuredObjects = subtractUnstructuredObjects(remainingUnstructuredObjects, foundChildren)
-		objectsTrees = append(objectsTrees, sourceParentTree)
-	}
-
-	var parentTree ObjectsTree
-	for _, remainingUnstructuredObject := range remainingUnstructuredObjects {
-		remainingObj := ObjectsTree{
-			UID:    string(remainingUnstructuredObject.GetUID()),
-			Kind:   remainingUnstructuredObject.GetKind(),
-			Object: remainingUnstructuredObject.Object,
-			Name:   remainingUnstructuredObject.GetName(),
-		}
-		parentTree, foundChildren = createTrees(remainingObj, remainingUnstructuredObjects)
-		remainingUnstructuredObjects = subtractUnstructuredObjects(remainingUnstructuredObjects, foundChildren)
-		objectsTrees = append(objectsTrees, parentTree)
+	for _, i := range b.roots {
+		objectsTrees = append(objectsTrees, b.build(newObjectsTree(b.objects[i])))
 	}
+
+	// objects whose owners were not found are the roots of additional trees
+	var orphans []int
+	for _, i := range b.owned {
+		if !b.isAttached(i) {
+			orphans = append(orphans, i)
+		}
+	}
+	for _, i := range orphans {
+		objectsTrees = append(objectsTrees, b.build(newObjectsTree(b.objects[i])))
+	}
+
 	return objectsTrees, nil
 }
 
-func getSourceParents(objects []unstructured.Unstructured) (
-	[]ObjectsTree, []unstructured.Unstructured) {
-	specialParents := getSpecialParents(objects, []string{"Service", "StatefulSet", "PersistentVolumeClaim"})
-	sourceParents := make([]ObjectsTree, 0)
-	remainingChildren := make([]unstructured.Unstructured, 0)
+// treeBuilder indexes a list of Kubernetes objects by their owners
+type treeBuilder struct {
+	objects []unstructured.Unstructured
 
-	for _, obj := range objects {
-		objOwners := obj.GetOwnerReferences()
+	// indexes of objects without owners, in their original order
+	roots []int
+
+	// indexes of objects with owners, in their original order
+	owned []int
+
+	// UIDs of the owners of each object, without duplicates
+	owners map[int][]string
+
+	// indexes of the objects referencing each owner UID, in their original
+	// order
+	children map[string][]int
+
+	// links from objects to owners that were already added to the trees
+	attached map[ownerLink]bool
+}
+
+// ownerLink links the object at an index to the UID of one of its owners
+type ownerLink struct {
+	object int
+	owner  string
+}
+
+func newTreeBuilder(objects []unstructured.Unstructured) *treeBuilder {
+	b := &treeBuilder{
+		objects:  objects,
+		owners:   make(map[int][]string),
+		children: make(map[string][]int),
+		attached: make(map[ownerLink]bool),
+	}
 
-		if len(objOwners) == 0 {
-			isSpecialChildren, newObjOwners := specialChildren(obj, specialParents)
-			if isSpecialChildren {
-				obj.SetOwnerReferences(newObjOwners)
-				remainingChildren = append(remainingChildren, obj)
+	parents := newSpecialParents(objects)
+
+	for i, obj := range objects {
+		ownerReferences := obj.GetOwnerReferences()
+		if len(ownerReferences) == 0 {
+			ownerReferences = parents.ownerOf(obj)
+			if ownerReferences == nil {
+				b.roots = append(b.roots, i)
 				continue
 			}
+		}
+
+		b.owned = append(b.owned, i)
+		for j, ownerRef := range ownerReferences {
+			if !referencesOwner(ownerReferences[:j], string(ownerRef.UID)) {
+				b.owners[i] = append(b.owners[i], string(ownerRef.UID))
+				b.children[string(ownerRef.UID)] = append(b.children[string(ownerRef.UID)], i)
+			}
+		}
+	}
 
-			sourceParents = append(sourceParents, ObjectsTree{
-				UID:    string(obj.GetUID()),
-				Kind:   obj.GetKind(),
-				Object: obj.Object,
-				Name:   obj.GetName(),
-			})
+	return b
+}
+
+// build attaches to the provided tree all objects referencing it as an owner
+// that were not attached to it yet, and recursively builds their trees
+func (b *treeBuilder) build(objectsTree ObjectsTree) ObjectsTree {
+	for _, i := range b.children[objectsTree.UID] {
+		link := ownerLink{object: i, owner: objectsTree.UID}
+		if b.attached[link] {
 			continue
 		}
-		remainingChildren = append(remainingChildren, obj)
+		b.attached[link] = true
+
+		objectsTree.Children = append(objectsTree.Children, b.build(newObjectsTree(b.objects[i])))
 	}
-	return sourceParents, remainingChildren
+
+	aggregateHealth(&objectsTree)
+
+	return objectsTree
 }
 
-func specialChildren(obj unstructured.Unstructured, specialParents map[string]map[string]unstructured.Unstructured) (bool, []v1.OwnerReference) {
-	switch obj.GetKind() {
-	case "Endpoints":
-		service, ok := specialParents["Service"][fmt.Sprintf("%s/%s", obj.GetNamespace(), obj.GetName())]
-		if ok {
-			return true, []v1.OwnerReference{
-				{
-					APIVersion: service.GetAPIVersion(),
-					Kind:       service.GetKind(),
-					Name:       service.GetName(),
-					UID:        service.GetUID(),
-				},
-			}
+// isAttached checks whether the object at the provided index was attached to
+// all of its owners
+func (b *treeBuilder) isAttached(i int) bool {
+	for _, owner := range b.owners[i] {
+		if !b.attached[ownerLink{object: i, owner: owner}] {
+			return false
 		}
-	case "PersistentVolume":
-		persistentVolumeClaim, ok := specialParents["PersistentVolumeClaim"][fmt.Sprintf("%s", obj.GetName())]
-		if ok {
-			return true, []v1.OwnerReference{
-				{
-					APIVersion: persistentVolumeClaim.GetAPIVersion(),
-					Kind:       persistentVolumeClaim.GetKind(),
-					Name:       persistentVolumeClaim.GetName(),
-					UID:        persistentVolumeClaim.GetUID(),
-				},
-			}
+	}
+	return true
+}
+
+func newObjectsTree(obj unstructured.Unstructured) ObjectsTree {
+	return ObjectsTree{
+		UID:    string(obj.GetUID()),
+		Kind:   obj.GetKind(),
+		Object: obj.Object,
+		Name:   obj.GetName(),
+		Health: ObjectHealth(obj),
+	}
+}
+
+func referencesOwner(ownerReferences []v1.OwnerReference, uid string) bool {
+	for _, ownerRef := range ownerReferences {
+		if string(ownerRef.UID) == uid {
+			return true
 		}
-	case "PersistentVolumeClaim":
-		pvcSplitName := strings.Split(obj.GetName(), "-")
-		if len(pvcSplitName) == 1 {
-			break
+	}
+	return false
+}
+
+// specialParents indexes objects that are considered the owners of other
+// objects even though they are not referenced by them
+type specialParents struct {
+	// Services by namespace and name
+	services map[types.NamespacedName]unstructured.Unstructured
+
+	// PersistentVolumeClaims by UID, by namespace and name, and by the name
+	// of the PersistentVolume they are bound to
+	persistentVolumeClaims       map[types.UID]unstructured.Unstructured
+	persistentVolumeClaimsByName map[types.NamespacedName]unstructured.Unstructured
+	persistentVolumeClaimsByPV   map[string]unstructured.Unstructured
+
+	// StatefulSets by namespace and name
+	statefulSets map[types.NamespacedName]unstructured.Unstructured
+
+	// StatefulSets by the names of the PersistentVolumeClaims created from
+	// their volume claim templates without the ordinal suffix (i.e.
+	// "<template>-<statefulset>"), by namespace
+	claimTemplates map[types.NamespacedName][]unstructured.Unstructured
+}
+
+func newSpecialParents(objects []unstructured.Unstructured) *specialParents {
+	parents := &specialParents{
+		services:                     make(map[types.NamespacedName]unstructured.Unstructured),
+		persistentVolumeClaims:       make(map[types.UID]unstructured.Unstructured),
+		persistentVolumeClaimsByName: make(map[types.NamespacedName]unstructured.Unstructured),
+		persistentVolumeClaimsByPV:   make(map[string]unstructured.Unstructured),
+		statefulSets:                 make(map[types.NamespacedName]unstructured.Unstructured),
+		claimTemplates:               make(map[types.NamespacedName][]unstructured.Unstructured),
+	}
+
+	for _, obj := range objects {
+		key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+
+		switch obj.GetKind() {
+		case "Service":
+			parents.services[key] = obj
+		case "PersistentVolumeClaim":
+			parents.persistentVolumeClaims[obj.GetUID()] = obj
+			parents.persistentVolumeClaimsByName[key] = obj
+			if volumeName, _, _ := unstructured.NestedString(obj.Object, "spec", "volumeName"); volumeName != "" {
+				parents.persistentVolumeClaimsByPV[volumeName] = obj
+			}
+		case "StatefulSet":
+			// the last of several StatefulSets by the same name is used
+			parents.statefulSets[key] = obj
 		}
+	}
 
-		pvcNameWithoutIndex := strings.Join(pvcSplitName[:len(pvcSplitName)-1], "-")
-		statefulSet := funk.Find(specialParents["StatefulSet"], func(statefulSet unstructured.Unstructured) bool {
-			if obj.GetNamespace() == statefulSet.GetNamespace() &&
-				strings.HasSuffix(pvcNameWithoutIndex, statefulSet.GetName()) {
-				return true
+	for key, statefulSet := range parents.statefulSets {
+		templates, _, _ := unstructured.NestedSlice(statefulSet.Object, "spec", "volumeClaimTemplates")
+		for _, template := range templates {
+			template, ok := template.(map[string]interface{})
+			if !ok {
+				continue
 			}
-			return false
-		})
-
-		if statefulSet != nil {
-			statefulSetUnstructured := statefulSet.(unstructured.Unstructured)
-			return true, []v1.OwnerReference{
-				{
-					APIVersion: statefulSetUnstructured.GetAPIVersion(),
-					Kind:       statefulSetUnstructured.GetKind(),
-					Name:       statefulSetUnstructured.GetName(),
-					UID:        statefulSetUnstructured.GetUID(),
-				},
+
+			name, _, _ := unstructured.NestedString(template, "metadata", "name")
+			if name == "" {
+				continue
 			}
+
+			claimKey := types.NamespacedName{Namespace: key.Namespace, Name: name + "-" + key.Name}
+			parents.claimTemplates[claimKey] = append(parents.claimTemplates[claimKey], statefulSet)
 		}
 	}
 
-	return false, nil
+	return parents
 }
 
-func getSpecialParents(objects []unstructured.Unstructured, specialParentsKind []string) map[string]map[string]unstructured.Unstructured {
-	specialParents := make(map[string]map[string]unstructured.Unstructured)
-	funk.ForEach(objects, func(obj unstructured.Unstructured) {
-		if funk.ContainsString(specialParentsKind, obj.GetKind()) {
-			if specialParents[obj.GetKind()] == nil {
-				specialParents[obj.GetKind()] = make(map[string]unstructured.Unstructured)
-			}
-
-			switch obj.GetKind() {
-			case "PersistentVolumeClaim":
-				specialParents[obj.GetKind()][fmt.Sprintf("pvc-%s", obj.GetUID())] = obj
-			default:
-				specialParents[obj.GetKind()][fmt.Sprintf("%s/%s", obj.GetNamespace(), obj.GetName())] = obj
-			}
+// ownerOf returns owner references to the special parent of an object, or
+// nil if it has none:
+//
+//   - Endpoints are owned by the Service by the same name.
+//   - PersistentVolumes are owned by the PersistentVolumeClaim referenced by
+//     their claimRef, or by the PersistentVolumeClaim referencing them by its
+//     volumeName.
+//   - PersistentVolumeClaims are owned by the StatefulSet that created them
+//     from one of its volume claim templates, i.e. the PersistentVolumeClaim is
+//     named "<template>-<statefulset>-<ordinal>" and its labels match the
+//     StatefulSet's selector. Claims matching several StatefulSets are not
+//     attached to any of them.
+func (parents *specialParents) ownerOf(obj unstructured.Unstructured) []v1.OwnerReference {
+	switch obj.GetKind() {
+	case "Endpoints":
+		service, ok := parents.services[types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}]
+		if ok {
+			return ownerReferenceTo(service)
 		}
-	})
+	case "PersistentVolume":
+		if claim, ok := parents.claimOf(obj); ok {
+			return ownerReferenceTo(claim)
+		}
+	case "PersistentVolumeClaim":
+		if statefulSet, ok := parents.statefulSetOf(obj); ok {
+			return ownerReferenceTo(statefulSet)
+		}
+	}
 
-	return specialParents
+	return nil
 }
 
-func createTrees(objectsTree ObjectsTree, objects []unstructured.Unstructured) (
-	ObjectsTree, []unstructured.Unstructured) {
-	foundChildren := make([]unstructured.Unstructured, 0)
-	objChildren := make([]unstructured.Unstructured, 0)
-	var childTree ObjectsTree
+// claimOf returns the PersistentVolumeClaim a PersistentVolume is bound to.
+// A claimRef with a UID only matches the claim with that UID, as the claim
+// may have been deleted and recreated with the same name.
+func (parents *specialParents) claimOf(volume unstructured.Unstructured) (unstructured.Unstructured, bool) {
+	claimRef, found, _ := unstructured.NestedStringMap(volume.Object, "spec", "claimRef")
+	if found {
+		if uid := claimRef["uid"]; uid != "" {
+			claim, ok := parents.persistentVolumeClaims[types.UID(uid)]
+			return claim, ok
+		}
 
-	for _, obj := range objects {
-		ownerReference := obj.GetOwnerReferences()
-		for _, ownerRef := range ownerReference {
-			if string(ownerRef.UID) == objectsTree.UID {
-				ownerReference = subtractOwnerReferences(ownerReference, []v1.OwnerReference{ownerRef})
-				obj.SetOwnerReferences(ownerReference)
-
-				if len(ownerReference) == 0 {
-					foundChildren = append(foundChildren, obj)
-				}
-				remainingChildren := subtractUnstructuredObjects(objects, foundChildren)
-
-				childObj := ObjectsTree{
-					UID:    string(obj.GetUID()),
-					Kind:   obj.GetKind(),
-					Object: obj.Object,
-					Name:   obj.GetName(),
-				}
-				childTree, objChildren = createTrees(childObj, remainingChildren)
-				objectsTree.Children = append(objectsTree.Children, childTree)
-				foundChildren = append(foundChildren, objChildren...)
-				break
-			}
+		claim, ok := parents.persistentVolumeClaimsByName[types.NamespacedName{
+			Namespace: claimRef["namespace"],
+			Name:      claimRef["name"],
+		}]
+		if ok {
+			return claim, true
 		}
 	}
 
-	return objectsTree, foundChildren
+	claim, ok := parents.persistentVolumeClaimsByPV[volume.GetName()]
+	return claim, ok
 }
 
-func subtractUnstructuredObjects(a, b []unstructured.Unstructured) []unstructured.Unstructured {
-	mb := make(map[string]struct{}, len(b))
-	for _, x := range b {
-		mb[string(x.GetUID())] = struct{}{}
+// statefulSetOf returns the StatefulSet that created a PersistentVolumeClaim
+// from one of its volume claim templates
+func (parents *specialParents) statefulSetOf(claim unstructured.Unstructured) (unstructured.Unstructured, bool) {
+	name := claim.GetName()
+	i := strings.LastIndex(name, "-")
+	if i == -1 || !isOrdinal(name[i+1:]) {
+		return unstructured.Unstructured{}, false
 	}
-	var diff []unstructured.Unstructured
-	for _, x := range a {
-		if _, found := mb[string(x.GetUID())]; !found {
-			diff = append(diff, x)
+
+	var (
+		owner unstructured.Unstructured
+		found bool
+	)
+	claimLabels := labels.Set(claim.GetLabels())
+	for _, statefulSet := range parents.claimTemplates[types.NamespacedName{Namespace: claim.GetNamespace(), Name: name[:i]}] {
+		selector, _, ok := labelSelector(statefulSet, "spec", "selector")
+		if !ok || selector.Empty() || !selector.Matches(claimLabels) {
+			continue
+		}
+		if found {
+			// ambiguous
+			return unstructured.Unstructured{}, false
 		}
+		owner, found = statefulSet, true
 	}
-	return diff
+
+	return owner, found
 }
 
-func subtractOwnerReferences(a, b []v1.OwnerReference) []v1.OwnerReference {
-	mb := make(map[string]struct{}, len(b))
-	for _, x := range b {
-		mb[string(x.UID)] = struct{}{}
+// isOrdinal checks whether a string is a StatefulSet ordinal, i.e. a
+// non-negative integer without leading zeros
+func isOrdinal(s string) bool {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return false
 	}
-	var diff []v1.OwnerReference
-	for _, x := range a {
-		if _, found := mb[string(x.UID)]; !found {
-			diff = append(diff, x)
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
 		}
 	}
-	return diff
+	return true
+}
+
+func ownerReferenceTo(obj unstructured.Unstructured) []v1.OwnerReference {
+	return []v1.OwnerReference{
+		{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Name:       obj.GetName(),
+			UID:        obj.GetUID(),
+		},
+	}
 }
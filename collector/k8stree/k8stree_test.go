@@ -0,0 +1,583 @@
+package k8stree
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8s"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// loadObjects loads a list of Kubernetes objects from a JSON fixture
+func loadObjects(t testing.TB, path string) []interface{} {
+	data, err := ioutil.ReadFile(path)
+	assert.MustBeNil(t, err, "fixture must be readable")
+
+	var items []map[string]interface{}
+	assert.MustBeNil(t, json.Unmarshal(data, &items), "fixture must be valid JSON")
+
+	objects := make([]interface{}, len(items))
+	for i, item := range items {
+		objects[i] = k8s.KubernetesObject{Object: item}
+	}
+
+	return objects
+}
+
+func TestGetK8sTree(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*.json")
+	assert.MustBeNil(t, err, "fixtures must be listed")
+
+	for _, fixture := range fixtures {
+		if strings.HasSuffix(fixture, ".golden.json") {
+			continue
+		}
+
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			objects := loadObjects(t, fixture)
+			before, err := json.Marshal(objects)
+			assert.MustBeNil(t, err, "objects must be encoded")
+
+			trees, err := GetK8sTree(objects)
+			assert.MustBeNil(t, err, "error must be nil")
+
+			after, err := json.Marshal(objects)
+			assert.MustBeNil(t, err, "objects must be encoded")
+			assert.True(t, bytes.Equal(before, after), "objects must not be modified")
+
+			output, err := json.MarshalIndent(trees, "", "  ")
+			assert.MustBeNil(t, err, "trees must be encoded")
+			output = append(output, '\n')
+
+			golden := strings.TrimSuffix(fixture, ".json") + ".golden.json"
+			if *update {
+				assert.MustBeNil(t, ioutil.WriteFile(golden, output, 0644), "golden file must be written")
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			assert.MustBeNil(t, err, "golden file must be readable")
+			assert.True(t, bytes.Equal(expected, output), "trees must match golden file")
+		})
+	}
+}
+
+// syntheticCluster generates about n objects resembling a real cluster: every
+// application has a Deployment, ReplicaSet, three Pods, a Service with its
+// Endpoints, and a ConfigMap
+func syntheticCluster(n int) []interface{} {
+	newObject := func(apiVersion, kind, namespace, name string, owner map[string]interface{}) map[string]interface{} {
+		metadata := map[string]interface{}{
+			"namespace": namespace,
+			"name":      name,
+			"uid":       namespace + "/" + kind + "/" + name,
+		}
+		if owner != nil {
+			ownerMetadata := owner["metadata"].(map[string]interface{})
+			metadata["ownerReferences"] = []interface{}{
+				map[string]interface{}{
+					"apiVersion": owner["apiVersion"],
+					"kind":       owner["kind"],
+					"name":       ownerMetadata["name"],
+					"uid":        ownerMetadata["uid"],
+					"controller": true,
+				},
+			}
+		}
+		return map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata":   metadata,
+		}
+	}
+
+	objects := make([]interface{}, 0, n)
+	for app := 0; len(objects) < n; app++ {
+		namespace := fmt.Sprintf("ns-%d", app%50)
+		name := fmt.Sprintf("app-%d", app)
+
+		deployment := newObject("apps/v1", "Deployment", namespace, name, nil)
+		replicaSet := newObject("apps/v1", "ReplicaSet", namespace, name+"-5d8f", deployment)
+		items := []map[string]interface{}{
+			newObject("v1", "Pod", namespace, name+"-5d8f-a", replicaSet),
+			newObject("v1", "Pod", namespace, name+"-5d8f-b", replicaSet),
+			newObject("v1", "Pod", namespace, name+"-5d8f-c", replicaSet),
+			replicaSet,
+			deployment,
+			newObject("v1", "Service", namespace, name, nil),
+			newObject("v1", "Endpoints", namespace, name, nil),
+			newObject("v1", "ConfigMap", namespace, name, nil),
+		}
+		for _, item := range items {
+			objects = append(objects, k8s.KubernetesObject{Object: item})
+		}
+	}
+
+	return objects
+}
+
+func benchmarkGetK8sTree(b *testing.B, n int) {
+	for i := 0; i < b.N; i++ {
+		// objects are modified while building trees, so a fresh cluster is
+		// needed for every iteration
+		b.StopTimer()
+		objects := syntheticCluster(n)
+		b.StartTimer()
+
+		_, err := GetK8sTree(objects)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetK8sTree10k(b *testing.B)  { benchmarkGetK8sTree(b, 10000) }
+func BenchmarkGetK8sTree100k(b *testing.B) { benchmarkGetK8sTree(b, 100000) }
+
+func TestGetK8sGraph(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*.json")
+	assert.MustBeNil(t, err, "fixtures must be listed")
+
+	rules := LoadRelationshipRules(&config.Config{RelationshipRulePacks: config.DefaultRelationshipRulePacks})
+
+	for _, fixture := range fixtures {
+		if strings.HasSuffix(fixture, ".golden.json") {
+			continue
+		}
+
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			objects := loadObjects(t, fixture)
+			before, err := json.Marshal(objects)
+			assert.MustBeNil(t, err, "objects must be encoded")
+
+			graph, err := GetK8sGraph(objects, rules)
+			assert.MustBeNil(t, err, "error must be nil")
+
+			after, err := json.Marshal(objects)
+			assert.MustBeNil(t, err, "objects must be encoded")
+			assert.True(t, bytes.Equal(before, after), "objects must not be modified")
+
+			output, err := json.MarshalIndent(graph, "", "  ")
+			assert.MustBeNil(t, err, "graph must be encoded")
+			output = append(output, '\n')
+
+			golden := strings.TrimSuffix(fixture, ".json") + ".graph.golden.json"
+			if *update {
+				assert.MustBeNil(t, ioutil.WriteFile(golden, output, 0644), "golden file must be written")
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			assert.MustBeNil(t, err, "golden file must be readable")
+			assert.True(t, bytes.Equal(expected, output), "graph must match golden file")
+		})
+	}
+}
+
+func TestSelectorRelations(t *testing.T) {
+	graph, err := GetK8sGraph(loadObjects(t, "testdata/selectors.json"), nil)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	edges := make(map[string]bool)
+	for _, edge := range graph.Edges {
+		if edge.Type == EdgeSelects || edge.From == "svc-api" {
+			edges[edge.Type+" "+edge.From+" "+edge.To] = true
+		}
+	}
+
+	assert.DeepEqual(t, map[string]bool{
+		"selects svc-api pod-api-a":     true,
+		"selects svc-api pod-api-b":     true,
+		"selects svc-api dep-api":       true,
+		"owns svc-api eps-api":          true,
+		"selects np-backend pod-api-a":  true,
+		"selects np-backend pod-api-b":  true,
+		"selects np-deny-all pod-api-a": true,
+		"selects np-deny-all pod-api-b": true,
+		"selects np-deny-all pod-web":   true,
+		"selects pdb-api pod-api-a":     true,
+		"selects pdb-api pod-api-b":     true,
+		"selects hpa-api dep-api":       true,
+	}, edges, "edges must match")
+}
+
+func TestRoutingRelations(t *testing.T) {
+	graph, err := GetK8sGraph(loadObjects(t, "testdata/routing.json"), nil)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	edges := make(map[string]bool)
+	for _, edge := range graph.Edges {
+		if edge.Type != EdgeRoutes && edge.Type != EdgeAttaches && edge.Type != EdgeImplements {
+			continue
+		}
+		edges[fmt.Sprintf("%s %s %s %t", edge.Type, edge.From, edge.To, edge.Inferred)] = true
+	}
+
+	assert.DeepEqual(t, map[string]bool{
+		"routes ing-main svc-frontend false":         true,
+		"routes ing-main svc-api false":              true,
+		"routes ing-main bucket-static false":        true,
+		"attaches ing-main ic-nginx false":           true,
+		"routes ing-legacy svc-frontend false":       true,
+		"attaches ing-legacy ic-nginx true":          true,
+		"implements dep-ingress-nginx ic-nginx true": true,
+		"implements dep-envoy-gateway gc-envoy true": true,
+		"attaches gw-shared gc-envoy false":          true,
+		"attaches route-web gw-shared false":         true,
+		"routes route-web svc-api false":             true,
+		"routes route-web svc-billing false":         true,
+		"attaches route-internal gw-shared false":    true,
+	}, edges, "edges must match")
+}
+
+func TestPodDependencyRelations(t *testing.T) {
+	graph, err := GetK8sGraph(loadObjects(t, "testdata/dependencies.json"), nil)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	edges := make(map[string]bool)
+	for _, edge := range graph.Edges {
+		if edge.Type != EdgeOwns {
+			edges[edge.Type+" "+edge.From+" "+edge.To] = true
+		}
+	}
+
+	assert.DeepEqual(t, map[string]bool{
+		"mounts dep-web cm-web-config":                true,
+		"mounts dep-web /Secret/app/web-tls":          true,
+		"mounts dep-web pvc-data":                     true,
+		"mounts dep-web /ConfigMap/app/shared":        true,
+		"mounts dep-web /Secret/app/creds":            true,
+		"mounts dep-web /ConfigMap/app/init-config":   true,
+		"mounts dep-web /ConfigMap/app/env-config":    true,
+		"mounts dep-web /Secret/app/db":               true,
+		"mounts dep-web /Secret/app/api-key":          true,
+		"uses dep-web /Secret/app/regcred":            true,
+		"uses dep-web sa-web":                         true,
+		"runsOn pod-web node-1":                       true,
+		"mounts cj-backup /Secret/app/backup-creds":   true,
+		"uses cj-backup /ServiceAccount/app/default":  true,
+		"mounts pod-debug cm-web-config":              true,
+		"uses pod-debug /ServiceAccount/app/debugger": true,
+		"runsOn pod-debug /Node//node-2":              true,
+		"mounts sts-db cm-web-config":                 true,
+		"uses sts-db /ServiceAccount/app/db":          true,
+	}, edges, "edges must match")
+
+	missing := 0
+	for _, node := range graph.Nodes {
+		if node.Missing {
+			missing++
+		}
+	}
+	assert.Equal(t, 13, missing, "missing dependencies must be added as nodes")
+}
+
+func TestRBACRelations(t *testing.T) {
+	graph, err := GetK8sGraph(loadObjects(t, "testdata/rbac.json"), nil)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	edges := make(map[string]bool)
+	for _, edge := range graph.Edges {
+		edges[edge.Type+" "+edge.From+" "+edge.To] = true
+	}
+
+	assert.DeepEqual(t, map[string]bool{
+		"binds rb-deployer role-deploy":                                                            true,
+		"grants rb-deployer sa-deployer":                                                           true,
+		"grants rb-deployer /ServiceAccount/build/builder":                                         true,
+		"grants rb-deployer rbac.authorization.k8s.io/User//jane@example.com":                      true,
+		"aggregates cr-monitoring cr-monitoring-pods":                                              true,
+		"aggregates cr-monitoring cr-monitoring-nodes":                                             true,
+		"binds crb-monitoring cr-monitoring":                                                       true,
+		"grants crb-monitoring rbac.authorization.k8s.io/Group//system:serviceaccounts:monitoring": true,
+		"binds crb-legacy-admin rbac.authorization.k8s.io/ClusterRole//cluster-admin":              true,
+		"grants crb-legacy-admin sa-deployer":                                                      true,
+	}, edges, "edges must match")
+
+	kinds := make(map[string]string)
+	for _, node := range graph.Nodes {
+		switch {
+		case node.Missing:
+			kinds[node.Kind+" "+node.Name] = "missing"
+		case node.External:
+			kinds[node.Kind+" "+node.Name] = "external"
+		}
+	}
+
+	assert.DeepEqual(t, map[string]string{
+		"ServiceAccount builder":                  "missing",
+		"ClusterRole cluster-admin":               "missing",
+		"User jane@example.com":                   "external",
+		"Group system:serviceaccounts:monitoring": "external",
+	}, kinds, "missing and external nodes must match")
+}
+
+func TestSpecialParents(t *testing.T) {
+	object := func(kind, namespace, name string, labels map[string]interface{}, spec map[string]interface{}) unstructured.Unstructured {
+		metadata := map[string]interface{}{"name": name, "uid": kind + "/" + name}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		if labels != nil {
+			metadata["labels"] = labels
+		}
+		return unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       kind,
+			"metadata":   metadata,
+			"spec":       spec,
+		}}
+	}
+	statefulSet := func(name, app string, templates ...string) unstructured.Unstructured {
+		claimTemplates := make([]interface{}, len(templates))
+		for i, template := range templates {
+			claimTemplates[i] = map[string]interface{}{"metadata": map[string]interface{}{"name": template}}
+		}
+		return object("StatefulSet", "default", name, nil, map[string]interface{}{
+			"selector":             map[string]interface{}{"matchLabels": map[string]interface{}{"app": app}},
+			"volumeClaimTemplates": claimTemplates,
+		})
+	}
+	claim := func(name, app, volumeName string) unstructured.Unstructured {
+		var labels map[string]interface{}
+		if app != "" {
+			labels = map[string]interface{}{"app": app}
+		}
+		return object("PersistentVolumeClaim", "default", name, labels, map[string]interface{}{"volumeName": volumeName})
+	}
+	volume := func(name string, claimRef map[string]interface{}) unstructured.Unstructured {
+		spec := map[string]interface{}{}
+		if claimRef != nil {
+			spec["claimRef"] = claimRef
+		}
+		return object("PersistentVolume", "", name, nil, spec)
+	}
+
+	tests := []struct {
+		name     string
+		objects  []unstructured.Unstructured
+		expected string
+	}{
+		{
+			name: "claim from template",
+			objects: []unstructured.Unstructured{
+				statefulSet("web", "web", "data"),
+				claim("data-web-0", "web", ""),
+			},
+			expected: "StatefulSet/web",
+		},
+		{
+			name: "name suffix of another StatefulSet",
+			objects: []unstructured.Unstructured{
+				statefulSet("web", "web", "data"),
+				statefulSet("b-web", "b-web", "data"),
+				claim("data-web-0", "web", ""),
+			},
+			expected: "StatefulSet/web",
+		},
+		{
+			name: "claim of StatefulSet with dashed template",
+			objects: []unstructured.Unstructured{
+				statefulSet("web", "web", "data"),
+				statefulSet("b-web", "b-web", "data"),
+				claim("data-b-web-0", "b-web", ""),
+			},
+			expected: "StatefulSet/b-web",
+		},
+		{
+			name: "template and StatefulSet names split differently",
+			objects: []unstructured.Unstructured{
+				statefulSet("web-a", "web-a", "data"),
+				statefulSet("a", "a", "data-web"),
+				claim("data-web-a-1", "a", ""),
+			},
+			expected: "StatefulSet/a",
+		},
+		{
+			name: "ambiguous labels",
+			objects: []unstructured.Unstructured{
+				statefulSet("web-a", "shared", "data"),
+				statefulSet("a", "shared", "data-web"),
+				claim("data-web-a-1", "shared", ""),
+			},
+			expected: "",
+		},
+		{
+			name: "labels not matching selector",
+			objects: []unstructured.Unstructured{
+				statefulSet("web", "web", "data"),
+				claim("data-web-0", "other", ""),
+			},
+			expected: "",
+		},
+		{
+			name: "unknown template",
+			objects: []unstructured.Unstructured{
+				statefulSet("web", "web", "data"),
+				claim("logs-web-0", "web", ""),
+			},
+			expected: "",
+		},
+		{
+			name: "not an ordinal",
+			objects: []unstructured.Unstructured{
+				statefulSet("web", "web", "data"),
+				claim("data-web-backup", "web", ""),
+			},
+			expected: "",
+		},
+		{
+			name: "volume bound by claimRef",
+			objects: []unstructured.Unstructured{
+				claim("data", "", ""),
+				volume("pv-1", map[string]interface{}{
+					"namespace": "default",
+					"name":      "data",
+					"uid":       "PersistentVolumeClaim/data",
+				}),
+			},
+			expected: "PersistentVolumeClaim/data",
+		},
+		{
+			name: "volume bound by claimRef without UID",
+			objects: []unstructured.Unstructured{
+				claim("data", "", ""),
+				volume("pv-1", map[string]interface{}{"namespace": "default", "name": "data"}),
+			},
+			expected: "PersistentVolumeClaim/data",
+		},
+		{
+			name: "volume released by recreated claim",
+			objects: []unstructured.Unstructured{
+				claim("data", "", ""),
+				volume("pv-1", map[string]interface{}{
+					"namespace": "default",
+					"name":      "data",
+					"uid":       "deleted",
+				}),
+			},
+			expected: "",
+		},
+		{
+			name: "volume bound by volumeName",
+			objects: []unstructured.Unstructured{
+				claim("data", "", "pv-1"),
+				volume("pv-1", nil),
+			},
+			expected: "PersistentVolumeClaim/data",
+		},
+		{
+			name: "volume named after claim UID",
+			objects: []unstructured.Unstructured{
+				claim("data", "", ""),
+				volume("pvc-PersistentVolumeClaim/data", nil),
+			},
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parents := newSpecialParents(test.objects)
+			ownerReferences := parents.ownerOf(test.objects[len(test.objects)-1])
+
+			var owner string
+			if len(ownerReferences) > 0 {
+				owner = string(ownerReferences[0].UID)
+			}
+			assert.Equal(t, test.expected, owner, "owner must match")
+		})
+	}
+}
+
+func TestRelationshipRules(t *testing.T) {
+	rules, err := ParseRelationshipRules([]byte(`
+rules:
+  - name: Backup selects volume claims
+    source:
+      group: example.com
+      kind: Backup
+    target:
+      group: ""
+      kind: PersistentVolumeClaim
+    type: selects
+    selector: .spec.selector
+`))
+	assert.MustBeNil(t, err, "rules must be parsed")
+
+	graph, err := GetK8sGraph(loadObjects(t, "testdata/operators.json"), rules)
+	assert.MustBeNil(t, err, "error must be nil")
+
+	edges := make(map[string]bool)
+	for _, edge := range graph.Edges {
+		edges[edge.Type+" "+edge.From+" "+edge.To] = true
+	}
+
+	assert.DeepEqual(t, map[string]bool{
+		"selects backup-nightly pvc-web-data": true,
+	}, edges, "edges must match")
+}
+
+func TestParseRelationshipRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		valid bool
+	}{
+		{
+			name:  "reference",
+			rule:  "{source: {group: example.com, kind: Widget}, target: {group: '', kind: Secret}, type: uses, reference: .spec.secretName}",
+			valid: true,
+		},
+		{
+			name:  "reference with braces",
+			rule:  "{source: {group: '*', kind: '*'}, target: {group: '*'}, type: owns, reference: '{.spec.refs[*]}'}",
+			valid: true,
+		},
+		{
+			name: "missing source kind",
+			rule: "{source: {group: example.com}, target: {kind: Secret}, type: uses, reference: .spec.secretName}",
+		},
+		{
+			name: "missing type",
+			rule: "{source: {group: example.com, kind: Widget}, target: {kind: Secret}, reference: .spec.secretName}",
+		},
+		{
+			name: "both reference and selector",
+			rule: "{source: {kind: Widget}, target: {kind: Pod}, type: selects, reference: .spec.name, selector: .spec.selector}",
+		},
+		{
+			name: "selector without target kind",
+			rule: "{source: {kind: Widget}, target: {group: '*'}, type: selects, selector: .spec.selector}",
+		},
+		{
+			name: "invalid expression",
+			rule: "{source: {kind: Widget}, target: {kind: Secret}, type: uses, reference: '.spec[name'}",
+		},
+		{
+			name: "unknown field",
+			rule: "{source: {kind: Widget}, target: {kind: Secret}, type: uses, reference: .spec.name, reverse: true}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseRelationshipRules([]byte("rules: [" + test.rule + "]"))
+			assert.Equal(t, test.valid, err == nil, "validity must match")
+		})
+	}
+
+	for _, pack := range RulePacks() {
+		_, err := loadRulePack(pack)
+		assert.MustBeNil(t, err, "built-in rule pack "+pack+" must be valid")
+	}
+}
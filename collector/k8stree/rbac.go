@@ -0,0 +1,111 @@
+package k8stree
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// rbacGroup is the API group of RBAC objects
+const rbacGroup = "rbac.authorization.k8s.io"
+
+// bindingRelations returns edges from a RoleBinding or ClusterRoleBinding to
+// the Role or ClusterRole it references, and to each of its subjects.
+// ServiceAccounts that were not collected are added to the graph as missing
+// nodes, and users and groups as external nodes.
+func bindingRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	kind := obj.GetKind()
+	if (kind != "RoleBinding" && kind != "ClusterRoleBinding") || obj.GroupVersionKind().Group != rbacGroup {
+		return nil
+	}
+
+	var edges []Edge
+
+	roleKind := nestedString(obj.Object, "roleRef", "kind")
+	roleName := nestedString(obj.Object, "roleRef", "name")
+	roleNamespace := ""
+	if roleKind == "Role" {
+		roleNamespace = obj.GetNamespace()
+	}
+	if roleName != "" {
+		edges = append(edges, Edge{
+			Type: EdgeBinds,
+			From: string(obj.GetUID()),
+			To:   idx.reference(rbacGroup, roleKind, roleNamespace, roleName),
+		})
+	}
+
+	subjects, _, _ := unstructured.NestedSlice(obj.Object, "subjects")
+	for _, subject := range subjects {
+		subject, ok := subject.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name := nestedString(subject, "name")
+		if name == "" {
+			continue
+		}
+
+		var uid string
+		switch subjectKind := nestedString(subject, "kind"); subjectKind {
+		case "ServiceAccount":
+			namespace := nestedString(subject, "namespace")
+			if namespace == "" {
+				namespace = obj.GetNamespace()
+			}
+			uid = idx.reference("", "ServiceAccount", namespace, name)
+		case "User", "Group":
+			uid = idx.addExtraNode(Node{
+				UID:        missingUID(rbacGroup, subjectKind, "", name),
+				APIVersion: rbacGroup + "/v1",
+				Kind:       subjectKind,
+				Name:       name,
+				External:   true,
+			})
+		default:
+			continue
+		}
+
+		edges = append(edges, Edge{
+			Type: EdgeGrants,
+			From: string(obj.GetUID()),
+			To:   uid,
+		})
+	}
+
+	return edges
+}
+
+// aggregationRelations returns edges from an aggregated ClusterRole to the
+// ClusterRoles matching its aggregation rule
+func aggregationRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if obj.GetKind() != "ClusterRole" || obj.GroupVersionKind().Group != rbacGroup {
+		return nil
+	}
+
+	selectors, _, _ := unstructured.NestedSlice(obj.Object, "aggregationRule", "clusterRoleSelectors")
+	var edges []Edge
+	for _, value := range selectors {
+		value, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		selector, required, found := labelSelector(unstructured.Unstructured{Object: value})
+		if !found || selector.Empty() {
+			continue
+		}
+
+		for _, pos := range idx.clusterRoles.match(selector, required) {
+			if pos == idx.byUID[string(obj.GetUID())] {
+				continue
+			}
+			edges = append(edges, Edge{
+				Type: EdgeAggregates,
+				From: string(obj.GetUID()),
+				To:   string(idx.objects[pos].GetUID()),
+			})
+		}
+	}
+
+	return edges
+}
@@ -0,0 +1,395 @@
+package k8stree
+
+import (
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// gatewayGroup is the API group of the Gateway API
+const gatewayGroup = "gateway.networking.k8s.io"
+
+// Annotations used to select IngressClasses
+const (
+	ingressClassAnnotation        = "kubernetes.io/ingress.class"
+	defaultIngressClassAnnotation = "ingressclass.kubernetes.io/is-default-class"
+)
+
+// routeKinds are the Gateway API route kinds
+var routeKinds = map[string]bool{
+	"HTTPRoute": true,
+	"GRPCRoute": true,
+	"TLSRoute":  true,
+	"TCPRoute":  true,
+	"UDPRoute":  true,
+}
+
+// ingressRelations returns edges from an Ingress to the Services (or other
+// resources) its rules and default backend route to, and to its IngressClass
+func ingressRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if obj.GetKind() != "Ingress" {
+		return nil
+	}
+
+	var backends []map[string]interface{}
+	if backend, ok, _ := unstructured.NestedMap(obj.Object, "spec", "defaultBackend"); ok {
+		backends = append(backends, backend)
+	}
+	if backend, ok, _ := unstructured.NestedMap(obj.Object, "spec", "backend"); ok {
+		backends = append(backends, backend)
+	}
+
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	for _, rule := range rules {
+		rule, ok := rule.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		paths, _, _ := unstructured.NestedSlice(rule, "http", "paths")
+		for _, path := range paths {
+			path, ok := path.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if backend, ok, _ := unstructured.NestedMap(path, "backend"); ok {
+				backends = append(backends, backend)
+			}
+		}
+	}
+
+	var edges []Edge
+	for _, backend := range backends {
+		if target, ok := idx.ingressBackend(obj.GetNamespace(), backend); ok {
+			edges = append(edges, Edge{
+				Type: EdgeRoutes,
+				From: string(obj.GetUID()),
+				To:   string(target.GetUID()),
+			})
+		}
+	}
+
+	if class, inferred, ok := idx.ingressClass(obj); ok {
+		edges = append(edges, Edge{
+			Type:     EdgeAttaches,
+			From:     string(obj.GetUID()),
+			To:       string(class.GetUID()),
+			Inferred: inferred,
+		})
+	}
+
+	return edges
+}
+
+// ingressBackend finds the target of an Ingress backend, in either the
+// networking.k8s.io/v1 format (service.name or resource) or the v1beta1
+// format (serviceName)
+func (idx *objectIndex) ingressBackend(namespace string, backend map[string]interface{}) (
+	unstructured.Unstructured,
+	bool,
+) {
+	if name, ok, _ := unstructured.NestedString(backend, "service", "name"); ok {
+		return idx.lookup(namespace, "v1", "Service", name)
+	}
+	if name, ok, _ := unstructured.NestedString(backend, "serviceName"); ok {
+		return idx.lookup(namespace, "v1", "Service", name)
+	}
+	if resource, ok, _ := unstructured.NestedStringMap(backend, "resource"); ok {
+		return idx.lookupGroup(namespace, resource["apiGroup"], resource["kind"], resource["name"])
+	}
+
+	return unstructured.Unstructured{}, false
+}
+
+// ingressClass finds the IngressClass of an Ingress, either by its
+// ingressClassName, by the legacy "kubernetes.io/ingress.class" annotation, or
+// if neither is set, the default IngressClass of the cluster (in which case
+// the relationship is inferred)
+func (idx *objectIndex) ingressClass(obj unstructured.Unstructured) (
+	class unstructured.Unstructured,
+	inferred bool,
+	found bool,
+) {
+	name, ok, _ := unstructured.NestedString(obj.Object, "spec", "ingressClassName")
+	if !ok {
+		name, ok = obj.GetAnnotations()[ingressClassAnnotation]
+	}
+	if ok {
+		class, found = idx.lookupGroup("", "networking.k8s.io", "IngressClass", name)
+		return class, false, found
+	}
+
+	for _, pos := range idx.ingressClasses {
+		if idx.objects[pos].GetAnnotations()[defaultIngressClassAnnotation] == "true" {
+			return idx.objects[pos], true, true
+		}
+	}
+
+	return unstructured.Unstructured{}, false, false
+}
+
+// classRelations returns inferred edges to an IngressClass or GatewayClass
+// from the workloads implementing its controller (see
+// objectIndex.controllers)
+func classRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	var controller string
+	switch {
+	case obj.GetKind() == "IngressClass":
+		controller, _, _ = unstructured.NestedString(obj.Object, "spec", "controller")
+	case obj.GetKind() == "GatewayClass" && obj.GroupVersionKind().Group == gatewayGroup:
+		controller, _, _ = unstructured.NestedString(obj.Object, "spec", "controllerName")
+	default:
+		return nil
+	}
+
+	var edges []Edge
+	for _, pos := range idx.controllers(controller) {
+		edges = append(edges, Edge{
+			Type:     EdgeImplements,
+			From:     string(idx.objects[pos].GetUID()),
+			To:       string(obj.GetUID()),
+			Inferred: true,
+		})
+	}
+	return edges
+}
+
+// controllers finds the workloads implementing an ingress or gateway
+// controller, by either referencing the controller's name in the arguments of
+// one of their containers (e.g. "--controller-class=k8s.io/ingress-nginx"), or
+// by having an "app.kubernetes.io/name" label matching its last path segment
+// (e.g. "ingress-nginx")
+func (idx *objectIndex) controllers(controller string) []int {
+	if controller == "" {
+		return nil
+	}
+
+	appName := controller[strings.LastIndex(controller, "/")+1:]
+
+	var matches []int
+	for _, templates := range idx.templates {
+		for i, pos := range templates.objects {
+			if templates.labels[i]["app.kubernetes.io/name"] == appName ||
+				containerArgsContain(idx.objects[pos], controller) {
+				matches = append(matches, pos)
+			}
+		}
+	}
+
+	// templates are indexed per namespace, so sort matches back into their
+	// original order
+	sort.Ints(matches)
+	return matches
+}
+
+func containerArgsContain(obj unstructured.Unstructured, value string) bool {
+	containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+	for _, container := range containers {
+		container, ok := container.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		args, _, _ := unstructured.NestedStringSlice(container, "args")
+		command, _, _ := unstructured.NestedStringSlice(container, "command")
+		for _, arg := range append(command, args...) {
+			if strings.Contains(arg, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gatewayRelations returns an edge from a Gateway to its GatewayClass
+func gatewayRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if obj.GetKind() != "Gateway" || obj.GroupVersionKind().Group != gatewayGroup {
+		return nil
+	}
+
+	name, _, _ := unstructured.NestedString(obj.Object, "spec", "gatewayClassName")
+	class, ok := idx.lookupGroup("", gatewayGroup, "GatewayClass", name)
+	if !ok {
+		return nil
+	}
+
+	return []Edge{{
+		Type: EdgeAttaches,
+		From: string(obj.GetUID()),
+		To:   string(class.GetUID()),
+	}}
+}
+
+// routeRelations returns edges from a Gateway API route to the parents it is
+// attached to (usually Gateways) and to its backends (usually Services).
+// Attachment to a Gateway in another namespace must be allowed by one of the
+// Gateway's listeners, and backends in other namespaces must be allowed by a
+// ReferenceGrant in the backend's namespace.
+func routeRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if !routeKinds[obj.GetKind()] || obj.GroupVersionKind().Group != gatewayGroup {
+		return nil
+	}
+
+	var edges []Edge
+
+	parentRefs, _, _ := unstructured.NestedSlice(obj.Object, "spec", "parentRefs")
+	for _, ref := range parentRefs {
+		ref, ok := ref.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		group, kind, namespace, name := gatewayReference(ref, obj.GetNamespace(), gatewayGroup, "Gateway")
+		parent, ok := idx.lookupGroup(namespace, group, kind, name)
+		if !ok {
+			continue
+		}
+
+		if group == gatewayGroup && kind == "Gateway" {
+			sectionName, _, _ := unstructured.NestedString(ref, "sectionName")
+			if !idx.gatewayAllowsRoute(parent, sectionName, obj.GetNamespace()) {
+				continue
+			}
+		}
+
+		edges = append(edges, Edge{
+			Type: EdgeAttaches,
+			From: string(obj.GetUID()),
+			To:   string(parent.GetUID()),
+		})
+	}
+
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	for _, rule := range rules {
+		rule, ok := rule.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		backendRefs, _, _ := unstructured.NestedSlice(rule, "backendRefs")
+		for _, ref := range backendRefs {
+			ref, ok := ref.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			group, kind, namespace, name := gatewayReference(ref, obj.GetNamespace(), "", "Service")
+			if namespace != obj.GetNamespace() &&
+				!idx.referenceGranted(obj, namespace, group, kind, name) {
+				continue
+			}
+
+			backend, ok := idx.lookupGroup(namespace, group, kind, name)
+			if !ok {
+				continue
+			}
+
+			edges = append(edges, Edge{
+				Type: EdgeRoutes,
+				From: string(obj.GetUID()),
+				To:   string(backend.GetUID()),
+			})
+		}
+	}
+
+	return edges
+}
+
+// gatewayReference parses a Gateway API object reference, applying defaults
+// for its group, kind and namespace
+func gatewayReference(ref map[string]interface{}, defaultNamespace, defaultGroup, defaultKind string) (
+	group, kind, namespace, name string,
+) {
+	group, ok, _ := unstructured.NestedString(ref, "group")
+	if !ok {
+		group = defaultGroup
+	}
+	kind, ok, _ = unstructured.NestedString(ref, "kind")
+	if !ok {
+		kind = defaultKind
+	}
+	namespace, ok, _ = unstructured.NestedString(ref, "namespace")
+	if !ok {
+		namespace = defaultNamespace
+	}
+	name, _, _ = unstructured.NestedString(ref, "name")
+
+	return group, kind, namespace, name
+}
+
+// gatewayAllowsRoute checks whether any listener of a Gateway (or only the
+// listener named sectionName, if set) allows routes from the provided
+// namespace
+func (idx *objectIndex) gatewayAllowsRoute(gateway unstructured.Unstructured, sectionName, namespace string) bool {
+	listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	for _, listener := range listeners {
+		listener, ok := listener.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if name, _, _ := unstructured.NestedString(listener, "name"); sectionName != "" && name != sectionName {
+			continue
+		}
+
+		from, _, _ := unstructured.NestedString(listener, "allowedRoutes", "namespaces", "from")
+		switch from {
+		case "All":
+			return true
+		case "Selector":
+			selector, _, found := labelSelector(
+				unstructured.Unstructured{Object: listener},
+				"allowedRoutes", "namespaces", "selector",
+			)
+			ns, ok := idx.lookupGroup("", "", "Namespace", namespace)
+			if found && ok && selector.Matches(labels.Set(ns.GetLabels())) {
+				return true
+			}
+		default:
+			if namespace == gateway.GetNamespace() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// referenceGranted checks whether a ReferenceGrant in the target namespace
+// allows the provided object to reference the target
+func (idx *objectIndex) referenceGranted(from unstructured.Unstructured, namespace, group, kind, name string) bool {
+	fromGVK := from.GroupVersionKind()
+
+	for _, pos := range idx.referenceGrants[namespace] {
+		grant := idx.objects[pos]
+
+		fromAllowed := false
+		fromList, _, _ := unstructured.NestedSlice(grant.Object, "spec", "from")
+		for _, value := range fromList {
+			entry, ok := value.(map[string]interface{})
+			if ok && entry["group"] == fromGVK.Group && entry["kind"] == fromGVK.Kind &&
+				entry["namespace"] == from.GetNamespace() {
+				fromAllowed = true
+				break
+			}
+		}
+		if !fromAllowed {
+			continue
+		}
+
+		toList, _, _ := unstructured.NestedSlice(grant.Object, "spec", "to")
+		for _, value := range toList {
+			entry, ok := value.(map[string]interface{})
+			if !ok || entry["group"] != group || entry["kind"] != kind {
+				continue
+			}
+			if toName, ok := entry["name"].(string); !ok || toName == "" || toName == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,442 @@
+package k8stree
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+// anyValue matches any API group or kind in relationship rules
+const anyValue = "*"
+
+//go:embed rules/*.yaml
+var rulePacks embed.FS
+
+// RelationshipRule declares a relationship between objects that is expressed
+// in their spec rather than by owner references, e.g. a cert-manager
+// Certificate and the Secret named by its secretName field. Rules are read
+// from YAML files holding a list of rules under the "rules" key.
+//
+// Objects matching the rule's source are related to targets found either by
+// reference or by label selector:
+//
+//   - Reference is a JSONPath expression (e.g. "{.spec.secretName}")
+//     evaluated against the source object. Every string result is the name
+//     of a target. Every object result is a reference to a target, read from
+//     its "name", "namespace", "uid", "kind", "apiVersion" and "group"
+//     fields, which override the rule's target.
+//   - Selector is a JSONPath expression evaluated against the source object,
+//     resulting in a metav1.LabelSelector or a map of labels. Targets are the
+//     objects of the target kind matching the selector. An empty selector
+//     selects nothing.
+//
+// Targets are looked up in the source's namespace, unless the target is
+// cluster-scoped (including references naming one of the target's
+// cluster-scoped kinds) or the reference names another namespace. Targets
+// referenced by name that are not found in the source's namespace are also
+// looked up among cluster-scoped objects, so that references to either a
+// namespaced or a cluster-scoped kind (e.g. Issuer and ClusterIssuer)
+// resolve.
+// Referenced targets that were not collected are added to the graph as
+// missing nodes, unless their API group or kind is unknown.
+type RelationshipRule struct {
+	// Name describes the rule, and is used in error messages
+	Name string `json:"name"`
+
+	// Source matches the objects the rule applies to
+	Source RuleSource `json:"source"`
+
+	// Target is the kind of related objects
+	Target RuleTarget `json:"target"`
+
+	// Type is the type of edges created from sources to targets (e.g. "owns"
+	// or "uses", see the Edge constants)
+	Type string `json:"type"`
+
+	// Reference and Selector are JSONPath expressions finding the targets;
+	// exactly one of them must be set
+	Reference string `json:"reference,omitempty"`
+	Selector  string `json:"selector,omitempty"`
+
+	requires  *jsonpath.JSONPath
+	reference *jsonpath.JSONPath
+	selector  *jsonpath.JSONPath
+}
+
+// RuleSource matches the source objects of a relationship rule
+type RuleSource struct {
+	// Group and Kind of source objects, "*" matches any group or kind
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+
+	// Requires is an optional JSONPath expression that must have a result
+	// for an object to match, e.g. to tell Crossplane claims (which can be
+	// of any kind) by their spec.compositionRef field
+	Requires string `json:"requires,omitempty"`
+}
+
+// RuleTarget is the kind of the target objects of a relationship rule
+type RuleTarget struct {
+	// Group and Kind of target objects. "*" matches any group. The kind may
+	// be omitted if references include it
+	Group string `json:"group"`
+	Kind  string `json:"kind,omitempty"`
+
+	// ClusterScoped is true if targets are cluster-scoped objects
+	ClusterScoped bool `json:"clusterScoped,omitempty"`
+
+	// ClusterScopedKinds are kinds of cluster-scoped targets that references
+	// may name instead of the target kind, e.g. ClusterIssuer for references
+	// to an Issuer or ClusterIssuer
+	ClusterScopedKinds []string `json:"clusterScopedKinds,omitempty"`
+}
+
+// ParseRelationshipRules parses and validates a YAML document holding a list
+// of relationship rules under the "rules" key
+func ParseRelationshipRules(data []byte) ([]RelationshipRule, error) {
+	var file struct {
+		Rules []RelationshipRule `json:"rules"`
+	}
+	err := yaml.UnmarshalStrict(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing relationship rules: %w", err)
+	}
+
+	for i := range file.Rules {
+		err = file.Rules[i].compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid relationship rule %d (%q): %w", i, file.Rules[i].Name, err)
+		}
+	}
+
+	return file.Rules, nil
+}
+
+// LoadRelationshipRules loads the built-in rule packs listed in
+// config.Config.RelationshipRulePacks, and the rule files listed in
+// config.Config.RelationshipRules. Packs and files that fail loading are
+// skipped.
+func LoadRelationshipRules(conf *config.Config) []RelationshipRule {
+	var rules []RelationshipRule
+
+	for _, pack := range conf.RelationshipRulePacks {
+		pack = strings.TrimSpace(pack)
+		if pack == "" {
+			continue
+		}
+
+		packRules, err := loadRulePack(pack)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("pack", pack).
+				Msg("Failed loading built-in relationship rules")
+			continue
+		}
+
+		rules = append(rules, packRules...)
+	}
+
+	for _, rulesPath := range conf.RelationshipRules {
+		rulesPath = strings.TrimSpace(rulesPath)
+		if rulesPath == "" {
+			continue
+		}
+
+		data, err := conf.ReadFile(rulesPath)
+		if err == nil {
+			var fileRules []RelationshipRule
+			fileRules, err = ParseRelationshipRules(data)
+			rules = append(rules, fileRules...)
+		}
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("path", rulesPath).
+				Msg("Failed loading relationship rules")
+		}
+	}
+
+	return rules
+}
+
+// RulePacks returns the names of all built-in relationship rule packs
+func RulePacks() []string {
+	entries, _ := rulePacks.ReadDir("rules")
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())))
+	}
+	return names
+}
+
+func loadRulePack(name string) ([]RelationshipRule, error) {
+	data, err := rulePacks.ReadFile("rules/" + name + ".yaml")
+	if err != nil {
+		return nil, fmt.Errorf("unknown rule pack (available packs: %s)", strings.Join(RulePacks(), ", "))
+	}
+
+	return ParseRelationshipRules(data)
+}
+
+func (rule *RelationshipRule) compile() (err error) {
+	switch {
+	case rule.Source.Kind == "":
+		return fmt.Errorf("source kind must be provided")
+	case rule.Type == "":
+		return fmt.Errorf("edge type must be provided")
+	case (rule.Reference == "") == (rule.Selector == ""):
+		return fmt.Errorf("exactly one of reference and selector must be provided")
+	case rule.Selector != "" && (rule.Target.Kind == "" || rule.Target.Kind == anyValue):
+		return fmt.Errorf("target kind must be provided for selector rules")
+	}
+
+	rule.requires, err = compileJSONPath("requires", rule.Source.Requires)
+	if err != nil {
+		return err
+	}
+	rule.reference, err = compileJSONPath("reference", rule.Reference)
+	if err != nil {
+		return err
+	}
+	rule.selector, err = compileJSONPath("selector", rule.Selector)
+	return err
+}
+
+// compileJSONPath parses a JSONPath expression, which may be provided with or
+// without the surrounding braces. Empty expressions result in nil.
+func compileJSONPath(name, expression string) (*jsonpath.JSONPath, error) {
+	expression = strings.TrimSpace(expression)
+	if expression == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(expression, "{") {
+		expression = "{" + expression + "}"
+	}
+
+	jp := jsonpath.New(name).AllowMissingKeys(true)
+	err := jp.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s expression: %w", name, err)
+	}
+	return jp, nil
+}
+
+// findValues evaluates a JSONPath expression against an object, returning
+// all non-nil results
+func findValues(jp *jsonpath.JSONPath, obj map[string]interface{}) []interface{} {
+	results, err := jp.FindResults(obj)
+	if err != nil {
+		return nil
+	}
+
+	var values []interface{}
+	for _, result := range results {
+		for _, value := range result {
+			if value.IsValid() && value.CanInterface() && value.Interface() != nil {
+				values = append(values, value.Interface())
+			}
+		}
+	}
+	return values
+}
+
+// matches checks whether an object is a source of the rule
+func (rule *RelationshipRule) matches(obj unstructured.Unstructured) bool {
+	if rule.Source.Kind != anyValue && rule.Source.Kind != obj.GetKind() {
+		return false
+	}
+	if rule.Source.Group != anyValue && rule.Source.Group != obj.GroupVersionKind().Group {
+		return false
+	}
+	return rule.requires == nil || len(findValues(rule.requires, obj.Object)) > 0
+}
+
+// relations returns the edges declared by the rule for an object
+func (rule *RelationshipRule) relations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if !rule.matches(obj) {
+		return nil
+	}
+
+	if rule.selector != nil {
+		return rule.selectorRelations(idx, obj)
+	}
+
+	var edges []Edge
+	for _, value := range findValues(rule.reference, obj.Object) {
+		target := ruleReference{
+			group:     rule.Target.Group,
+			kind:      rule.Target.Kind,
+			namespace: obj.GetNamespace(),
+		}
+		if rule.Target.ClusterScoped {
+			target.namespace = ""
+		}
+
+		switch value := value.(type) {
+		case string:
+			target.name = value
+		case map[string]interface{}:
+			target.override(value)
+		default:
+			continue
+		}
+
+		if includes(rule.Target.ClusterScopedKinds, target.kind) {
+			target.namespace = ""
+			target.explicitNamespace = false
+		}
+
+		if uid := idx.resolve(target); uid != "" {
+			edges = append(edges, Edge{
+				Type: rule.Type,
+				From: string(obj.GetUID()),
+				To:   uid,
+			})
+		}
+	}
+
+	return edges
+}
+
+// selectorRelations returns edges from an object to the targets matching the
+// label selector found by the rule
+func (rule *RelationshipRule) selectorRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	namespace := obj.GetNamespace()
+	if rule.Target.ClusterScoped {
+		namespace = ""
+	}
+
+	var edges []Edge
+	for _, value := range findValues(rule.selector, obj.Object) {
+		value, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var selector labels.Selector
+		_, isLabelSelector := value["matchLabels"]
+		if _, ok := value["matchExpressions"]; ok || isLabelSelector {
+			selector, _, ok = labelSelector(unstructured.Unstructured{Object: value})
+			if !ok {
+				continue
+			}
+		} else {
+			set := make(labels.Set, len(value))
+			for key, labelValue := range value {
+				if labelValue, ok := labelValue.(string); ok {
+					set[key] = labelValue
+				}
+			}
+			selector = labels.SelectorFromSet(set)
+		}
+		if selector.Empty() {
+			continue
+		}
+
+		for _, pos := range idx.kinds[rule.Target.Kind] {
+			target := idx.objects[pos]
+			if target.GetNamespace() != namespace ||
+				(rule.Target.Group != anyValue && rule.Target.Group != target.GroupVersionKind().Group) ||
+				!selector.Matches(labels.Set(target.GetLabels())) {
+				continue
+			}
+
+			edges = append(edges, Edge{
+				Type: rule.Type,
+				From: string(obj.GetUID()),
+				To:   string(target.GetUID()),
+			})
+		}
+	}
+
+	return edges
+}
+
+// ruleReference is a reference to the target of a relationship rule
+type ruleReference struct {
+	group     string
+	kind      string
+	namespace string
+	name      string
+	uid       string
+
+	// explicitNamespace is true if the namespace was read from the reference
+	explicitNamespace bool
+}
+
+// override overrides the fields of a reference with those of an object
+// reference found by a rule
+func (ref *ruleReference) override(value map[string]interface{}) {
+	if name, ok := value["name"].(string); ok {
+		ref.name = name
+	}
+	if namespace, ok := value["namespace"].(string); ok && namespace != "" {
+		ref.namespace = namespace
+		ref.explicitNamespace = true
+	}
+	if uid, ok := value["uid"].(string); ok {
+		ref.uid = uid
+	}
+	if kind, ok := value["kind"].(string); ok && kind != "" {
+		ref.kind = kind
+	}
+	if apiVersion, ok := value["apiVersion"].(string); ok && apiVersion != "" {
+		if gv, err := schema.ParseGroupVersion(apiVersion); err == nil {
+			ref.group = gv.Group
+		}
+	}
+	if group, ok := value["group"].(string); ok && group != "" {
+		ref.group = group
+	}
+}
+
+// resolve returns the UID of the target of a rule reference, adding a
+// missing node if needed, or an empty string if it cannot be resolved
+func (idx *objectIndex) resolve(ref ruleReference) string {
+	if ref.uid != "" {
+		if _, ok := idx.byUID[ref.uid]; ok {
+			return ref.uid
+		}
+	}
+	if ref.name == "" || ref.kind == "" || ref.kind == anyValue {
+		return ""
+	}
+
+	namespaces := []string{ref.namespace}
+	if ref.namespace != "" && !ref.explicitNamespace {
+		namespaces = append(namespaces, "")
+	}
+
+	for _, namespace := range namespaces {
+		if ref.group != anyValue {
+			if obj, ok := idx.lookupGroup(namespace, ref.group, ref.kind, ref.name); ok {
+				return string(obj.GetUID())
+			}
+			continue
+		}
+
+		for _, pos := range idx.kinds[ref.kind] {
+			obj := idx.objects[pos]
+			if obj.GetNamespace() == namespace && obj.GetName() == ref.name {
+				return string(obj.GetUID())
+			}
+		}
+	}
+
+	if ref.group == anyValue {
+		return ""
+	}
+	return idx.reference(ref.group, ref.kind, ref.namespace, ref.name)
+}
@@ -0,0 +1,299 @@
+package k8stree
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// endpointSliceServiceLabel is the label referencing the Service of an
+// EndpointSlice
+const endpointSliceServiceLabel = "kubernetes.io/service-name"
+
+// workloadKinds are the kinds of objects whose pod templates are matched by
+// Service selectors
+var workloadKinds = map[string]bool{
+	"Deployment":            true,
+	"StatefulSet":           true,
+	"DaemonSet":             true,
+	"ReplicaSet":            true,
+	"ReplicationController": true,
+}
+
+// labelIndex indexes objects in a single namespace by their labels
+type labelIndex struct {
+	// positions of indexed objects, and their labels
+	objects []int
+	labels  []labels.Set
+
+	// positions in objects by "key=value" label pairs
+	byPair map[string][]int
+}
+
+func (li *labelIndex) add(pos int, set labels.Set) {
+	if li.byPair == nil {
+		li.byPair = make(map[string][]int)
+	}
+
+	i := len(li.objects)
+	li.objects = append(li.objects, pos)
+	li.labels = append(li.labels, set)
+	for key, value := range set {
+		li.byPair[key+"="+value] = append(li.byPair[key+"="+value], i)
+	}
+}
+
+// match returns the positions of all indexed objects matching a selector. If
+// the selector requires specific label values, only objects having one of
+// them are evaluated.
+func (li *labelIndex) match(selector labels.Selector, required map[string]string) []int {
+	if li == nil {
+		return nil
+	}
+
+	candidates := -1
+	var narrowed []int
+	for key, value := range required {
+		list := li.byPair[key+"="+value]
+		if candidates == -1 || len(list) < candidates {
+			candidates = len(list)
+			narrowed = list
+		}
+	}
+
+	var matches []int
+	if candidates == -1 {
+		for i, set := range li.labels {
+			if selector.Matches(set) {
+				matches = append(matches, li.objects[i])
+			}
+		}
+		return matches
+	}
+
+	for _, i := range narrowed {
+		if selector.Matches(li.labels[i]) {
+			matches = append(matches, li.objects[i])
+		}
+	}
+	return matches
+}
+
+// objectKey identifies an object by namespace, API group, kind and name
+type objectKey struct {
+	Namespace string
+	Group     string
+	Kind      string
+	Name      string
+}
+
+func keyOf(obj unstructured.Unstructured) objectKey {
+	return objectKey{
+		Namespace: obj.GetNamespace(),
+		Group:     obj.GroupVersionKind().Group,
+		Kind:      obj.GetKind(),
+		Name:      obj.GetName(),
+	}
+}
+
+// lookup finds an object by namespace, API version, kind and name. The
+// version is ignored, as objects are collected in their preferred version
+// only.
+func (idx *objectIndex) lookup(namespace, apiVersion, kind, name string) (unstructured.Unstructured, bool) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return unstructured.Unstructured{}, false
+	}
+
+	return idx.lookupGroup(namespace, gv.Group, kind, name)
+}
+
+// lookupGroup finds an object by namespace, API group, kind and name
+func (idx *objectIndex) lookupGroup(namespace, group, kind, name string) (unstructured.Unstructured, bool) {
+	pos, ok := idx.byKey[objectKey{Namespace: namespace, Group: group, Kind: kind, Name: name}]
+	if !ok {
+		return unstructured.Unstructured{}, false
+	}
+	return idx.objects[pos], true
+}
+
+// selectEdges returns edges of the provided type from an object to all
+// matched objects
+func (idx *objectIndex) selectEdges(edgeType string, obj unstructured.Unstructured, matches []int) []Edge {
+	edges := make([]Edge, 0, len(matches))
+	for _, pos := range matches {
+		edges = append(edges, Edge{
+			Type: edgeType,
+			From: string(obj.GetUID()),
+			To:   string(idx.objects[pos].GetUID()),
+		})
+	}
+	return edges
+}
+
+// labelSelector parses the metav1.LabelSelector at the provided path of an
+// object. The returned map holds the labels required by the selector's
+// matchLabels, used to narrow down candidates.
+func labelSelector(obj unstructured.Unstructured, fields ...string) (
+	selector labels.Selector,
+	required map[string]string,
+	found bool,
+) {
+	value, found, err := unstructured.NestedMap(obj.Object, fields...)
+	if !found || err != nil {
+		return nil, nil, false
+	}
+
+	var labelSelector metav1.LabelSelector
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(value, &labelSelector)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	selector, err = metav1.LabelSelectorAsSelector(&labelSelector)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return selector, labelSelector.MatchLabels, true
+}
+
+// serviceRelations returns edges from a Service to the Pods and workloads
+// matching its selector. Services without a selector select nothing.
+func serviceRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if obj.GetKind() != "Service" {
+		return nil
+	}
+
+	set, found, err := unstructured.NestedStringMap(obj.Object, "spec", "selector")
+	if !found || err != nil || len(set) == 0 {
+		return nil
+	}
+
+	selector := labels.SelectorFromSet(set)
+	edges := idx.selectEdges(EdgeSelects, obj, idx.pods[obj.GetNamespace()].match(selector, set))
+	return append(edges, idx.selectEdges(EdgeSelects, obj, idx.templates[obj.GetNamespace()].match(selector, set))...)
+}
+
+// networkPolicyRelations returns edges from a NetworkPolicy to the Pods in
+// its namespace matching its pod selector. An empty selector selects all
+// Pods in the namespace.
+func networkPolicyRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if obj.GetKind() != "NetworkPolicy" {
+		return nil
+	}
+
+	selector, required, found := labelSelector(obj, "spec", "podSelector")
+	if !found {
+		return nil
+	}
+
+	return idx.selectEdges(EdgeSelects, obj, idx.pods[obj.GetNamespace()].match(selector, required))
+}
+
+// podDisruptionBudgetRelations returns edges from a PodDisruptionBudget to
+// the Pods in its namespace matching its selector. An empty selector selects
+// all Pods in policy/v1, and no Pods in policy/v1beta1.
+func podDisruptionBudgetRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if obj.GetKind() != "PodDisruptionBudget" {
+		return nil
+	}
+
+	selector, required, found := labelSelector(obj, "spec", "selector")
+	if !found || (selector.Empty() && obj.GetAPIVersion() == "policy/v1beta1") {
+		return nil
+	}
+
+	return idx.selectEdges(EdgeSelects, obj, idx.pods[obj.GetNamespace()].match(selector, required))
+}
+
+// autoscalerRelations returns an edge from a HorizontalPodAutoscaler or
+// VerticalPodAutoscaler to its scale target
+func autoscalerRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	var fields []string
+	switch obj.GetKind() {
+	case "HorizontalPodAutoscaler":
+		fields = []string{"spec", "scaleTargetRef"}
+	case "VerticalPodAutoscaler":
+		fields = []string{"spec", "targetRef"}
+	default:
+		return nil
+	}
+
+	ref, found, err := unstructured.NestedStringMap(obj.Object, fields...)
+	if !found || err != nil {
+		return nil
+	}
+
+	target, ok := idx.lookup(obj.GetNamespace(), ref["apiVersion"], ref["kind"], ref["name"])
+	if !ok {
+		return nil
+	}
+
+	return []Edge{{
+		Type: EdgeSelects,
+		From: string(obj.GetUID()),
+		To:   string(target.GetUID()),
+	}}
+}
+
+// endpointSliceRelations returns an edge to an EndpointSlice from the Service
+// referenced by its "kubernetes.io/service-name" label
+func endpointSliceRelations(idx *objectIndex, obj unstructured.Unstructured) []Edge {
+	if obj.GetKind() != "EndpointSlice" {
+		return nil
+	}
+
+	name, ok := obj.GetLabels()[endpointSliceServiceLabel]
+	if !ok {
+		return nil
+	}
+
+	service, ok := idx.lookup(obj.GetNamespace(), "v1", "Service", name)
+	if !ok {
+		return nil
+	}
+
+	return []Edge{{
+		Type: EdgeOwns,
+		From: string(service.GetUID()),
+		To:   string(obj.GetUID()),
+	}}
+}
+
+// podTemplateControllers are the kinds of controllers that create objects
+// from their own pod templates (e.g. Deployments creating ReplicaSets, or
+// CronJobs creating Jobs)
+var podTemplateControllers = map[groupKind]bool{
+	{"apps", "Deployment"}:        true,
+	{"apps", "StatefulSet"}:       true,
+	{"apps", "DaemonSet"}:         true,
+	{"apps", "ReplicaSet"}:        true,
+	{"extensions", "Deployment"}:  true,
+	{"extensions", "DaemonSet"}:   true,
+	{"extensions", "ReplicaSet"}:  true,
+	{"", "ReplicationController"}: true,
+	{"batch", "Job"}:              true,
+	{"batch", "CronJob"}:          true,
+}
+
+// hasPodTemplateController returns true if the object is managed by a
+// controller whose pod template it was created from (e.g. a ReplicaSet
+// managed by a Deployment), so that its pod template is that of the
+// controller. Objects managed by other controllers (e.g. a StatefulSet managed
+// by an operator's custom resource) own their pod templates.
+func hasPodTemplateController(obj unstructured.Unstructured) bool {
+	ref := metav1.GetControllerOfNoCopy(&obj)
+	if ref == nil {
+		return false
+	}
+
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false
+	}
+
+	return podTemplateControllers[groupKind{gv.Group, ref.Kind}]
+}
@@ -2,29 +2,54 @@ package k8stypes
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
 	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8s"
 )
 
+// crdResource is the resource of CustomResourceDefinitions
+var crdResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
 // Collector is a struct implementing the DataCollector interface. It wraps a
-// Kubernetes API client object.
+// Kubernetes API client object, and a dynamic client used to read
+// CustomResourceDefinitions.
 type Collector struct {
 	// client object for the Kubernetes API server
 	api kubernetes.Interface
+
+	// dynamic client for the Kubernetes API server
+	dynamic dynamic.Interface
+
+	// version of the Kubernetes API server, as found during the last run
+	serverVersion *version.Info
 }
 
 // New creates a new instance of the Collector struct. A Kubernetes API client
-// object must be provided. This can either be a client for a real API server,
-// a fake client from k8s.io/client-go/kubernetes/fake, or any object that
-// implements the kubernetes.Interface interface.
-func New(api kubernetes.Interface) *Collector {
+// object and a dynamic client must be provided. These can either be clients
+// for a real API server, or fake clients from k8s.io/client-go/kubernetes/fake
+// and k8s.io/client-go/dynamic/fake.
+func New(api kubernetes.Interface, dynamicClient dynamic.Interface) *Collector {
 	return &Collector{
-		api: api,
+		api:     api,
+		dynamic: dynamicClient,
 	}
 }
 
@@ -42,7 +67,12 @@ func DefaultConfiguration(apiConfig *rest.Config) (
 		return collector, fmt.Errorf("failed getting K8s client set: %w", err)
 	}
 
-	return New(api), nil
+	dynamicClient, err := dynamic.NewForConfig(apiConfig)
+	if err != nil {
+		return collector, fmt.Errorf("failed getting K8s dynamic client: %w", err)
+	}
+
+	return New(api, dynamicClient), nil
 }
 
 // Source is required by the DataCollector interface to return a name for the
@@ -52,7 +82,17 @@ func (f *Collector) Source() string {
 }
 
 // Run executes the collector with the provided configuration object, and
-// returns a list of supported resources from the cluster.
+// returns a list of supported resources from the cluster. Every resource type
+// is described by its kind, API version, plural resource name, scope, verbs,
+// short names, categories and subresources. Subresources (e.g.
+// "deployments/scale") are returned as entries of their own as well, naming
+// their parent resource. Every type also includes its storage version: for
+// custom resource types it is read from their CustomResourceDefinition, and
+// for built-in types it is found by matching their storage version hash
+// against the versions of their group served by the cluster (it is omitted if
+// none match, e.g. when objects are stored in a different group). Custom
+// resource types also include the name of their CustomResourceDefinition and,
+// if config.Config.CollectTypeSchemas is true, their OpenAPI v3 schema.
 func (f *Collector) Run(ctx context.Context, conf *config.Config) (
 	keyName string,
 	types []interface{},
@@ -61,19 +101,90 @@ func (f *Collector) Run(ctx context.Context, conf *config.Config) (
 	log.Debug().Msg("Starting collect Kubernetes supported types")
 	var supportedResources []map[string]interface{}
 
+	f.serverVersion, err = f.api.Discovery().ServerVersion()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed finding Kubernetes server version")
+	}
+
 	_, apiGroups, err := f.api.Discovery().ServerGroupsAndResources()
+	_, err = k8s.HandleDiscoveryError(conf, err)
 	if err != nil {
 		return "", nil, err
 	}
+
+	crds, err := f.customResourceDefinitions(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed listing CustomResourceDefinitions")
+	}
+
+	// versions served by the cluster for every group, used to find the storage
+	// versions of built-in types
+	groupVersions := make(map[string][]string)
+	for _, apiGroup := range apiGroups {
+		if gv, err := schema.ParseGroupVersion(apiGroup.GroupVersion); err == nil {
+			groupVersions[gv.Group] = append(groupVersions[gv.Group], gv.Version)
+		}
+	}
+
 	for _, apiGroup := range apiGroups {
 		if len(apiGroup.APIResources) == 0 {
 			continue
 		}
+
+		gv, err := schema.ParseGroupVersion(apiGroup.GroupVersion)
+		if err != nil {
+			log.Warn().Err(err).Str("groupVersion", apiGroup.GroupVersion).Msg("Skipping invalid group version")
+			continue
+		}
+
+		// subresources (e.g. "deployments/scale") are also listed along with
+		// their parent resources
+		subresources := make(map[string][]string)
+		for _, resource := range apiGroup.APIResources {
+			if parts := strings.SplitN(resource.Name, "/", 2); len(parts) == 2 {
+				subresources[parts[0]] = append(subresources[parts[0]], parts[1])
+			}
+		}
+
 		for _, resource := range apiGroup.APIResources {
+			// subresources are entries of their own, which name their parent
+			// resource in "parentResource"
+			parent := strings.SplitN(resource.Name, "/", 2)[0]
+			isSubresource := parent != resource.Name
+
 			var resourceConf = make(map[string]interface{})
 			resourceConf["kind"] = resource.Kind
 			resourceConf["namespaced"] = resource.Namespaced
 			resourceConf["apiVersion"] = apiGroup.GroupVersion
+			resourceConf["resource"] = resource.Name
+			resourceConf["verbs"] = []string(resource.Verbs)
+			resourceConf["shortNames"] = resource.ShortNames
+			resourceConf["categories"] = resource.Categories
+			if isSubresource {
+				resourceConf["parentResource"] = parent
+			} else {
+				sort.Strings(subresources[resource.Name])
+				resourceConf["subresources"] = subresources[resource.Name]
+			}
+			if resource.StorageVersionHash != "" {
+				resourceConf["storageVersionHash"] = resource.StorageVersionHash
+				if version := storageVersion(gv.Group, resource.Kind, resource.StorageVersionHash, groupVersions[gv.Group]); version != "" {
+					resourceConf["storageVersion"] = version
+				}
+			}
+
+			if crds != nil {
+				crd, isCRD := crds[schema.GroupResource{Group: gv.Group, Resource: parent}]
+				resourceConf["crd"] = isCRD
+				if isCRD {
+					resourceConf["crdName"] = crd.name
+					resourceConf["storageVersion"] = crd.storageVersion
+					if conf.CollectTypeSchemas && !isSubresource && crd.schemas[gv.Version] != nil {
+						resourceConf["schema"] = crd.schemas[gv.Version]
+					}
+				}
+			}
+
 			supportedResources = append(supportedResources, resourceConf)
 		}
 	}
@@ -87,3 +198,90 @@ func (f *Collector) Run(ctx context.Context, conf *config.Config) (
 
 	return "k8s_types", types, nil
 }
+
+// storageVersion returns the version, out of the provided versions of a group,
+// whose storage version hash for the provided kind matches the provided hash.
+// An empty string is returned if none match.
+func storageVersion(group, kind, hash string, versions []string) string {
+	for _, version := range versions {
+		if storageVersionHash(group, version, kind) == hash {
+			return version
+		}
+	}
+
+	return ""
+}
+
+// storageVersionHash calculates the storage version hash of a group, version
+// and kind the same way the Kubernetes API server does
+func storageVersionHash(group, version, kind string) string {
+	sum := sha256.Sum256([]byte(group + "/" + version + "/" + kind))
+	return base64.StdEncoding.EncodeToString(sum[:8])
+}
+
+// crdInfo describes a CustomResourceDefinition
+type crdInfo struct {
+	// name of the CustomResourceDefinition
+	name string
+
+	// version in which objects are persisted
+	storageVersion string
+
+	// OpenAPI v3 schemas of every version
+	schemas map[string]map[string]interface{}
+}
+
+// customResourceDefinitions lists all CustomResourceDefinitions in the
+// cluster, keyed by the group and resource they define
+func (f *Collector) customResourceDefinitions(ctx context.Context) (
+	map[schema.GroupResource]*crdInfo,
+	error,
+) {
+	list, err := f.dynamic.Resource(crdResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	crds := make(map[schema.GroupResource]*crdInfo, len(list.Items))
+	for _, item := range list.Items {
+		group, _, _ := unstructured.NestedString(item.Object, "spec", "group")
+		plural, _, _ := unstructured.NestedString(item.Object, "spec", "names", "plural")
+		versions, _, _ := unstructured.NestedSlice(item.Object, "spec", "versions")
+
+		crd := &crdInfo{
+			name:    item.GetName(),
+			schemas: make(map[string]map[string]interface{}, len(versions)),
+		}
+
+		for _, value := range versions {
+			version, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			name, _, _ := unstructured.NestedString(version, "name")
+			if storage, _, _ := unstructured.NestedBool(version, "storage"); storage {
+				crd.storageVersion = name
+			}
+			if openAPISchema, ok, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema"); ok {
+				crd.schemas[name] = openAPISchema
+			}
+		}
+
+		crds[schema.GroupResource{Group: group, Resource: plural}] = crd
+	}
+
+	return crds, nil
+}
+
+// Supplemental implements the SupplementalDataCollector interface, returning
+// the version of the Kubernetes API server under the "k8s_server_version" key.
+func (f *Collector) Supplemental() map[string][]interface{} {
+	if f.serverVersion == nil {
+		return nil
+	}
+
+	return map[string][]interface{}{
+		"k8s_server_version": {f.serverVersion},
+	}
+}
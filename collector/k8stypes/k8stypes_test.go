@@ -0,0 +1,158 @@
+package k8stypes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jgroeneveld/trial/assert"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/infralight/k8s-collector/collector/config"
+)
+
+func TestRun(t *testing.T) {
+	logger := zerolog.Nop()
+
+	widgetSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"spec": map[string]interface{}{"type": "object"},
+		},
+	}
+
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": "widgets.example.com"},
+		"spec": map[string]interface{}{
+			"group": "example.com",
+			"names": map[string]interface{}{"plural": "widgets", "kind": "Widget"},
+			"versions": []interface{}{
+				map[string]interface{}{
+					"name":    "v1alpha1",
+					"served":  true,
+					"storage": false,
+				},
+				map[string]interface{}{
+					"name":    "v1",
+					"served":  true,
+					"storage": true,
+					"schema":  map[string]interface{}{"openAPIV3Schema": widgetSchema},
+				},
+			},
+		},
+	}}
+
+	api := fake.NewSimpleClientset()
+	api.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{
+					Name:               "deployments",
+					Kind:               "Deployment",
+					Namespaced:         true,
+					Verbs:              []string{"get", "list", "watch"},
+					ShortNames:         []string{"deploy"},
+					Categories:         []string{"all"},
+					StorageVersionHash: "8aSe+NMegvE=",
+				},
+				{Name: "deployments/status", Kind: "Deployment", Namespaced: true, Verbs: []string{"get"}},
+				{Name: "deployments/scale", Group: "autoscaling", Version: "v1", Kind: "Scale", Namespaced: true, Verbs: []string{"get"}},
+			},
+		},
+		{
+			GroupVersion: "example.com/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "widgets", Kind: "Widget", Verbs: []string{"get", "list"}},
+				{Name: "widgets/status", Kind: "Widget", Verbs: []string{"get", "update"}},
+			},
+		},
+	}
+
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{crdResource: "CustomResourceDefinitionList"},
+		crd,
+	)
+
+	conf := &config.Config{
+		Log:                  &logger,
+		CollectTypeSchemas:   true,
+		MaxDiscoveryFailures: 5,
+	}
+
+	keyName, types, err := New(api, dynamicClient).Run(context.Background(), conf)
+	assert.MustBeNil(t, err, "error must be nil")
+	assert.Equal(t, "k8s_types", keyName, "key name must match")
+	assert.DeepEqual(t, []interface{}{
+		map[string]interface{}{
+			"kind":               "Deployment",
+			"namespaced":         true,
+			"apiVersion":         "apps/v1",
+			"resource":           "deployments",
+			"verbs":              []string{"get", "list", "watch"},
+			"shortNames":         []string{"deploy"},
+			"categories":         []string{"all"},
+			"subresources":       []string{"scale", "status"},
+			"storageVersionHash": "8aSe+NMegvE=",
+			"storageVersion":     "v1",
+			"crd":                false,
+		},
+		map[string]interface{}{
+			"kind":           "Deployment",
+			"namespaced":     true,
+			"apiVersion":     "apps/v1",
+			"resource":       "deployments/status",
+			"verbs":          []string{"get"},
+			"shortNames":     []string(nil),
+			"categories":     []string(nil),
+			"parentResource": "deployments",
+			"crd":            false,
+		},
+		map[string]interface{}{
+			"kind":           "Scale",
+			"namespaced":     true,
+			"apiVersion":     "apps/v1",
+			"resource":       "deployments/scale",
+			"verbs":          []string{"get"},
+			"shortNames":     []string(nil),
+			"categories":     []string(nil),
+			"parentResource": "deployments",
+			"crd":            false,
+		},
+		map[string]interface{}{
+			"kind":           "Widget",
+			"namespaced":     false,
+			"apiVersion":     "example.com/v1",
+			"resource":       "widgets",
+			"verbs":          []string{"get", "list"},
+			"shortNames":     []string(nil),
+			"categories":     []string(nil),
+			"subresources":   []string{"status"},
+			"crd":            true,
+			"crdName":        "widgets.example.com",
+			"storageVersion": "v1",
+			"schema":         widgetSchema,
+		},
+		map[string]interface{}{
+			"kind":           "Widget",
+			"namespaced":     false,
+			"apiVersion":     "example.com/v1",
+			"resource":       "widgets/status",
+			"verbs":          []string{"get", "update"},
+			"shortNames":     []string(nil),
+			"categories":     []string(nil),
+			"parentResource": "widgets",
+			"crd":            true,
+			"crdName":        "widgets.example.com",
+			"storageVersion": "v1",
+		},
+	}, types, "types must match")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8stree"
+)
+
+// Formats of the changes reported by the diff command
+const (
+	diffFormatText = "text"
+	diffFormatJSON = "json"
+)
+
+// runDiff implements the "diff" command, which reports the structural
+// changes between the objects trees of two fetchings. Every side of the
+// comparison is either the output of a dry run or a tree snapshot, and
+// defaults to the last persisted snapshot (for the older side) or to the live
+// cluster (for the newer side). Nothing is sent to Firefly.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	debug := flags.Bool("debug", false, "sets log level to debug")
+	external := flags.String(
+		"external",
+		"",
+		"run outside of the cluster (provide path to kubeconfig file)",
+	)
+	configDir := flags.String("config", "/etc/config", "configuration files directory")
+	from := flags.String(
+		"from",
+		"",
+		"dry run output or tree snapshot to compare from (defaults to the persisted snapshot, see collector.treeSnapshotPath)",
+	)
+	to := flags.String(
+		"to",
+		"",
+		"dry run output or tree snapshot to compare to (\"-\" for standard input, defaults to the cluster)",
+	)
+	format := flags.String("format", diffFormatText, "output format (text, json)")
+	output := flags.String("output", "", "output file (defaults to standard output)")
+	_ = flags.Parse(args)
+
+	logger := loadLogger(*debug)
+
+	if *format != diffFormatText && *format != diffFormatJSON {
+		return fmt.Errorf("invalid format %q, must be %q or %q", *format, diffFormatText, diffFormatJSON)
+	}
+	if *from == "-" && *to == "-" {
+		return fmt.Errorf("only one side of the comparison can be read from standard input")
+	}
+
+	conf, err := config.LoadConfig(logger, nil, *configDir, true)
+	if err != nil {
+		return fmt.Errorf("failed loading collector configuration: %w", err)
+	}
+
+	if *from == "" {
+		if conf.TreeSnapshotPath == "" {
+			return fmt.Errorf("no snapshot to compare from, provide -from or set collector.treeSnapshotPath")
+		}
+		*from = conf.TreeSnapshotPath
+	}
+
+	fromSnapshot, err := loadSnapshot(conf, *from)
+	if err != nil {
+		return err
+	}
+
+	var toSnapshot *k8stree.Snapshot
+	if *to != "" {
+		toSnapshot, err = loadSnapshot(conf, *to)
+	} else {
+		var objects []interface{}
+		objects, err = collectObjects(conf, *external)
+		if err == nil {
+			toSnapshot, err = snapshotObjects(conf, objects)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	changes := k8stree.DiffSnapshots(fromSnapshot, toSnapshot)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed creating output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == diffFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(changes)
+	} else {
+		err = k8stree.WriteChanges(w, changes)
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing changes: %w", err)
+	}
+
+	logger.Info().
+		Int("changes", len(changes)).
+		Str("format", *format).
+		Msg("Diff finished")
+
+	return nil
+}
+
+// loadSnapshot loads a tree snapshot from a file, which is either a snapshot
+// or the output of a dry run, whose objects trees are built
+func loadSnapshot(conf *config.Config, path string) (*k8stree.Snapshot, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading %s: %w", path, err)
+	}
+
+	var keys map[string]json.RawMessage
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", path, err)
+	}
+
+	if _, ok := keys["k8s_objects"]; !ok {
+		snapshot, err := k8stree.DecodeSnapshot(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading %s: %w", path, err)
+		}
+		return snapshot, nil
+	}
+
+	objects, err := parseDump(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotObjects(conf, objects)
+}
+
+// snapshotObjects builds the object graph and trees of a list of objects, and
+// returns their snapshot
+func snapshotObjects(conf *config.Config, objects []interface{}) (*k8stree.Snapshot, error) {
+	trees, err := k8stree.GetK8sTree(objects)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting k8s objects tree: %w", err)
+	}
+
+	graph, err := k8stree.GetK8sGraph(objects, k8stree.LoadRelationshipRules(conf))
+	if err != nil {
+		return nil, fmt.Errorf("failed getting k8s objects graph: %w", err)
+	}
+
+	return k8stree.NewSnapshot(trees, graph), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/thoas/go-funk"
+
+	"github.com/infralight/k8s-collector/collector"
+	"github.com/infralight/k8s-collector/collector/config"
+	"github.com/infralight/k8s-collector/collector/k8s"
+	"github.com/infralight/k8s-collector/collector/k8stree"
+)
+
+// Views exported by the export command
+const (
+	exportViewGraph = "graph"
+	exportViewTree  = "tree"
+)
+
+// runExport implements the "export" command, which builds the object graph
+// (or trees) of a live cluster, or of the output of a dry run, and exports it
+// for visualization. Nothing is sent to Firefly.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	debug := flags.Bool("debug", false, "sets log level to debug")
+	external := flags.String(
+		"external",
+		"",
+		"run outside of the cluster (provide path to kubeconfig file)",
+	)
+	configDir := flags.String("config", "/etc/config", "configuration files directory")
+	dump := flags.String(
+		"dump",
+		"",
+		"read objects from the output of a dry run (\"-\" for standard input) rather than from the cluster",
+	)
+	format := flags.String(
+		"format",
+		k8stree.FormatDOT,
+		fmt.Sprintf("output format (%s)", strings.Join(k8stree.ExportFormats, ", ")),
+	)
+	view := flags.String(
+		"view",
+		exportViewGraph,
+		"export the relationship graph (\"graph\") or the ownership trees (\"tree\")",
+	)
+	output := flags.String("output", "", "output file (defaults to standard output)")
+	namespaces := flags.String("namespace", "", "comma-separated list of namespaces of exported roots")
+	rootKinds := flags.String("root-kind", "", "comma-separated list of kinds of exported roots")
+	depth := flags.Int("depth", 0, "maximum distance of exported objects from their roots (0 for unlimited)")
+	_ = flags.Parse(args)
+
+	logger := loadLogger(*debug)
+
+	if *view != exportViewGraph && *view != exportViewTree {
+		return fmt.Errorf("invalid view %q, must be %q or %q", *view, exportViewGraph, exportViewTree)
+	}
+	if !funk.ContainsString(k8stree.ExportFormats, *format) {
+		return fmt.Errorf("invalid format %q, must be one of %s", *format, strings.Join(k8stree.ExportFormats, ", "))
+	}
+
+	conf, err := config.LoadConfig(logger, nil, *configDir, true)
+	if err != nil {
+		return fmt.Errorf("failed loading collector configuration: %w", err)
+	}
+
+	var objects []interface{}
+	if *dump != "" {
+		objects, err = loadDump(*dump)
+	} else {
+		objects, err = collectObjects(conf, *external)
+	}
+	if err != nil {
+		return err
+	}
+
+	var graph *k8stree.Graph
+	if *view == exportViewTree {
+		trees, err := k8stree.GetK8sTree(objects)
+		if err != nil {
+			return fmt.Errorf("failed getting k8s objects tree: %w", err)
+		}
+		graph = k8stree.TreeGraph(trees)
+	} else {
+		graph, err = k8stree.GetK8sGraph(objects, k8stree.LoadRelationshipRules(conf))
+		if err != nil {
+			return fmt.Errorf("failed getting k8s objects graph: %w", err)
+		}
+	}
+
+	graph = graph.Filter(k8stree.ExportFilter{
+		Namespaces: splitList(*namespaces),
+		RootKinds:  splitList(*rootKinds),
+		Depth:      *depth,
+	})
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed creating output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	err = k8stree.Export(w, graph, *format)
+	if err != nil {
+		return fmt.Errorf("failed exporting %s: %w", *view, err)
+	}
+
+	logger.Info().
+		Int("nodes", len(graph.Nodes)).
+		Int("edges", len(graph.Edges)).
+		Str("format", *format).
+		Msg("Export finished")
+
+	return nil
+}
+
+// collectObjects collects all Kubernetes objects from a live cluster
+func collectObjects(conf *config.Config, external string) ([]interface{}, error) {
+	apiConfig, err := loadKubeConfig(external)
+	if err != nil {
+		return nil, err
+	}
+
+	k8sCollector, err := k8s.DefaultConfiguration(apiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading Kubernetes collector: %w", err)
+	}
+
+	data, err := collector.
+		New("export", apiConfig, conf, k8sCollector).
+		Collect(context.TODO(), conf.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	return data["k8s_objects"], nil
+}
+
+// loadDump loads the Kubernetes objects from the output of a dry run
+func loadDump(path string) ([]interface{}, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading dump: %w", err)
+	}
+
+	return parseDump(data)
+}
+
+// readInput reads a file, or standard input if the path is "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// parseDump parses the Kubernetes objects from the output of a dry run
+func parseDump(data []byte) ([]interface{}, error) {
+	var dump struct {
+		Objects []k8s.KubernetesObject `json:"k8s_objects"`
+	}
+	err := json.Unmarshal(data, &dump)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing dump: %w", err)
+	}
+
+	objects := make([]interface{}, len(dump.Objects))
+	for i, obj := range dump.Objects {
+		objects[i] = obj
+	}
+
+	return objects, nil
+}
+
+func splitList(str string) []string {
+	var list []string
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -20,6 +20,24 @@ import (
 )
 
 func main() {
+	// The export command builds the object graph without sending anything
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		err := runExport(os.Args[2:])
+		if err != nil {
+			log.Fatal().Err(err).Msg("Export failed")
+		}
+		return
+	}
+
+	// The diff command compares the objects trees of two fetchings
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		err := runDiff(os.Args[2:])
+		if err != nil {
+			log.Fatal().Err(err).Msg("Diff failed")
+		}
+		return
+	}
+
 	// Parse command line flags
 	debug := flag.Bool("debug", false, "sets log level to debug")
 	external := flag.String(